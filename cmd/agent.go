@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"github.com/shubhang93/tplagent/internal/agent"
 	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/events"
+	"github.com/shubhang93/tplagent/internal/health"
 	"github.com/shubhang93/tplagent/internal/httplis"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -29,28 +32,111 @@ func startAgent(ctx context.Context, configFilePath string) error {
 
 }
 
+// agentRef points at the agent started by
+// the latest reload so that the listener can
+// trigger refreshes across reloads
+type agentRef struct {
+	mu   sync.Mutex
+	proc *agent.Proc
+}
+
+func (a *agentRef) set(proc *agent.Proc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.proc = proc
+}
+
+func (a *agentRef) get() (*agent.Proc, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.proc == nil {
+		return nil, errors.New("agent is not running")
+	}
+	return a.proc, nil
+}
+
+func (a *agentRef) TriggerRefresh(templateName string) error {
+	proc, err := a.get()
+	if err != nil {
+		return err
+	}
+	return proc.TriggerRefresh(templateName)
+}
+
+func (a *agentRef) Templates() []string {
+	proc, err := a.get()
+	if err != nil {
+		return []string{}
+	}
+	return proc.Templates()
+}
+
+func (a *agentRef) ReloadTemplate(conf config.TPLAgent, name string) error {
+	proc, err := a.get()
+	if err != nil {
+		return err
+	}
+	return proc.ReloadTemplate(conf, name)
+}
+
+func (a *agentRef) TemplatesMatching(selector map[string]string) []string {
+	proc, err := a.get()
+	if err != nil {
+		return nil
+	}
+	return proc.TemplatesMatching(selector)
+}
+
+func (a *agentRef) Health() ([]health.Template, error) {
+	proc, err := a.get()
+	if err != nil {
+		return nil, err
+	}
+	return proc.Health(), nil
+}
+
+func (a *agentRef) ForceRefresh(templateName string) error {
+	proc, err := a.get()
+	if err != nil {
+		return err
+	}
+	return proc.ForceRefresh(templateName)
+}
+
 func spawnAndReload(rootCtx context.Context, configPath string) error {
+	ref := &agentRef{}
+	// outlives reloads so that event
+	// streams see every agent
+	bus := events.NewBus()
 	starters := procStarters{
 		listener: func(ctx context.Context, conf config.TPLAgent, reload bool) error {
 			if conf.Agent.HTTPListenerAddr != "" {
 				logFmt := conf.Agent.LogFmt
 				level := conf.Agent.LogLevel
 				s := httplis.Proc{
-					Logger:   newLogger(logFmt, level).WithGroup("http-lis"),
-					Reloaded: reload,
+					Logger:     newLogger(logFmt, level).WithGroup("http-lis"),
+					Reloaded:   reload,
+					Agent:      ref,
+					Listener:   conf.Agent.Listener,
+					ConfigPath: configPath,
+					Events:     bus,
+					Hooks:      conf.Agent.Hooks,
 				}
-				s.Start(ctx, conf.Agent.HTTPListenerAddr)
+				return s.Start(ctx, conf.Agent.HTTPListenerAddr)
 			}
 			return nil
 		},
 		agent: func(ctx context.Context, conf config.TPLAgent, reload bool) error {
 			logFmt := conf.Agent.LogFmt
 			level := conf.Agent.LogLevel
-			proc := agent.Proc{
-				Logger:   newLogger(logFmt, level).WithGroup("agent"),
-				TickFunc: agent.RenderAndExec,
-				Reloaded: reload,
+			proc := &agent.Proc{
+				Logger:     newLogger(logFmt, level).WithGroup("agent"),
+				TickFunc:   agent.RenderAndExec,
+				Reloaded:   reload,
+				Events:     bus,
+				ConfigPath: configPath,
 			}
+			ref.set(proc)
 			return proc.Start(ctx, conf)
 		},
 	}
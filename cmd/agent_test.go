@@ -56,7 +56,7 @@ func Test_reloadProcs(t *testing.T) {
 					t.Error(err)
 					return
 				}
-				err = config.WriteTo(f, 1, 1)
+				err = config.WriteTo(f, 1, 1, "json")
 				if err != nil {
 					t.Error(err)
 					return
@@ -117,6 +117,41 @@ func Test_reloadProcs(t *testing.T) {
 		}
 
 	})
+
+	t.Run("listener error", func(t *testing.T) {
+		cfgFile := t.TempDir() + "/config.json"
+		f, err := os.Create(cfgFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = config.WriteTo(f, 1, 1, "json")
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		listenErr := errors.New("address in use")
+		ps := procStarters{
+			listener: func(ctx context.Context, conf config.TPLAgent, reload bool) error {
+				return listenErr
+			},
+			agent: func(ctx context.Context, conf config.TPLAgent, reload bool) error {
+				<-ctx.Done()
+				return nil
+			},
+		}
+
+		err = reloadProcs(ctx, cfgFile, ps)
+		if !fatal.Is(err) || !errors.Is(err, listenErr) {
+			t.Errorf("expected a fatal listener error got %v", err)
+		}
+		if ctx.Err() != nil {
+			t.Error("agent was not stopped after the listener error")
+		}
+	})
 }
 
 var makeConfig = func(suffix string, tmpDir string) config.TPLAgent {
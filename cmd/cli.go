@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/shubhang93/tplagent/internal/config"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -20,9 +22,45 @@ const usage = `usage:
   tplagent start -config=/path/to/config.json
     -config: specifies the path to read the config file from (default /etc/tplagent/config.json)
 
+  tplagent trigger -config=/path/to/config.json [-force] <template-name>
+    -config: specifies the path to read the listener address from (default /etc/tplagent/config.json)
+    -force:  pushes the render through even if it is refused by the template guard
+
+  tplagent reload -config=/path/to/config.json
+    -config: specifies the path to read the listener address from (default /etc/tplagent/config.json)
+    signals the agent PID when the http listener is not enabled
+
+  tplagent status -config=/path/to/config.json
+    -config: specifies the path to read the listener address from (default /etc/tplagent/config.json)
+
+  tplagent stop -config=/path/to/config.json
+    -config: specifies the path to read the listener address from (default /etc/tplagent/config.json)
+
+  tplagent tail -config=/path/to/config.json [-template name] [-type render_error,exec_result] [-json]
+    -config:   specifies the path to read the listener address from (default /etc/tplagent/config.json)
+    -template: only show events of these comma separated templates
+    -type:     only show events of these comma separated types
+    -json:     print the events as JSON lines
+
+  trigger, reload, status, stop and tail accept the credentials of a secured listener
+    -token-file: bearer token, defaults to the highest scoped readable token of the listener
+    -ca-file:    verifies the listener certificate, defaults to the tls_cert_file of the listener
+    -cert-file:  client certificate for listeners with a client_ca_file
+    -key-file:   key of the client certificate
+
+  tplagent exec -config=/path/to/config.json -- <command> [args...]
+    -config: specifies the path to read the config file from (default /etc/tplagent/config.json)
+    renders all the templates and runs the command as a supervised child
+
   tplagent genconf -n 1 -indent 4 > path/to/config.json
     -n:      number of template blocks to generate (default 1)
     -indent: indentation space in the generated config (default 2)
+    -format: json, yaml, toml or hcl (default json)
+
+  tplagent convert -from json -to toml [path/to/config.json] > path/to/config.toml
+    -from: format of the config, picked from the file extension if a path is given (default json)
+    -to:   format to write the config in
+    reads the config from stdin if no path is given
 	
   tplagent version
 `
@@ -40,6 +78,43 @@ func startCLI(ctx context.Context, stdout io.Writer, args ...string) error {
 	genConfCmd := flag.NewFlagSet("genconf", flag.ExitOnError)
 	numBlocks := genConfCmd.Int("n", 1, "-n 2")
 	indent := genConfCmd.Int("indent", 2, "-indent 2")
+	genConfFormat := genConfCmd.String("format", "json", "-format yaml")
+
+	convertCmd := flag.NewFlagSet("convert", flag.ExitOnError)
+	convertFrom := convertCmd.String("from", "", "-from json")
+	convertTo := convertCmd.String("to", "", "-to toml")
+
+	triggerCmd := flag.NewFlagSet("trigger", flag.ExitOnError)
+	triggerConfigPath := triggerCmd.String("config", defaultConfigPath, "-config /path/to/config.json")
+	var triggerCreds controlCreds
+	triggerCreds.register(triggerCmd)
+	force := triggerCmd.Bool("force", false, "-force")
+
+	reloadCmd := flag.NewFlagSet("reload", flag.ExitOnError)
+	reloadConfigPath := reloadCmd.String("config", defaultConfigPath, "-config /path/to/config.json")
+	var reloadCreds controlCreds
+	reloadCreds.register(reloadCmd)
+
+	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+	statusConfigPath := statusCmd.String("config", defaultConfigPath, "-config /path/to/config.json")
+	var statusCreds controlCreds
+	statusCreds.register(statusCmd)
+
+	stopCmd := flag.NewFlagSet("stop", flag.ExitOnError)
+	stopConfigPath := stopCmd.String("config", defaultConfigPath, "-config /path/to/config.json")
+	var stopCreds controlCreds
+	stopCreds.register(stopCmd)
+
+	tailCmd := flag.NewFlagSet("tail", flag.ExitOnError)
+	tailConfigPath := tailCmd.String("config", defaultConfigPath, "-config /path/to/config.json")
+	var tailCreds controlCreds
+	tailCreds.register(tailCmd)
+	tailTemplates := tailCmd.String("template", "", "-template nginx-conf")
+	tailTypes := tailCmd.String("type", "", "-type render_error,exec_result")
+	tailJSON := tailCmd.Bool("json", false, "-json")
+
+	execCmd := flag.NewFlagSet("exec", flag.ExitOnError)
+	execConfigPath := execCmd.String("config", defaultConfigPath, "-config /path/to/config.json")
 
 	cmd := args[0]
 	args = args[1:]
@@ -69,19 +144,133 @@ Go Runtime: %s`
 			*indent = 2
 		}
 
-		err = config.WriteTo(stdout, *numBlocks, *indent)
+		err = config.WriteTo(stdout, *numBlocks, *indent, *genConfFormat)
+		if err != nil {
+			return err
+		}
+	case "convert":
+		err := convertCmd.Parse(args)
 		if err != nil {
 			return err
 		}
+		if *convertTo == "" || convertCmd.NArg() > 1 {
+			return errors.New(usage)
+		}
+		return convert(stdout, convertCmd.Arg(0), *convertFrom, *convertTo)
 	case "reload":
-		pidFilePath := filepath.Join(pidDir, pidFilename)
-		return reload(pidFilePath)
+		err := reloadCmd.Parse(args)
+		if err != nil {
+			return err
+		}
+		return reloadAgent(stdout, *reloadConfigPath, reloadCreds)
+	case "status":
+		err := statusCmd.Parse(args)
+		if err != nil {
+			return err
+		}
+		return writeControlResponse(stdout, *statusConfigPath, statusCreds, http.MethodGet, "/status")
+	case "stop":
+		err := stopCmd.Parse(args)
+		if err != nil {
+			return err
+		}
+		return writeControlResponse(stdout, *stopConfigPath, stopCreds, http.MethodPost, "/agent/stop")
+	case "trigger":
+		err := triggerCmd.Parse(args)
+		if err != nil {
+			return err
+		}
+		if triggerCmd.NArg() != 1 {
+			return errors.New(usage)
+		}
+		return trigger(stdout, *triggerConfigPath, triggerCreds, triggerCmd.Arg(0), *force)
+	case "tail":
+		err := tailCmd.Parse(args)
+		if err != nil {
+			return err
+		}
+		query := url.Values{}
+		if *tailTemplates != "" {
+			query.Set("template", *tailTemplates)
+		}
+		if *tailTypes != "" {
+			query.Set("type", *tailTypes)
+		}
+		return tail(ctx, stdout, *tailConfigPath, tailCreds, query, *tailJSON)
+	case "exec":
+		err := execCmd.Parse(args)
+		if err != nil {
+			return err
+		}
+		if execCmd.NArg() < 1 {
+			return errors.New(usage)
+		}
+		return execChild(ctx, *execConfigPath, execCmd.Args())
 	default:
 		return errors.New(usage)
 	}
 	return nil
 }
 
+// convert reads the config at path or
+// stdin and writes it in format to
+func convert(stdout io.Writer, path string, from string, to string) error {
+	var in io.Reader = os.Stdin
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+		if from == "" {
+			from = config.FormatFromPath(path)
+		}
+	}
+	if from == "" {
+		from = "json"
+	}
+
+	c, err := config.Read(in, from)
+	if err != nil {
+		return err
+	}
+	return config.Encode(stdout, c, to)
+}
+
+func trigger(stdout io.Writer, configPath string, creds controlCreds, templateName string, force bool) error {
+	triggerPath := fmt.Sprintf("/templates/%s/trigger", url.PathEscape(templateName))
+	if force {
+		triggerPath += "?force=true"
+	}
+	return writeControlResponse(stdout, configPath, creds, http.MethodPost, triggerPath)
+}
+
+func writeControlResponse(stdout io.Writer, configPath string, creds controlCreds, method string, path string) error {
+	body, err := controlRequest(configPath, creds, method, path)
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(body)
+	return err
+}
+
+// reloadAgent reloads via the http listener and falls back
+// to signalling the agent PID if the config cannot be read
+// or the listener is not enabled
+func reloadAgent(stdout io.Writer, configPath string, creds controlCreds) error {
+	conf, err := config.ReadFromFile(configPath)
+	if err != nil || conf.Agent.HTTPListenerAddr == "" {
+		return reload(filepath.Join(pidDir, pidFilename))
+	}
+	body, err := controlRequestConf(conf, creds, http.MethodPost, "/config/reload")
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(body)
+	return err
+}
+
 func reload(pidFilePath string) error {
 	contents, err := os.ReadFile(pidFilePath)
 	if err != nil {
@@ -4,15 +4,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/shubhang93/tplagent/internal/config"
 	"github.com/shubhang93/tplagent/internal/duration"
 	"github.com/shubhang93/tplagent/internal/fatal"
 	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"testing"
@@ -86,6 +92,33 @@ func Test_cli(t *testing.T) {
 
 	})
 
+	t.Run("test generate yaml", func(t *testing.T) {
+		jsonOut := bytes.Buffer{}
+		yamlOut := bytes.Buffer{}
+		if err := startCLI(context.Background(), &jsonOut, "genconf", "-n", "2"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := startCLI(context.Background(), &yamlOut, "genconf", "-n", "2", "-format", "yaml"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		fromJSON, err := config.Read(&jsonOut, "json")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fromYAML, err := config.Read(&yamlOut, "yaml")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if diff := cmp.Diff(fromJSON, fromYAML, cmpopts.EquateEmpty(), cmpopts.IgnoreUnexported(config.RawMessage{})); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
 	t.Run("test generate when num block is less than 1 and indent is less than 1", func(t *testing.T) {
 		stdout := bytes.Buffer{}
 		expected := bytes.Buffer{}
@@ -209,6 +242,236 @@ written from exec
 
 	})
 
+	t.Run("test convert", func(t *testing.T) {
+		tmp := t.TempDir()
+		jsonPath := filepath.Join(tmp, "config.json")
+		f, err := os.Create(jsonPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := config.WriteTo(f, 2, 2, "json"); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+
+		path := jsonPath
+		for _, to := range []string{"toml", "hcl", "yaml"} {
+			var out bytes.Buffer
+			if err := startCLI(context.Background(), &out, "convert", "-to", to, path); err != nil {
+				t.Fatalf("convert to %s:%v", to, err)
+			}
+			path = filepath.Join(tmp, "config."+to)
+			if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected, err := config.ReadFromFile(jsonPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := config.ReadFromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, got, cmpopts.EquateEmpty(), cmpopts.IgnoreUnexported(config.RawMessage{})); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
+	t.Run("test trigger", func(t *testing.T) {
+		var gotPath string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.String()
+			_, _ = w.Write([]byte(`{"success":true}`))
+		}))
+		defer srv.Close()
+
+		configPath := t.TempDir() + "/config.json"
+		ac := config.TPLAgent{
+			Agent: config.Agent{
+				LogLevel:         slog.LevelInfo,
+				LogFmt:           "text",
+				HTTPListenerAddr: strings.TrimPrefix(srv.URL, "http://"),
+			},
+			TemplateSpecs: map[string]*config.TemplateSpec{
+				"test-config": {Raw: "hello", Destination: "/tmp/test.render"},
+			},
+		}
+		bs, err := json.Marshal(ac)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := os.WriteFile(configPath, bs, 0755); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var stdout bytes.Buffer
+		err = startCLI(context.Background(), &stdout, "trigger", "-config", configPath, "-force", "test-config")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if expected := "/templates/test-config/trigger?force=true"; gotPath != expected {
+			t.Errorf("expected path %s got %s", expected, gotPath)
+		}
+	})
+
+	t.Run("test status and stop over unix socket", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		sock := tmpDir + "/tplagent.sock"
+		ln, err := net.Listen("unix", sock)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var gotRequests []string
+		srv := http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotRequests = append(gotRequests, r.Method+" "+r.URL.Path)
+			_, _ = w.Write([]byte(`{"success":true}`))
+		})}
+		go func() { _ = srv.Serve(ln) }()
+		defer srv.Close()
+
+		configPath := tmpDir + "/config.json"
+		ac := config.TPLAgent{
+			Agent: config.Agent{
+				LogLevel:         slog.LevelInfo,
+				LogFmt:           "text",
+				HTTPListenerAddr: "unix://" + sock,
+			},
+			TemplateSpecs: map[string]*config.TemplateSpec{
+				"test-config": {Raw: "hello", Destination: "/tmp/test.render"},
+			},
+		}
+		bs, err := json.Marshal(ac)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := os.WriteFile(configPath, bs, 0755); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for _, cmd := range []string{"status", "stop", "reload"} {
+			var stdout bytes.Buffer
+			if err := startCLI(context.Background(), &stdout, cmd, "-config", configPath); err != nil {
+				t.Errorf("%s error:%v", cmd, err)
+				return
+			}
+		}
+
+		expected := []string{"GET /status", "POST /agent/stop", "POST /config/reload"}
+		if diff := cmp.Diff(expected, gotRequests); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
+	t.Run("test tail", func(t *testing.T) {
+		var gotQuery string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotQuery = r.URL.RawQuery
+			_, _ = w.Write([]byte(`{"type":"render_error","time":"2026-01-02T03:04:05Z","template":"app","error":"boom"}` + "\n"))
+		}))
+		defer srv.Close()
+
+		configPath := t.TempDir() + "/config.json"
+		ac := config.TPLAgent{
+			Agent: config.Agent{
+				LogLevel:         slog.LevelInfo,
+				LogFmt:           "text",
+				HTTPListenerAddr: strings.TrimPrefix(srv.URL, "http://"),
+			},
+			TemplateSpecs: map[string]*config.TemplateSpec{
+				"app": {Raw: "hello", Destination: "/tmp/test.render"},
+			},
+		}
+		bs, err := json.Marshal(ac)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := os.WriteFile(configPath, bs, 0755); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// the stream is reopened until
+		// the context is done
+		ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+		defer cancel()
+		var stdout bytes.Buffer
+		err = startCLI(ctx, &stdout, "tail", "-config", configPath, "-template", "app", "-type", "render_error")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if expected := "template=app&type=render_error"; gotQuery != expected {
+			t.Errorf("expected query %s got %s", expected, gotQuery)
+		}
+		line := `2026-01-02T03:04:05Z render_error templ=app error="boom"` + "\n"
+		if diff := cmp.Diff(line+line, stdout.String()); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
+	t.Run("test exec", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := tmpDir + "/config.json"
+		envFile := tmpDir + "/app.env"
+		out := tmpDir + "/child.out"
+		ac := config.TPLAgent{
+			Agent: config.Agent{
+				LogLevel:  slog.LevelInfo,
+				LogFmt:    "text",
+				Supervise: &config.SuperviseSpec{EnvFile: envFile},
+			},
+			TemplateSpecs: map[string]*config.TemplateSpec{
+				"app-env": {
+					Raw:             `GREETING={{.greeting}}`,
+					Destination:     envFile,
+					StaticData:      map[string]string{"greeting": "hello"},
+					RefreshInterval: duration.Duration(time.Minute),
+					MissingKey:      "error",
+				},
+			},
+		}
+		bs, err := json.Marshal(ac)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := os.WriteFile(configPath, bs, 0755); err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		child := fmt.Sprintf(`echo "$GREETING" > %s; exit 3`, out)
+		err = startCLI(ctx, os.Stdout, "exec", "-config", configPath, "--", "sh", "-c", child)
+		var exitCode exitCodeErr
+		if !errors.As(err, &exitCode) || exitCode != 3 {
+			t.Errorf("expected child exit code 3 got %v", err)
+			return
+		}
+
+		d, err := os.ReadFile(out)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if diff := cmp.Diff("hello\n", string(d)); diff != "" {
+			t.Error(diff)
+		}
+	})
+
 	t.Run("test reload", func(t *testing.T) {
 		tmp := t.TempDir()
 		sighup, cancel := signal.NotifyContext(context.Background(), syscall.SIGHUP)
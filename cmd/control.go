@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/events"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// controlCreds authenticate the cli with a
+// listener secured by the listener block
+type controlCreds struct {
+	tokenFile string
+	caFile    string
+	certFile  string
+	keyFile   string
+}
+
+func (c *controlCreds) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.tokenFile, "token-file", "", "-token-file /etc/tplagent/admin.token")
+	fs.StringVar(&c.caFile, "ca-file", "", "-ca-file /etc/tplagent/ca.crt")
+	fs.StringVar(&c.certFile, "cert-file", "", "-cert-file /etc/tplagent/client.crt")
+	fs.StringVar(&c.keyFile, "key-file", "", "-key-file /etc/tplagent/client.key")
+}
+
+// controlConn reaches the http listener
+// and authenticates every request
+type controlConn struct {
+	client  *http.Client
+	baseURL string
+	token   string
+}
+
+// dialControl returns a conn to the http listener of conf,
+// unix:// addresses are dialed over the unix socket, https is
+// used when the listener has tls and the token defaults to the
+// highest scoped token of the listener readable by the caller
+func dialControl(conf config.TPLAgent, creds controlCreds) (*controlConn, error) {
+	addr := conf.Agent.HTTPListenerAddr
+	if addr == "" {
+		return nil, errors.New("the http listener is not enabled")
+	}
+	spec := conf.Agent.Listener
+
+	token, err := controlToken(spec, creds.tokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	scheme, host := "http", addr
+	path, unixSocket := config.SocketPath(addr)
+	if unixSocket {
+		dialer := net.Dialer{Timeout: 5 * time.Second}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		// the host is ignored when dialing a socket
+		host = "tplagent"
+	}
+
+	if spec != nil && spec.TLSCertFile != "" {
+		scheme = "https"
+		transport.TLSClientConfig, err = controlTLS(spec, creds, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &controlConn{
+		client:  &http.Client{Transport: transport},
+		baseURL: scheme + "://" + host,
+		token:   token,
+	}, nil
+}
+
+func controlToken(spec *config.ListenerSpec, tokenFile string) (string, error) {
+	if tokenFile == "" && spec != nil {
+		rank := map[string]int{config.ScopeRead: 1, config.ScopeTrigger: 2, config.ScopeAdmin: 3}
+		best := 0
+		for _, t := range spec.Tokens {
+			if rank[t.Scope] <= best {
+				continue
+			}
+			if _, err := os.Stat(os.ExpandEnv(t.File)); err == nil {
+				tokenFile, best = t.File, rank[t.Scope]
+			}
+		}
+	}
+	if tokenFile == "" {
+		return "", nil
+	}
+	contents, err := os.ReadFile(os.ExpandEnv(tokenFile))
+	if err != nil {
+		return "", fmt.Errorf("read token file:%w", err)
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// controlTLS verifies the listener with the ca file,
+// the listener certificate is trusted by default
+func controlTLS(spec *config.ListenerSpec, creds controlCreds, addr string) (*tls.Config, error) {
+	caFile := cmp.Or(creds.caFile, spec.TLSCertFile)
+	caPEM, err := os.ReadFile(os.ExpandEnv(caFile))
+	if err != nil {
+		return nil, fmt.Errorf("read ca file:%w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	serverName := "localhost"
+	if _, ok := config.SocketPath(addr); !ok {
+		if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+			serverName = host
+		}
+	}
+	conf := &tls.Config{RootCAs: pool, ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if creds.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(os.ExpandEnv(creds.certFile), os.ExpandEnv(creds.keyFile))
+		if err != nil {
+			return nil, fmt.Errorf("load client key pair:%w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func (c *controlConn) newRequest(ctx context.Context, method string, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// controlRequest calls an endpoint of the http
+// listener configured in the config at configPath
+func controlRequest(configPath string, creds controlCreds, method string, path string) ([]byte, error) {
+	conf, err := config.ReadFromFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return controlRequestConf(conf, creds, method, path)
+}
+
+func controlRequestConf(conf config.TPLAgent, creds controlCreds, method string, path string) ([]byte, error) {
+	conn, err := dialControl(conf, creds)
+	if err != nil {
+		return nil, err
+	}
+	req, err := conn.newRequest(context.Background(), method, path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := conn.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s request failed:%w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s failed with status %d:%s", method, path, resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+const tailReconnectDelay = time.Second
+
+// tail prints the event stream of the agent, the
+// stream is reopened when the listener restarts
+// on reload until ctx is done
+func tail(ctx context.Context, stdout io.Writer, configPath string, creds controlCreds, query url.Values, raw bool) error {
+	conf, err := config.ReadFromFile(configPath)
+	if err != nil {
+		return err
+	}
+	conn, err := dialControl(conf, creds)
+	if err != nil {
+		return err
+	}
+	eventsPath := "/events?" + query.Encode()
+
+	connected := false
+	for {
+		err := streamEvents(ctx, conn, eventsPath, stdout, raw, func() { connected = true })
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !connected {
+			// never reached the agent
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(tailReconnectDelay):
+		}
+	}
+}
+
+func streamEvents(ctx context.Context, conn *controlConn, eventsPath string, stdout io.Writer, raw bool, onConnect func()) error {
+	req, err := conn.newRequest(ctx, http.MethodGet, eventsPath)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := conn.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET /events request failed:%w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("GET /events failed with status %d:%s", resp.StatusCode, body)
+	}
+	onConnect()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if raw {
+			_, err = fmt.Fprintf(stdout, "%s\n", line)
+		} else {
+			err = printEvent(stdout, line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func printEvent(stdout io.Writer, line []byte) error {
+	var e events.Event
+	if err := json.Unmarshal(line, &e); err != nil {
+		return fmt.Errorf("invalid event:%w", err)
+	}
+	var sb strings.Builder
+	sb.WriteString(e.Time.Format(time.RFC3339))
+	sb.WriteString(" ")
+	sb.WriteString(string(e.Type))
+	if e.Template != "" {
+		sb.WriteString(" templ=" + e.Template)
+	}
+	if e.Action != "" {
+		sb.WriteString(" action=" + e.Action)
+	}
+	if e.Error != "" {
+		sb.WriteString(" error=" + strconv.Quote(e.Error))
+	}
+	_, err := fmt.Fprintln(stdout, sb.String())
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shubhang93/tplagent/internal/config"
+)
+
+func Test_controlRequestConf(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer admin-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{"read.token": "read-secret", "admin.token": "admin-secret\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := config.TPLAgent{Agent: config.Agent{
+		HTTPListenerAddr: srv.Listener.Addr().String(),
+		Listener: &config.ListenerSpec{
+			TLSCertFile: certFile,
+			TLSKeyFile:  filepath.Join(dir, "tls.key"),
+			Tokens: []config.TokenSpec{
+				{File: filepath.Join(dir, "read.token"), Scope: config.ScopeRead},
+				{File: filepath.Join(dir, "admin.token"), Scope: config.ScopeAdmin},
+				{File: filepath.Join(dir, "missing.token"), Scope: config.ScopeAdmin},
+			},
+		},
+	}}
+
+	body, err := controlRequestConf(conf, controlCreds{}, http.MethodGet, "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"success":true}` {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	creds := controlCreds{tokenFile: filepath.Join(dir, "read.token"), caFile: certFile}
+	if _, err := controlRequestConf(conf, creds, http.MethodGet, "/status"); err == nil {
+		t.Error("expected the token flag to override the config tokens")
+	}
+}
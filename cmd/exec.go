@@ -0,0 +1,279 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/shubhang93/tplagent/internal/agent"
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/events"
+	"github.com/shubhang93/tplagent/internal/fatal"
+	"github.com/shubhang93/tplagent/internal/httplis"
+	"github.com/shubhang93/tplagent/internal/signame"
+	"github.com/shubhang93/tplagent/internal/supervise"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+}
+
+// exitCodeErr makes tplagent exit
+// with the exit code of the child
+type exitCodeErr int
+
+func (e exitCodeErr) Error() string {
+	return fmt.Sprintf("child exited with status %d", int(e))
+}
+
+// execChild renders all the templates, then runs command
+// as a supervised child until it exits, the agent keeps
+// refreshing the templates while the child is running
+func execChild(ctx context.Context, configPath string, command []string) error {
+	conf, err := config.ReadFromFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	logger := newLogger(conf.Agent.LogFmt, conf.Agent.LogLevel)
+	child, err := newChild(conf.Agent.Supervise, command, logger.WithGroup("child"))
+	if err != nil {
+		return err
+	}
+
+	// SIGINT and SIGTERM are forwarded to the
+	// child, tplagent exits once the child does
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	agentCtx, stopAgent := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopAgent()
+
+	tracker := newRenderTracker(conf, child)
+	ref := &agentRef{}
+	bus := events.NewBus()
+	proc := &agent.Proc{
+		Logger:        logger.WithGroup("agent"),
+		TickFunc:      agent.RenderAndExec,
+		RenderOnStart: true,
+		RenderHook:    tracker.rendered,
+		RenderErrHook: tracker.failed,
+		Events:        bus,
+		ConfigPath:    configPath,
+	}
+	ref.set(proc)
+
+	var wg sync.WaitGroup
+	listenerErrCh := make(chan error, 1)
+	if conf.Agent.HTTPListenerAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := httplis.Proc{
+				Logger:     logger.WithGroup("http-lis"),
+				Agent:      ref,
+				Listener:   conf.Agent.Listener,
+				ConfigPath: configPath,
+				Events:     bus,
+				Hooks:      conf.Agent.Hooks,
+			}
+			if err := s.Start(agentCtx, conf.Agent.HTTPListenerAddr); err != nil {
+				listenerErrCh <- fatal.NewError(fmt.Errorf("http listener:%w", err))
+			}
+		}()
+	}
+	defer wg.Wait()
+
+	agentErrCh := make(chan error, 1)
+	go func() {
+		agentErrCh <- proc.Start(agentCtx, conf)
+	}()
+
+	select {
+	case <-tracker.ready:
+		if tracker.err != nil {
+			stopAgent()
+			<-agentErrCh
+			return tracker.err
+		}
+	case err := <-agentErrCh:
+		return cmp.Or(err, errors.New("agent stopped before rendering all templates"))
+	case err := <-listenerErrCh:
+		stopAgent()
+		<-agentErrCh
+		return err
+	case <-ctx.Done():
+		stopAgent()
+		<-agentErrCh
+		return ctx.Err()
+	}
+
+	childCtx, stopChild := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer stopChild(nil)
+
+	agentDone := make(chan struct{})
+	var agentErr error
+	go func() {
+		defer close(agentDone)
+		agentErr = <-agentErrCh
+		if fatal.Is(agentErr) {
+			logger.Error("agent stopped, stopping child", slog.String("error", agentErr.Error()))
+			stopChild(agentErr)
+		}
+	}()
+	go func() {
+		select {
+		case err := <-listenerErrCh:
+			logger.Error("http listener stopped, stopping child", slog.String("error", err.Error()))
+			stopChild(err)
+		case <-childCtx.Done():
+		}
+	}()
+
+	code, err := child.Run(childCtx, sigs)
+	stopAgent()
+	<-agentDone
+
+	if err != nil {
+		return err
+	}
+	if cause := context.Cause(childCtx); fatal.Is(cause) {
+		return cause
+	}
+	if code != 0 {
+		return exitCodeErr(code)
+	}
+	return nil
+}
+
+func newChild(spec *config.SuperviseSpec, command []string, logger *slog.Logger) (*supervise.Child, error) {
+	if spec == nil {
+		spec = &config.SuperviseSpec{}
+	}
+
+	child := &supervise.Child{
+		Cmd:         command[0],
+		Args:        command[1:],
+		EnvFile:     os.ExpandEnv(spec.EnvFile),
+		KillTimeout: time.Duration(spec.KillTimeout),
+		Stdin:       os.Stdin,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+		Logger:      logger,
+	}
+
+	if spec.OnChange != config.OnChangeRestart {
+		sig, err := signame.Parse(cmp.Or(spec.OnChange, "SIGHUP"))
+		if err != nil {
+			return nil, err
+		}
+		child.ChangeSignal = sig
+	}
+
+	if spec.KillSignal != "" {
+		sig, err := signame.Parse(spec.KillSignal)
+		if err != nil {
+			return nil, err
+		}
+		child.KillSignal = sig
+	}
+	return child, nil
+}
+
+// renderTracker waits for the first render of
+// every template before the child is started,
+// later changes of the watched templates are
+// propagated to the child
+type renderTracker struct {
+	mu      sync.Mutex
+	pending map[string]struct{}
+	ready   chan struct{}
+	// err is set before ready is closed if
+	// the first render of a template failed
+	err     error
+	child   *supervise.Child
+	watched map[string]bool
+	// a change to the env file can
+	// only be applied by a restart
+	restartOn map[string]bool
+}
+
+func newRenderTracker(conf config.TPLAgent, child *supervise.Child) *renderTracker {
+	spec := conf.Agent.Supervise
+	if spec == nil {
+		spec = &config.SuperviseSpec{}
+	}
+
+	t := &renderTracker{
+		pending:   make(map[string]struct{}, len(conf.TemplateSpecs)),
+		ready:     make(chan struct{}),
+		child:     child,
+		watched:   make(map[string]bool, len(conf.TemplateSpecs)),
+		restartOn: make(map[string]bool),
+	}
+
+	for name, tmpl := range conf.TemplateSpecs {
+		t.pending[name] = struct{}{}
+		t.watched[name] = len(spec.Templates) == 0
+		dest := filepath.Clean(os.ExpandEnv(tmpl.Destination))
+		if tmpl.Destination != "" && child.EnvFile != "" && dest == filepath.Clean(child.EnvFile) {
+			t.restartOn[name] = true
+		}
+	}
+	for _, name := range spec.Templates {
+		t.watched[name] = true
+	}
+
+	if len(t.pending) == 0 {
+		close(t.ready)
+	}
+	return t
+}
+
+func (t *renderTracker) rendered(name string, changed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return
+	}
+	if len(t.pending) > 0 {
+		delete(t.pending, name)
+		if len(t.pending) == 0 {
+			close(t.ready)
+		}
+		return
+	}
+
+	if changed && (t.watched[name] || t.restartOn[name]) {
+		t.child.Changed(t.restartOn[name])
+	}
+}
+
+// failed settles the tracker with the error
+// of a failed first render, the child is
+// never started
+func (t *renderTracker) failed(name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.pending[name]; !ok || t.err != nil {
+		return
+	}
+	t.err = fmt.Errorf("first render of template %s failed:%w", name, err)
+	close(t.ready)
+}
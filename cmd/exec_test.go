@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/duration"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_execChild_firstRenderFails(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := config.TPLAgent{
+		Agent: config.Agent{
+			LogLevel:               slog.LevelError,
+			LogFmt:                 "text",
+			MaxConsecutiveFailures: 10,
+		},
+		TemplateSpecs: map[string]*config.TemplateSpec{
+			"app-conf": {
+				Raw:             `{{.Missing}}`,
+				Destination:     tmp + "/app.conf",
+				RefreshInterval: duration.Duration(time.Second),
+				MissingKey:      "error",
+			},
+		},
+	}
+	data, err := json.Marshal(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := tmp + "/agent-config.json"
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	started := tmp + "/started"
+	err = execChild(ctx, configPath, []string{"touch", started})
+	if err == nil || !strings.Contains(err.Error(), "first render of template app-conf failed") {
+		t.Fatalf("expected first render error got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("exec did not stop after the failed render")
+	}
+	if _, err := os.Stat(started); !os.IsNotExist(err) {
+		t.Errorf("expected the child not to start got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/shubhang93/tplagent/internal/config"
+	"log/slog"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const fragmentPollInterval = 2 * time.Second
+
+// watchFragments polls the include_dirs of conf and
+// asks the reload loop to reload once the fragments
+// change and the merged config is valid
+func watchFragments(ctx context.Context, configPath string, conf config.TPLAgent, interval time.Duration, reload chan<- os.Signal, logger *slog.Logger) {
+	if len(conf.Agent.IncludeDirs) == 0 {
+		return
+	}
+	expandedPath := os.ExpandEnv(configPath)
+	last := fragmentsFingerprint(conf, expandedPath)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := fragmentsFingerprint(conf, expandedPath)
+		if current == last {
+			continue
+		}
+		last = current
+
+		if _, err := config.ReadFromFile(configPath); err != nil {
+			logger.Error("config fragments changed, skipping reload", slog.String("error", err.Error()))
+			continue
+		}
+		logger.Info("config fragments changed, reloading")
+		select {
+		case reload <- syscall.SIGHUP:
+		default:
+			// a reload is already pending
+		}
+	}
+}
+
+// fragmentsFingerprint describes the fragment
+// files by their name, size and mod time
+func fragmentsFingerprint(conf config.TPLAgent, configPath string) string {
+	files, err := config.FragmentFiles(conf, configPath)
+	if err != nil {
+		return err.Error()
+	}
+	var sb strings.Builder
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			_, _ = fmt.Fprintf(&sb, "%s:%s\n", file, err)
+			continue
+		}
+		_, _ = fmt.Fprintf(&sb, "%s:%d:%d\n", file, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return sb.String()
+}
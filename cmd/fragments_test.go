@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shubhang93/tplagent/internal/config"
+)
+
+func Test_watchFragments(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	fragDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(fragDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mainConf := `{"agent":{"log_fmt":"text","include_dirs":["conf.d"]},"templates":{}}`
+	if err := os.WriteFile(configPath, []byte(mainConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := config.ReadFromFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reload := make(chan os.Signal, 1)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	go watchFragments(ctx, configPath, conf, 10*time.Millisecond, reload, logger)
+
+	expectReload := func(want bool) {
+		t.Helper()
+		select {
+		case <-reload:
+			if !want {
+				t.Error("unexpected reload")
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Error("expected a reload")
+			}
+		}
+	}
+
+	fragPath := filepath.Join(fragDir, "db.json")
+	invalid := `{"templates":{"db-conf":{"raw":"db"}}}`
+	if err := os.WriteFile(fragPath, []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(false)
+
+	valid := `{"templates":{"db-conf":{"raw":"db","destination":"/tmp/db.conf"}}}`
+	if err := os.WriteFile(fragPath, []byte(valid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(true)
+
+	if err := os.Remove(fragPath); err != nil {
+		t.Fatal(err)
+	}
+	expectReload(true)
+}
@@ -13,6 +13,10 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
 	err := startCLI(ctx, os.Stdout, os.Args[1:]...)
+	var exitCode exitCodeErr
+	if errors.As(err, &exitCode) {
+		os.Exit(int(exitCode))
+	}
 	if err != nil && !isCtxErr(err) {
 		_, _ = fmt.Fprintf(os.Stderr, err.Error())
 		os.Exit(1)
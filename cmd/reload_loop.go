@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/shubhang93/tplagent/internal/config"
 	"github.com/shubhang93/tplagent/internal/fatal"
 	"os"
@@ -28,12 +29,19 @@ func reloadProcs(root context.Context, configPath string, starters procStarters)
 		return err
 	}
 
-	serverDone := make(chan struct{}, 1)
-	go launchListener(ctx, starters.listener, conf, false, serverDone)
+	serverErrCh := make(chan error, 1)
+	go launchListener(ctx, starters.listener, conf, false, serverErrCh)
+	// set to nil once the error of the
+	// listener is read, serverErrCh is
+	// closed after the listener exits
+	serverErrs := serverErrCh
 
 	agentErrCh := make(chan error, 1)
 	go launchAgent(ctx, starters.agent, conf, false, agentErrCh)
 
+	logger := newLogger(conf.Agent.LogFmt, conf.Agent.LogLevel).WithGroup("fragments")
+	go watchFragments(ctx, configPath, conf, fragmentPollInterval, sighup, logger)
+
 	for {
 		select {
 		case <-sighup:
@@ -45,12 +53,12 @@ func reloadProcs(root context.Context, configPath string, starters procStarters)
 			if fatal.Is(err) {
 				// agent had a fatal error
 				// exit the loop
-				<-serverDone
+				<-serverErrCh
 				return err
 			}
 
 			// wait for server to exit
-			<-serverDone
+			<-serverErrCh
 
 			// reset all the sync
 			// primitives
@@ -61,25 +69,41 @@ func reloadProcs(root context.Context, configPath string, starters procStarters)
 				return err
 			}
 
-			go launchListener(ctx, starters.listener, conf, true, serverDone)
+			serverErrCh = make(chan error, 1)
+			serverErrs = serverErrCh
+			go launchListener(ctx, starters.listener, conf, true, serverErrCh)
 
 			agentErrCh = make(chan error, 1)
 			go launchAgent(ctx, starters.agent, conf, true, agentErrCh)
+
+			logger := newLogger(conf.Agent.LogFmt, conf.Agent.LogLevel).WithGroup("fragments")
+			go watchFragments(ctx, configPath, conf, fragmentPollInterval, sighup, logger)
 		case err := <-agentErrCh:
 			if fatal.Is(err) {
 				cancel(err)
 				// wait for server and
 				// exit
-				<-serverDone
+				<-serverErrCh
 				return err
 			}
 		// for non-fatal errors
 		// server goroutine
 		// is kept running
 		// to allow reloads
+		case err := <-serverErrs:
+			serverErrs = nil
+			if err != nil {
+				// a listener which failed cannot
+				// serve reloads, the agent is
+				// stopped like on a fatal error
+				err = fatal.NewError(fmt.Errorf("http listener:%w", err))
+				cancel(err)
+				<-agentErrCh
+				return err
+			}
 		case <-ctx.Done():
 			err := <-agentErrCh
-			<-serverDone
+			<-serverErrCh
 			return err
 		}
 	}
@@ -96,7 +120,10 @@ func launchAgent(ctx context.Context, lf launcherFunc, conf config.TPLAgent, rel
 	close(errCh)
 }
 
-func launchListener(ctx context.Context, lf launcherFunc, conf config.TPLAgent, reloaded bool, doneCh chan<- struct{}) {
-	_ = lf(ctx, conf, reloaded)
-	doneCh <- struct{}{}
+func launchListener(ctx context.Context, lf launcherFunc, conf config.TPLAgent, reloaded bool, errCh chan<- error) {
+	errCh <- lf(ctx, conf, reloaded)
+	// closed like the error channel
+	// of the agent so that waiting
+	// after the read returns
+	close(errCh)
 }
@@ -1,6 +1,7 @@
 package actionable
 
 import (
+	"github.com/shubhang93/tplagent/internal/escape"
 	"github.com/shubhang93/tplagent/internal/tplactions"
 	"html/template"
 	"io"
@@ -11,6 +12,7 @@ type Template struct {
 	html          *template.Template
 	text          *texttemp.Template
 	activeActions []tplactions.Interface
+	escapeMode    string
 	Name          string
 }
 
@@ -36,6 +38,18 @@ func (tt *Template) Parse(text string) error {
 	return err
 }
 
+// ParseNamed parses text as a template
+// associated with tt, it can be invoked
+// from tt using {{template "name"}}
+func (tt *Template) ParseNamed(name string, text string) error {
+	if tt.html != nil {
+		_, err := tt.html.New(name).Parse(text)
+		return err
+	}
+	_, err := tt.text.New(name).Parse(text)
+	return err
+}
+
 func (tt *Template) Funcs(actions map[string]any) {
 	if tt.html != nil {
 		tt.html.Funcs(actions)
@@ -62,6 +76,28 @@ func (tt *Template) Execute(writer io.Writer, data any) error {
 	return tt.text.Execute(writer, data)
 }
 
+// SetEscapeMode enables escaping of values
+// for the output format, it is not supported
+// for HTML templates which are always escaped
+func (tt *Template) SetEscapeMode(mode string) {
+	if mode == "" || tt.html != nil {
+		return
+	}
+	tt.escapeMode = mode
+	tt.text.Funcs(escape.Funcs())
+}
+
+// Escape rewrites the parsed templates to escape
+// values written by actions, it must be called after
+// all the templates have been parsed, actions ending
+// with the skip functions are written as is
+func (tt *Template) Escape(skip ...string) error {
+	if tt.escapeMode == "" {
+		return nil
+	}
+	return escape.Rewrite(tt.text, tt.escapeMode, skip...)
+}
+
 func (tt *Template) Delims(l, r string) {
 	if tt.html != nil {
 		tt.html.Delims(l, r)
@@ -74,6 +110,16 @@ func (tt *Template) AddAction(action tplactions.Interface) {
 	tt.activeActions = append(tt.activeActions, action)
 }
 
+func (tt *Template) Watchers() []tplactions.Watcher {
+	var watchers []tplactions.Watcher
+	for _, a := range tt.activeActions {
+		if w, ok := a.(tplactions.Watcher); ok {
+			watchers = append(watchers, w)
+		}
+	}
+	return watchers
+}
+
 func (tt *Template) CloseActions() {
 	for _, a := range tt.activeActions {
 		a.Close()
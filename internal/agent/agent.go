@@ -8,18 +8,27 @@ import (
 	"github.com/shubhang93/tplagent/internal/actionable"
 	"github.com/shubhang93/tplagent/internal/cmdexec"
 	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/events"
 	"github.com/shubhang93/tplagent/internal/fatal"
+	"github.com/shubhang93/tplagent/internal/outformat"
 	"github.com/shubhang93/tplagent/internal/render"
+	"github.com/shubhang93/tplagent/internal/signame"
 	"github.com/shubhang93/tplagent/internal/tplactions"
 	"log/slog"
+	"net/http"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 )
 
 const defaultExecTimeout = 30 * time.Second
 const defaultMaxConsecFailures = 10
+const defaultWebhookTimeout = 10 * time.Second
+const defaultExecGroupDebounce = 500 * time.Millisecond
 
 type CMDExecer interface {
 	ExecContext(ctx context.Context) error
@@ -29,9 +38,26 @@ type Renderer interface {
 	Render(data any) error
 }
 
+// changeReporter is implemented by renderers
+// which describe their last write to the
+// exec hook, see render.Sink
+type changeReporter interface {
+	LastChange() render.Change
+}
+
+// targetReporter is implemented by renderers
+// whose execer does not know the template
+// it runs for, see groupRenderer
+type targetReporter interface {
+	target() (name string, dest string)
+}
+
 type sinkExecConfig struct {
 	sinkConfig
 	*execConfig
+	notify      *cmdexec.Notify
+	webhookSpec *config.WebhookSpec
+	webhook     *cmdexec.Webhook
 }
 
 var errTooManyFailures = errors.New("too many failures")
@@ -51,30 +77,159 @@ type sinkConfig struct {
 	renderOnce       bool
 	raw              string
 	readFrom         string
+	readFromDir      string
+	includes         []string
 	missingKey       string
+	dependsOn        map[string]string
+	multiOutput      bool
+	managedBlock     *render.ManagedBlock
+	outputFormat     string
+	canonicalize     string
+	guardSpec        *config.GuardSpec
+	guard            *render.Guard
+	escape           string
+	execGroup        string
+	labels           map[string]string
+	required         bool
 }
 
 type execConfig struct {
-	cmd     string
-	timeout time.Duration
-	args    []string
-	env     map[string]string
+	cmd         string
+	timeout     time.Duration
+	args        []string
+	env         map[string]string
+	shell       bool
+	dir         string
+	inheritEnv  bool
+	outputLimit int
+	user        string
+	group       string
+	retries     int
+	backoff     time.Duration
+	expand      bool
+}
+
+func makeExecConfig(spec *config.ExecSpec) *execConfig {
+	inheritEnv := true
+	if spec.InheritEnv != nil {
+		inheritEnv = *spec.InheritEnv
+	}
+	return &execConfig{
+		cmd:         spec.Cmd,
+		timeout:     cmp.Or(time.Duration(spec.CmdTimeout), defaultExecTimeout),
+		args:        spec.CmdArgs,
+		env:         spec.Env,
+		shell:       spec.Shell,
+		dir:         os.ExpandEnv(spec.Dir),
+		inheritEnv:  inheritEnv,
+		outputLimit: spec.OutputLimit,
+		user:        spec.User,
+		group:       spec.Group,
+		retries:     spec.Retries,
+		backoff:     time.Duration(spec.RetryBackoff),
+		expand:      spec.ExpandTemplates,
+	}
+}
+
+func (ec *execConfig) execer(name string, dest string, logger *slog.Logger) *cmdexec.Default {
+	return &cmdexec.Default{
+		Args:            ec.args,
+		Cmd:             ec.cmd,
+		Env:             ec.env,
+		Timeout:         ec.timeout,
+		Shell:           ec.shell,
+		Dir:             ec.dir,
+		InheritEnv:      ec.inheritEnv,
+		OutputLimit:     ec.outputLimit,
+		User:            ec.user,
+		Group:           ec.group,
+		ExpandTemplates: ec.expand,
+		Logger:          logger,
+		Template:        name,
+		Destination:     dest,
+	}
+}
+
+type triggerReq struct {
+	force bool
 }
 
 type triggerFlow struct {
-	trigger     chan struct{}
+	trigger     chan triggerReq
 	triggerResp chan error
+	// ctx and done end triggers
+	// waiting on a stopped loop
+	ctx  context.Context
+	done chan struct{}
 }
 type Proc struct {
 	Logger   *slog.Logger
 	TickFunc tickFunc
 	configs  []sinkExecConfig
 	Reloaded bool
+	// RenderOnStart renders every template once
+	// before waiting for its first refresh tick
+	RenderOnStart bool
+	// RenderHook is called after a template renders
+	// successfully, changed is false if the
+	// destination already had the same contents
+	RenderHook func(name string, changed bool)
+	// RenderErrHook is called after a render
+	// of a template fails
+	RenderErrHook func(name string, err error)
+	// Events receives the activity of the
+	// render loops, it can be nil
+	Events *events.Bus
+	// ConfigPath keys the exec pending
+	// files of the agent in the state dir
+	ConfigPath string
 
 	triggerMU       sync.Mutex
 	refreshTriggers map[string]triggerFlow
 
 	maxConsecFailures int
+
+	depsMU          sync.RWMutex
+	renderGates     map[string]*renderGate
+	dependents      map[string][]string
+	upstreamChanged map[string]chan struct{}
+
+	execGroups     map[string]*cmdexec.Group
+	execGroupSpecs map[string]*config.ExecGroupSpec
+	execState      execState
+
+	// loops tracks the running render loops so
+	// that ReloadTemplate can restart one of them
+	loopsMU     sync.Mutex
+	loopsCtx    context.Context
+	loops       map[string]*renderLoop
+	loopErrs    chan error
+	activeLoops int
+	loopsDone   bool
+	reloadMU    sync.Mutex
+
+	healthMU sync.Mutex
+	health   map[string]*loopHealth
+}
+
+type renderLoop struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// renderGate is opened once a template
+// has attempted its first render, templates
+// depending on it wait for the gate to open
+// before starting their render loops
+type renderGate struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (g *renderGate) open() {
+	g.once.Do(func() {
+		close(g.done)
+	})
 }
 
 func (p *Proc) Start(ctx context.Context, config config.TPLAgent) error {
@@ -85,18 +240,79 @@ func (p *Proc) Start(ctx context.Context, config config.TPLAgent) error {
 		p.Logger.Info("agent starting")
 	}
 
+	p.triggerMU.Lock()
 	if p.refreshTriggers == nil {
 		p.refreshTriggers = make(map[string]triggerFlow, len(config.TemplateSpecs))
 	}
+	p.triggerMU.Unlock()
 
 	templConfig := config.TemplateSpecs
 	scs := sanitizeConfigs(templConfig)
+	addGlobalIncludes(scs, config.Agent.Includes)
+	if err := sortByDependencies(scs, templConfig); err != nil {
+		return fatal.NewError(err)
+	}
+	p.depsMU.Lock()
 	p.configs = scs
+	p.depsMU.Unlock()
+	p.execState = newExecState(config.Agent.StateDir, p.ConfigPath)
+	p.execGroups = makeExecGroups(ctx, config.Agent.ExecGroups, p.execState, p.Logger)
+	p.execGroupSpecs = config.Agent.ExecGroups
 	p.maxConsecFailures = cmp.Or(config.Agent.MaxConsecutiveFailures, defaultMaxConsecFailures)
+	if p.Reloaded {
+		p.Events.Publish(events.Event{Type: events.ReloadBegun})
+	}
 	return p.startTickLoops(ctx)
 }
 
+// Templates returns the names of the
+// templates with a running render loop
+func (p *Proc) Templates() []string {
+	p.triggerMU.Lock()
+	defer p.triggerMU.Unlock()
+	names := make([]string, 0, len(p.refreshTriggers))
+	for name := range p.refreshTriggers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TemplatesMatching returns the names of the
+// templates having every label of selector
+func (p *Proc) TemplatesMatching(selector map[string]string) []string {
+	p.depsMU.RLock()
+	defer p.depsMU.RUnlock()
+	var names []string
+	for _, sc := range p.configs {
+		if matchLabels(sc.labels, selector) {
+			names = append(names, sc.name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func matchLabels(labels map[string]string, selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
 func (p *Proc) TriggerRefresh(templateName string) error {
+	return p.triggerRefresh(templateName, triggerReq{})
+}
+
+// ForceRefresh triggers a refresh which
+// skips the render guard of the template
+func (p *Proc) ForceRefresh(templateName string) error {
+	return p.triggerRefresh(templateName, triggerReq{force: true})
+}
+
+func (p *Proc) triggerRefresh(templateName string, req triggerReq) error {
 
 	p.triggerMU.Lock()
 	flow, ok := p.refreshTriggers[templateName]
@@ -106,9 +322,16 @@ func (p *Proc) TriggerRefresh(templateName string) error {
 		return fmt.Errorf("render loop not initialized for template %s", templateName)
 	}
 
-	flow.trigger <- struct{}{}
-	return <-flow.triggerResp
-
+	select {
+	case flow.trigger <- req:
+		// the loop always responds to
+		// a trigger it has received
+		return <-flow.triggerResp
+	case <-flow.ctx.Done():
+		return fmt.Errorf("render loop of template %s stopped:%w", templateName, flow.ctx.Err())
+	case <-flow.done:
+		return fmt.Errorf("render loop of template %s stopped", templateName)
+	}
 }
 
 func sanitizeConfigs(templConfig map[string]*config.TemplateSpec) []sinkExecConfig {
@@ -116,6 +339,16 @@ func sanitizeConfigs(templConfig map[string]*config.TemplateSpec) []sinkExecConf
 	var scs = make([]sinkExecConfig, len(templConfig))
 	for name := range templConfig {
 		specTempl := templConfig[name]
+
+		dest := specTempl.Destination
+		multiOutput := specTempl.MultiOutput
+		if specTempl.SourceDir != "" {
+			// every template in the dir is rendered
+			// as one file of a multi output template
+			dest = specTempl.DestinationDir
+			multiOutput = true
+		}
+
 		scs[i] = sinkExecConfig{
 			sinkConfig: sinkConfig{
 				refreshInterval:  time.Duration(specTempl.RefreshInterval),
@@ -123,30 +356,173 @@ func sanitizeConfigs(templConfig map[string]*config.TemplateSpec) []sinkExecConf
 				templateDelims:   specTempl.TemplateDelimiters,
 				actions:          specTempl.Actions,
 				readFrom:         os.ExpandEnv(specTempl.Source),
-				dest:             os.ExpandEnv(specTempl.Destination),
+				readFromDir:      os.ExpandEnv(specTempl.SourceDir),
+				includes:         specTempl.Includes,
+				dest:             os.ExpandEnv(dest),
 				staticData:       specTempl.StaticData,
 				name:             name,
 				renderOnce:       cmp.Or(specTempl.RenderOnce || specTempl.RefreshInterval == 0),
 				raw:              specTempl.Raw,
 				missingKey:       strings.TrimSpace(specTempl.MissingKey),
 				refreshOnTrigger: specTempl.RefreshOnTrigger,
+				dependsOn:        resolveDependencies(templConfig, specTempl.DependsOn),
+				multiOutput:      multiOutput,
+				managedBlock:     makeManagedBlock(name, specTempl),
+				outputFormat:     specTempl.OutputFormat,
+				canonicalize:     specTempl.Canonicalize,
+				guardSpec:        specTempl.Guard,
+				escape:           specTempl.Escape,
+				execGroup:        specTempl.ExecGroup,
+				labels:           specTempl.Labels,
+				required:         specTempl.Required,
 			},
 		}
 
 		specExec := specTempl.Exec
 		if specExec != nil {
-			scs[i].execConfig = &execConfig{
-				cmd:     specExec.Cmd,
-				timeout: cmp.Or(time.Duration(specExec.CmdTimeout), defaultExecTimeout),
-				args:    specExec.CmdArgs,
-				env:     specExec.Env,
-			}
+			scs[i].execConfig = makeExecConfig(specExec)
 		}
+		scs[i].notify = makeNotify(specTempl.Notify)
+		scs[i].webhookSpec = specTempl.Webhook
 		i++
 	}
 	return scs
 }
 
+func makeExecGroups(ctx context.Context, specs map[string]*config.ExecGroupSpec, state execState, logger *slog.Logger) map[string]*cmdexec.Group {
+	groups := make(map[string]*cmdexec.Group, len(specs))
+	for name, spec := range specs {
+		ec := makeExecConfig(&spec.ExecSpec)
+		pendingFile := makePendingDir(state.groupFile(name), logger.With(slog.String("exec_group", name)))
+		groups[name] = &cmdexec.Group{
+			Execer: &cmdexec.Retry{
+				Execer:      ec.execer("", "", logger.With(slog.String("exec_group", name))),
+				Retries:     ec.retries,
+				Backoff:     ec.backoff,
+				PendingFile: pendingFile,
+			},
+			Debounce: cmp.Or(time.Duration(spec.Debounce), defaultExecGroupDebounce),
+			Context:  ctx,
+		}
+	}
+	return groups
+}
+
+func makeNotify(spec *config.NotifySpec) *cmdexec.Notify {
+	if spec == nil {
+		return nil
+	}
+	// the signal name is checked
+	// during config validation
+	sig, _ := signame.Parse(spec.Signal)
+	return &cmdexec.Notify{
+		PIDFile:     os.ExpandEnv(spec.PIDFile),
+		ProcessName: spec.ProcessName,
+		Signal:      sig,
+	}
+}
+
+func makeWebhook(name string, dest string, spec *config.WebhookSpec) (*cmdexec.Webhook, error) {
+	if spec == nil {
+		return nil, nil
+	}
+	var body *template.Template
+	if spec.Body != "" {
+		parsed, err := template.New("webhook-body").Parse(spec.Body)
+		if err != nil {
+			return nil, fmt.Errorf("webhook body:%w", err)
+		}
+		body = parsed
+	}
+	headers := make(map[string]string, len(spec.Headers))
+	for k, v := range spec.Headers {
+		headers[k] = os.ExpandEnv(v)
+	}
+	return &cmdexec.Webhook{
+		Name:           name,
+		Destination:    dest,
+		Method:         cmp.Or(spec.Method, http.MethodPost),
+		URL:            os.ExpandEnv(spec.URL),
+		Headers:        headers,
+		Body:           body,
+		Timeout:        cmp.Or(time.Duration(spec.Timeout), defaultWebhookTimeout),
+		ExpectedStatus: spec.ExpectedStatus,
+	}, nil
+}
+
+func compileGuard(spec *config.GuardSpec) (*render.Guard, error) {
+	if spec == nil {
+		return nil, nil
+	}
+	guard := &render.Guard{
+		MinSize:          spec.MinSize,
+		MaxShrinkPercent: spec.MaxShrinkPercent,
+		Contains:         spec.Contains,
+	}
+	for _, pattern := range spec.Matches {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid guard pattern:%w", err)
+		}
+		guard.Matches = append(guard.Matches, re)
+	}
+	return guard, nil
+}
+
+func makeManagedBlock(name string, spec *config.TemplateSpec) *render.ManagedBlock {
+	if spec.Mode != config.ModeManagedBlock {
+		return nil
+	}
+	if len(spec.BlockMarkers) == 2 {
+		return &render.ManagedBlock{Begin: spec.BlockMarkers[0], End: spec.BlockMarkers[1]}
+	}
+	return &render.ManagedBlock{
+		Begin: fmt.Sprintf("# BEGIN tplagent %s", name),
+		End:   fmt.Sprintf("# END tplagent %s", name),
+	}
+}
+
+// sortByDependencies sorts scs in dependency order
+// so that loops of dependencies are started first
+func sortByDependencies(scs []sinkExecConfig, templConfig map[string]*config.TemplateSpec) error {
+	order, err := config.DependencyOrder(templConfig)
+	if err != nil {
+		return err
+	}
+	pos := make(map[string]int, len(order))
+	for i, name := range order {
+		pos[name] = i
+	}
+	slices.SortFunc(scs, func(a, b sinkExecConfig) int {
+		return cmp.Compare(pos[a.name], pos[b.name])
+	})
+	return nil
+}
+
+// addGlobalIncludes prepends the agent wide includes
+// so that template level includes can override them
+func addGlobalIncludes(scs []sinkExecConfig, includes []string) {
+	if len(includes) < 1 {
+		return
+	}
+	for i := range scs {
+		scs[i].includes = append(slices.Clone(includes), scs[i].includes...)
+	}
+}
+
+func resolveDependencies(templConfig map[string]*config.TemplateSpec, names []string) map[string]string {
+	if len(names) < 1 {
+		return nil
+	}
+	deps := make(map[string]string, len(names))
+	for _, name := range names {
+		if spec, ok := templConfig[name]; ok {
+			deps[name] = os.ExpandEnv(spec.Destination)
+		}
+	}
+	return deps
+}
+
 type templInitErr struct {
 	name string
 	err  error
@@ -157,31 +533,31 @@ func (t templInitErr) Error() string {
 }
 
 func (p *Proc) startTickLoops(ctx context.Context) error {
-	var wg sync.WaitGroup
 	errsChan := make(chan error)
 
+	p.linkDependencies()
+
+	p.loopsMU.Lock()
+	p.loopsCtx = ctx
+	p.loops = make(map[string]*renderLoop, len(p.configs))
+	p.loopErrs = errsChan
+	p.loopsDone = false
+	// held until every loop is
+	// spawned so that errsChan is
+	// not closed by an early exit
+	p.activeLoops = 1
+	p.loopsMU.Unlock()
+
 	for i := range p.configs {
-		wg.Add(1)
-		go func(idx int) {
-			defer wg.Done()
-			sc := p.configs[idx]
-			if err := p.initTemplate(&sc); err != nil {
-				initErr := templInitErr{
-					name: sc.name,
-					err:  err,
-				}
-				errsChan <- fatal.NewError(initErr)
-				p.Logger.Error("init template error", slog.String("error", err.Error()), slog.String("name", sc.name))
-				return
-			}
-			errsChan <- p.startRenderLoop(ctx, sc)
-		}(i)
+		_ = p.spawnLoop(p.configs[i])
 	}
 
-	go func() {
-		wg.Wait()
-		close(errsChan)
-	}()
+	p.loopsMU.Lock()
+	p.releaseLoop()
+	p.loopsMU.Unlock()
+	if p.Reloaded {
+		p.Events.Publish(events.Event{Type: events.ReloadCompleted})
+	}
 
 	var loopErrs []error
 	var fatalCount int
@@ -196,73 +572,376 @@ func (p *Proc) startTickLoops(ctx context.Context) error {
 		return nil
 	}
 
-	if fatalCount == len(p.configs) {
+	if fatalCount == len(loopErrs) {
 		return fatal.NewError(errors.Join(loopErrs...))
 	}
 
 	return errors.Join(loopErrs...)
 }
 
+// spawnLoop starts the render loop of sc, its
+// error is reported to startTickLoops unless
+// the loop was stopped by ReloadTemplate
+func (p *Proc) spawnLoop(sc sinkExecConfig) error {
+	p.loopsMU.Lock()
+	defer p.loopsMU.Unlock()
+	if p.loops == nil || p.loopsDone {
+		return errors.New("agent is not running")
+	}
+
+	ctx, cancel := context.WithCancel(p.loopsCtx)
+	loop := &renderLoop{cancel: cancel, done: make(chan struct{})}
+	p.loops[sc.name] = loop
+	p.activeLoops++
+	errsChan := p.loopErrs
+	parent := p.loopsCtx
+
+	go func() {
+		defer p.loopExited(sc.name, loop)
+		err := p.runLoop(ctx, sc)
+		if ctx.Err() != nil && parent.Err() == nil {
+			return
+		}
+		errsChan <- err
+	}()
+	return nil
+}
+
+func (p *Proc) runLoop(ctx context.Context, sc sinkExecConfig) error {
+	// dependents should not wait
+	// forever if this template
+	// fails before its first render
+	defer p.openGate(sc.name)
+	if err := p.waitForDependencies(ctx, sc); err != nil {
+		return err
+	}
+	if err := p.initTemplate(&sc); err != nil {
+		initErr := templInitErr{
+			name: sc.name,
+			err:  err,
+		}
+		p.Logger.Error("init template error", slog.String("error", err.Error()), slog.String("name", sc.name))
+		p.Events.Publish(events.Event{Type: events.TemplateStopped, Template: sc.name, Error: initErr.Error()})
+		return fatal.NewError(initErr)
+	}
+	return p.startRenderLoop(ctx, sc)
+}
+
+func (p *Proc) loopExited(name string, loop *renderLoop) {
+	p.loopsMU.Lock()
+	defer p.loopsMU.Unlock()
+	loop.cancel()
+	close(loop.done)
+	if p.loops[name] == loop {
+		delete(p.loops, name)
+	}
+	p.releaseLoop()
+}
+
+// releaseLoop must be called with loopsMU held
+func (p *Proc) releaseLoop() {
+	p.activeLoops--
+	if p.activeLoops == 0 {
+		p.loopsDone = true
+		close(p.loopErrs)
+	}
+}
+
+// linkDependencies builds the render gates
+// and the reverse dependency lookup used
+// to re-render dependents of a template
+func (p *Proc) linkDependencies() {
+	p.depsMU.Lock()
+	defer p.depsMU.Unlock()
+	p.renderGates = make(map[string]*renderGate, len(p.configs))
+	p.dependents = make(map[string][]string)
+	p.upstreamChanged = make(map[string]chan struct{}, len(p.configs))
+	for _, sc := range p.configs {
+		p.renderGates[sc.name] = &renderGate{done: make(chan struct{})}
+		p.upstreamChanged[sc.name] = make(chan struct{}, 1)
+		for dep := range sc.dependsOn {
+			p.dependents[dep] = append(p.dependents[dep], sc.name)
+		}
+	}
+}
+
+func (p *Proc) waitForDependencies(ctx context.Context, sc sinkExecConfig) error {
+	for dep := range sc.dependsOn {
+		p.depsMU.RLock()
+		gate, ok := p.renderGates[dep]
+		p.depsMU.RUnlock()
+		if !ok {
+			continue
+		}
+		p.Logger.Debug("waiting for dependency", slog.String("templ", sc.name), slog.String("dependency", dep))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-gate.done:
+		}
+	}
+	return nil
+}
+
+func (p *Proc) openGate(name string) {
+	p.depsMU.RLock()
+	defer p.depsMU.RUnlock()
+	if gate, ok := p.renderGates[name]; ok {
+		gate.open()
+	}
+}
+
+func (p *Proc) hasDependencyLinks(sc sinkExecConfig) bool {
+	p.depsMU.RLock()
+	defer p.depsMU.RUnlock()
+	return len(sc.dependsOn) > 0 || len(p.dependents[sc.name]) > 0
+}
+
+func (p *Proc) notifyDependents(name string) {
+	p.depsMU.RLock()
+	defer p.depsMU.RUnlock()
+	for _, dependent := range p.dependents[name] {
+		select {
+		case p.upstreamChanged[dependent] <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// tick runs the TickFunc, opens the render
+// gate for the template and notifies its
+// dependents and the RenderHook if the
+// destination was written, a failed render
+// is passed to the RenderErrHook
+func (p *Proc) tick(ctx context.Context, sink Renderer, execer CMDExecer, cfg sinkExecConfig) error {
+	err := p.TickFunc(ctx, sink, execer, cfg.staticData)
+	p.openGate(cfg.name)
+
+	execErr := &cmdexec.ExecErr{}
+	notifyErr := &cmdexec.NotifyErr{}
+	webhookErr := &cmdexec.WebhookErr{}
+	pending := errors.As(err, new(pendingExecErr))
+	written := !pending && (err == nil || errors.As(err, &execErr) || errors.As(err, &notifyErr) || errors.As(err, &webhookErr))
+	if written {
+		p.notifyDependents(cfg.name)
+	}
+	switch {
+	case written || errors.Is(err, render.ContentsIdentical):
+		if p.RenderHook != nil {
+			p.RenderHook(cfg.name, written)
+		}
+	case p.RenderErrHook != nil:
+		p.RenderErrHook(cfg.name, err)
+	}
+	p.publishTick(err, written, execer != nil, cfg)
+	p.updateHealth(cfg.name, func(h *loopHealth) {
+		h.lastTick = time.Now()
+		h.rendered = h.rendered || written || pending || errors.Is(err, render.ContentsIdentical)
+	})
+	return err
+}
+
+func (p *Proc) publishTick(err error, written bool, hasExecer bool, cfg sinkExecConfig) {
+	if p.Events == nil {
+		return
+	}
+	switch {
+	case errors.As(err, new(execRetried)):
+		p.Events.Publish(events.Event{Type: events.RenderIdentical, Template: cfg.name})
+		p.Events.Publish(events.Event{Type: events.ExecResult, Template: cfg.name})
+	case errors.Is(err, render.ContentsIdentical):
+		p.Events.Publish(events.Event{Type: events.RenderIdentical, Template: cfg.name})
+	case errors.As(err, new(pendingExecErr)):
+		p.Events.Publish(events.Event{Type: events.ExecResult, Template: cfg.name, Error: err.Error()})
+	case written:
+		p.Events.Publish(events.Event{Type: events.RenderWritten, Template: cfg.name})
+		if hasExecer {
+			e := events.Event{Type: events.ExecResult, Template: cfg.name}
+			if err != nil {
+				e.Error = err.Error()
+			}
+			p.Events.Publish(e)
+		}
+	default:
+		if action, ok := failedAction(err, cfg.actions); ok {
+			p.Events.Publish(events.Event{Type: events.ActionError, Template: cfg.name, Action: action, Error: err.Error()})
+		}
+		p.Events.Publish(events.Event{Type: events.RenderError, Template: cfg.name, Error: err.Error()})
+	}
+}
+
+var funcCallErr = regexp.MustCompile(`error calling (\w+):`)
+
+// failedAction returns the action whose function
+// failed the render, text/template names the
+// function in the error
+func failedAction(err error, actions []config.Actions) (string, bool) {
+	match := funcCallErr.FindStringSubmatch(err.Error())
+	if match == nil {
+		return "", false
+	}
+	for _, a := range actions {
+		if strings.HasPrefix(match[1], a.Name+"_") {
+			return a.Name, true
+		}
+	}
+	return "", false
+}
+
 func (p *Proc) initTemplate(sc *sinkExecConfig) error {
+	webhook, err := makeWebhook(sc.name, sc.dest, sc.webhookSpec)
+	if err != nil {
+		return err
+	}
+	sc.webhook = webhook
+
 	at := actionable.NewTemplate(sc.name, sc.html)
 	at.SetMissingKeyBehaviour(sc.missingKey)
+	at.SetEscapeMode(sc.escape)
 	setTemplateDelims(at, sc.templateDelims)
+	at.Funcs(dependencyFuncs(sc.dependsOn))
+	at.Funcs(multiOutputFuncs(sc.multiOutput))
 	if err := attachActions(at, tplactions.Registry, p.Logger, sc.actions); err != nil {
 		return err
 	}
 	sc.parsed = at
-	return parseTemplate(sc.raw, sc.readFrom, sc.parsed)
+
+	guard, err := compileGuard(sc.guardSpec)
+	if err != nil {
+		return err
+	}
+	sc.guard = guard
+
+	if sc.readFromDir != "" {
+		err = parseTemplateDir(sc.readFromDir, sc.templateDelims, sc.parsed)
+	} else {
+		err = parseTemplate(sc.raw, sc.readFrom, sc.parsed)
+	}
+	if err != nil {
+		return err
+	}
+	if err := parseIncludes(sc.includes, sc.parsed); err != nil {
+		return err
+	}
+	// file markers delimit the output
+	// files and must be written as is
+	return sc.parsed.Escape("file")
 }
 
 func (p *Proc) startRenderLoop(ctx context.Context, cfg sinkExecConfig) error {
 
 	p.Logger.Info("starting refresh loop", slog.String("templ", cfg.name))
+	p.Events.Publish(events.Event{Type: events.TemplateStarted, Template: cfg.name})
+	p.loopStarted(cfg)
 	sink := render.Sink{
-		Templ:   cfg.parsed,
-		WriteTo: cfg.dest,
+		Templ:        cfg.parsed,
+		WriteTo:      cfg.dest,
+		MultiOutput:  cfg.multiOutput,
+		ManagedBlock: cfg.managedBlock,
+		ManifestName: fmt.Sprintf(".tplagent-%s.manifest", cfg.name),
+		Guard:        cfg.guard,
+	}
+	if cfg.outputFormat != "" {
+		sink.Checker = outformat.Checker{
+			Format:       cfg.outputFormat,
+			Canonicalize: cfg.canonicalize,
+		}
 	}
 
 	var execer CMDExecer = nil
 	ec := cfg.execConfig
 	if ec != nil {
-		execer = &cmdexec.Default{
-			Args:    ec.args,
-			Cmd:     ec.cmd,
-			Env:     ec.env,
-			Timeout: ec.timeout,
+		logger := p.Logger.With(slog.String("tmpl", cfg.name))
+		execer = &cmdexec.Retry{
+			Execer:      ec.execer(cfg.name, cfg.dest, logger),
+			Retries:     ec.retries,
+			Backoff:     ec.backoff,
+			PendingFile: makePendingDir(p.execState.templateFile(cfg.name, cfg.dest), logger),
 		}
 	}
+	if cfg.notify != nil {
+		execer = cfg.notify
+	}
+	if cfg.webhook != nil {
+		execer = cfg.webhook
+	}
+
+	var renderer Renderer = &sink
+	if group, ok := p.execGroups[cfg.execGroup]; ok {
+		member := group.Member()
+		execer = member
+		renderer = groupRenderer{Renderer: renderer, member: member, name: cfg.name, dest: cfg.dest}
+	}
 
 	var ticker *time.Ticker
 	var tick <-chan time.Time
 	if cfg.renderOnce {
-		if err := p.TickFunc(ctx, &sink, execer, cfg.staticData); err != nil && !errors.Is(err, render.ContentsIdentical) {
+		if err := p.tick(ctx, renderer, execer, cfg); err != nil && !errors.Is(err, render.ContentsIdentical) {
 			p.Logger.Error("RenderAndExec error", slog.String("error", err.Error()), slog.String("loop", cfg.name), slog.Bool("once", true))
 		}
 		p.Logger.Info("refresh complete", slog.Bool("once", true), slog.String("templ", cfg.name))
 	} else {
+		// templates linked by dependencies render
+		// when the loop starts so that the gates of
+		// dependents do not wait for a refresh tick
+		if p.RenderOnStart || p.hasDependencyLinks(cfg) {
+			err := p.tick(ctx, renderer, execer, cfg)
+			p.handleTickExecErr(err, cfg)
+		}
 		ticker = time.NewTicker(cfg.refreshInterval)
 		defer ticker.Stop()
 		tick = ticker.C
 	}
 
-	refreshTrigger := make(chan struct{})
+	refreshTrigger := make(chan triggerReq)
 	triggerResp := make(chan error)
+	loopDone := make(chan struct{})
 
 	p.triggerMU.Lock()
 	p.refreshTriggers[cfg.name] = triggerFlow{
 		trigger:     refreshTrigger,
 		triggerResp: triggerResp,
+		ctx:         ctx,
+		done:        loopDone,
 	}
 	p.triggerMU.Unlock()
 
+	var stopErr error
 	defer func() {
+		close(loopDone)
 		cfg.parsed.CloseActions()
 		p.triggerMU.Lock()
 		delete(p.refreshTriggers, cfg.name)
 		p.triggerMU.Unlock()
+		p.updateHealth(cfg.name, func(h *loopHealth) {
+			h.running = false
+			h.failed = errors.Is(stopErr, errTooManyFailures)
+		})
+		stopped := events.Event{Type: events.TemplateStopped, Template: cfg.name}
+		if stopErr != nil {
+			stopped.Error = stopErr.Error()
+		}
+		p.Events.Publish(stopped)
 	}()
 
+	if cfg.refreshOnTrigger {
+		watchCtx, cancelWatch := context.WithCancel(ctx)
+		waitWatchers := p.startWatchers(watchCtx, cfg, triggerFlow{
+			trigger:     refreshTrigger,
+			triggerResp: triggerResp,
+			ctx:         watchCtx,
+			done:        loopDone,
+		})
+		defer func() {
+			cancelWatch()
+			waitWatchers()
+		}()
+	}
+
+	p.depsMU.RLock()
+	upstreamChanged := p.upstreamChanged[cfg.name]
+	p.depsMU.RUnlock()
+
 	consecutiveFailures := 0
 	for consecutiveFailures < p.maxConsecFailures {
 		resetFailures := true
@@ -270,19 +949,28 @@ func (p *Proc) startRenderLoop(ctx context.Context, cfg sinkExecConfig) error {
 		case <-ctx.Done():
 			p.Logger.Info("stopping render sink", slog.String("sink", cfg.name), slog.String("cause", ctx.Err().Error()))
 			return ctx.Err()
-		case <-refreshTrigger:
-			err := p.TickFunc(ctx, &sink, execer, cfg.staticData)
+		case req := <-refreshTrigger:
+			sink.SkipGuard = req.force
+			err := p.tick(ctx, renderer, execer, cfg)
+			sink.SkipGuard = false
 			triggerResp <- err
 			resetFailures = p.handleTickExecErr(err, cfg)
 		case <-tick:
-			err := p.TickFunc(ctx, &sink, execer, cfg.staticData)
+			err := p.tick(ctx, renderer, execer, cfg)
+			resetFailures = p.handleTickExecErr(err, cfg)
+		case <-upstreamChanged:
+			p.Logger.Info("dependency changed, refreshing", slog.String("templ", cfg.name))
+			err := p.tick(ctx, renderer, execer, cfg)
 			resetFailures = p.handleTickExecErr(err, cfg)
 		}
 		if resetFailures {
 			consecutiveFailures = 0
-			continue
+		} else {
+			consecutiveFailures++
 		}
-		consecutiveFailures++
+		p.updateHealth(cfg.name, func(h *loopHealth) {
+			h.failures = consecutiveFailures
+		})
 	}
 
 	if consecutiveFailures == p.maxConsecFailures {
@@ -292,22 +980,106 @@ func (p *Proc) startRenderLoop(ctx context.Context, cfg sinkExecConfig) error {
 			slog.String("templ", cfg.name),
 			slog.String("cause", "too many render failures"),
 		)
+		stopErr = errTooManyFailures
 		return fatal.NewError(errTooManyFailures)
 	}
 	return nil
 }
 
+// startWatchers runs Watch for every action
+// implementing tplactions.Watcher, change
+// notifications are coalesced and forwarded
+// to the refresh trigger of the render loop
+func (p *Proc) startWatchers(ctx context.Context, cfg sinkExecConfig, flow triggerFlow) (wait func()) {
+	watchers := cfg.parsed.Watchers()
+	if len(watchers) < 1 {
+		return func() {}
+	}
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range watchers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := w.Watch(ctx, notify)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				p.Logger.Error("action watch failed", slog.String("error", err.Error()), slog.String("templ", cfg.name))
+				p.Events.Publish(events.Event{Type: events.ActionError, Template: cfg.name, Error: err.Error()})
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case flow.trigger <- triggerReq{}:
+				// errors are already handled
+				// by the render loop
+				<-flow.triggerResp
+			}
+		}
+	}()
+
+	p.Logger.Info("watching actions for changes", slog.Int("count", len(watchers)), slog.String("templ", cfg.name))
+	return wg.Wait
+}
+
 func (p *Proc) handleTickExecErr(err error, cfg sinkExecConfig) (reset bool) {
 	execErr := &cmdexec.ExecErr{}
+	notifyErr := &cmdexec.NotifyErr{}
+	webhookErr := &cmdexec.WebhookErr{}
+	invalidOutput := &outformat.InvalidOutput{}
+	guardViolation := &render.GuardViolation{}
 	switch {
 	case errors.Is(err, render.ContentsIdentical):
 		return true
+	case errors.As(err, &guardViolation):
+		p.Logger.Error("render refused by guard",
+			slog.String("reason", guardViolation.Reason),
+			slog.String("tmpl", cfg.name))
+		return false
+	case errors.As(err, &invalidOutput):
+		p.Logger.Error("render rejected",
+			slog.String("cause", err.Error()),
+			slog.String("format", invalidOutput.Format),
+			slog.String("tmpl", cfg.name))
+		return false
 	case errors.As(err, &execErr):
 		p.Logger.Error("render succeeded, exec failed",
 			slog.String("error", string(execErr.Stderr)),
+			slog.String("stdout", string(execErr.Stdout)),
 			slog.Int("exit-code", execErr.Status),
 			slog.String("tmpl", cfg.name))
 		return false
+	case errors.As(err, &notifyErr):
+		p.Logger.Error("render succeeded, notify failed",
+			slog.String("error", notifyErr.Err.Error()),
+			slog.String("target", notifyErr.Target),
+			slog.String("tmpl", cfg.name))
+		return false
+	case errors.As(err, &webhookErr):
+		p.Logger.Error("render succeeded, webhook failed",
+			slog.String("error", webhookErr.Error()),
+			slog.String("tmpl", cfg.name))
+		return false
 	case err != nil:
 		p.Logger.Error("render failed", slog.String("cause", err.Error()))
 		return false
@@ -317,6 +1089,50 @@ func (p *Proc) handleTickExecErr(err error, cfg sinkExecConfig) (reset bool) {
 	}
 }
 
+// groupRenderer holds back the exec of
+// the group while the template renders
+type groupRenderer struct {
+	Renderer
+	member *cmdexec.GroupMember
+	// name and dest describe the member
+	// in the change passed to the group
+	name string
+	dest string
+}
+
+func (g groupRenderer) LastChange() render.Change {
+	if cr, ok := g.Renderer.(changeReporter); ok {
+		return cr.LastChange()
+	}
+	return render.Change{}
+}
+
+func (g groupRenderer) target() (name string, dest string) {
+	return g.name, g.dest
+}
+
+func (g groupRenderer) Render(data any) error {
+	return g.member.Track(func() (*cmdexec.Change, error) {
+		if err := g.Renderer.Render(data); err != nil {
+			return nil, err
+		}
+		change, ok := execChange(g)
+		if !ok {
+			return nil, nil
+		}
+		return &change, nil
+	})
+}
+
+type pendingExecer interface {
+	Pending() bool
+}
+
+func execPending(execer CMDExecer) bool {
+	pe, ok := execer.(pendingExecer)
+	return ok && pe.Pending()
+}
+
 type renderExecErr struct {
 	execErr bool
 	err     error
@@ -333,6 +1149,34 @@ func (r renderExecErr) Error() string {
 	return r.err.Error()
 }
 
+// pendingExecErr is returned when the exec left pending
+// by an earlier render fails again, the destination
+// was not written by the render
+type pendingExecErr struct {
+	err error
+}
+
+func (p pendingExecErr) Unwrap() error {
+	return p.err
+}
+
+func (p pendingExecErr) Error() string {
+	return fmt.Sprintf("pending exec err:%s", p.err.Error())
+}
+
+// execRetried is returned when the exec left pending
+// by an earlier render succeeds, it unwraps to
+// ContentsIdentical as the destination was not written
+type execRetried struct{}
+
+func (execRetried) Unwrap() error {
+	return render.ContentsIdentical
+}
+
+func (execRetried) Error() string {
+	return "pending exec retried:identical contents"
+}
+
 func RenderAndExec(ctx context.Context, sink Renderer, execer CMDExecer, staticData any) error {
 	select {
 	case <-ctx.Done():
@@ -341,6 +1185,14 @@ func RenderAndExec(ctx context.Context, sink Renderer, execer CMDExecer, staticD
 	}
 
 	err := sink.Render(staticData)
+	if errors.Is(err, render.ContentsIdentical) && execPending(execer) {
+		// the exec of an earlier render failed, it is
+		// run again with the change of that render
+		if err := execer.ExecContext(changeContext(ctx, sink)); err != nil {
+			return pendingExecErr{err: err}
+		}
+		return execRetried{}
+	}
 	if err != nil {
 		return renderExecErr{
 			execErr: false,
@@ -351,7 +1203,34 @@ func RenderAndExec(ctx context.Context, sink Renderer, execer CMDExecer, staticD
 	if execer == nil {
 		return nil
 	}
+	return execer.ExecContext(changeContext(ctx, sink))
+}
 
-	return execer.ExecContext(context.Background())
+// changeContext adds the last change of sink to ctx
+func changeContext(ctx context.Context, sink Renderer) context.Context {
+	change, ok := execChange(sink)
+	if !ok {
+		return ctx
+	}
+	return cmdexec.WithChange(ctx, change)
+}
 
+// execChange returns the last change of sink
+// if it reports its changes
+func execChange(sink Renderer) (cmdexec.Change, bool) {
+	cr, ok := sink.(changeReporter)
+	if !ok {
+		return cmdexec.Change{}, false
+	}
+	change := cr.LastChange()
+	execChange := cmdexec.Change{
+		Backup:     change.Backup,
+		OldHash:    change.OldHash,
+		NewHash:    change.NewHash,
+		RenderedAt: change.RenderedAt,
+	}
+	if tr, ok := sink.(targetReporter); ok {
+		execChange.Template, execChange.Destination = tr.target()
+	}
+	return execChange, true
 }
@@ -7,13 +7,20 @@ import (
 	"fmt"
 	gocmp "github.com/google/go-cmp/cmp"
 	"github.com/shubhang93/tplagent/internal/actionable"
+	"github.com/shubhang93/tplagent/internal/cmdexec"
 	cfg "github.com/shubhang93/tplagent/internal/config"
 	"github.com/shubhang93/tplagent/internal/duration"
+	"github.com/shubhang93/tplagent/internal/events"
 	"github.com/shubhang93/tplagent/internal/fatal"
 	"github.com/shubhang93/tplagent/internal/render"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -70,10 +77,11 @@ func Test_makeSinkExecConfigs(t *testing.T) {
 					readFrom:        homeDir + "/testdir",
 				},
 				execConfig: &execConfig{
-					timeout: 5 * time.Second,
-					args:    []string{"hello"},
-					cmd:     "echo",
-					env:     nil,
+					timeout:    5 * time.Second,
+					args:       []string{"hello"},
+					cmd:        "echo",
+					env:        nil,
+					inheritEnv: true,
 				},
 			},
 			{
@@ -89,9 +97,10 @@ func Test_makeSinkExecConfigs(t *testing.T) {
 					renderOnce: true,
 				},
 				execConfig: &execConfig{
-					args:    []string{"hello"},
-					cmd:     "echo",
-					timeout: 30 * time.Second,
+					args:       []string{"hello"},
+					cmd:        "echo",
+					timeout:    30 * time.Second,
+					inheritEnv: true,
 				},
 			}}
 
@@ -551,6 +560,661 @@ func Test_renderLoop(t *testing.T) {
 
 }
 
+type watchingAction struct {
+	testAction
+	changes int
+}
+
+func (w *watchingAction) Watch(ctx context.Context, notify func()) error {
+	for range w.changes {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+			notify()
+		}
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func Test_watchers(t *testing.T) {
+	tests := map[string]struct {
+		refreshOnTrigger bool
+		wantCount        int32
+	}{
+		"refresh on trigger enabled": {
+			refreshOnTrigger: true,
+			wantCount:        4,
+		},
+		"refresh on trigger disabled": {
+			refreshOnTrigger: false,
+			wantCount:        1,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			tpl := actionable.NewTemplate("test", false)
+			tpl.AddAction(&watchingAction{changes: 3})
+			must(tpl.Parse("hello"))
+
+			tickCount := atomic.Int32{}
+			p := Proc{
+				Logger: newLogger(),
+				TickFunc: func(ctx context.Context, _ Renderer, _ CMDExecer, _ any) error {
+					tickCount.Add(1)
+					return nil
+				},
+				refreshTriggers:   make(map[string]triggerFlow),
+				maxConsecFailures: defaultMaxConsecFailures,
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			err := p.startRenderLoop(ctx, sinkExecConfig{
+				sinkConfig: sinkConfig{
+					name:             "test-watch",
+					parsed:           tpl,
+					renderOnce:       true,
+					refreshOnTrigger: tc.refreshOnTrigger,
+				},
+			})
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				t.Error(err)
+				return
+			}
+
+			if got := tickCount.Load(); got != tc.wantCount {
+				t.Errorf("want count:%d got count:%d", tc.wantCount, got)
+			}
+		})
+	}
+}
+
+func Test_dependencies(t *testing.T) {
+	tmp := t.TempDir()
+	inventoryDest := tmp + "/inventory.json"
+	vhostsDest := tmp + "/vhosts.conf"
+
+	configs := sanitizeConfigs(map[string]*cfg.TemplateSpec{
+		"inventory": {
+			Raw:             "unused",
+			Destination:     inventoryDest,
+			RefreshInterval: duration.Duration(1 * time.Second),
+		},
+		"vhosts": {
+			Raw:         `{{range (dependency "inventory").services}}server {{.}};{{end}}`,
+			Destination: vhostsDest,
+			RenderOnce:  true,
+			DependsOn:   []string{"inventory"},
+		},
+	})
+
+	var inventoryWrites atomic.Int32
+	p := Proc{
+		Logger: newLogger(),
+		TickFunc: func(ctx context.Context, sink Renderer, execer CMDExecer, staticData any) error {
+			if sink.(*render.Sink).WriteTo != inventoryDest {
+				return RenderAndExec(ctx, sink, execer, staticData)
+			}
+			n := inventoryWrites.Add(1)
+			return os.WriteFile(inventoryDest, []byte(fmt.Sprintf(`{"services":["svc%d"]}`, n)), 0755)
+		},
+		configs:           configs,
+		refreshTriggers:   make(map[string]triggerFlow),
+		maxConsecFailures: defaultMaxConsecFailures,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3500*time.Millisecond)
+	defer cancel()
+	if err := p.startTickLoops(ctx); fatal.Is(err) {
+		t.Errorf("startTickLoops failed with error:%v", err)
+		return
+	}
+
+	bs, err := os.ReadFile(vhostsDest)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := fmt.Sprintf("server svc%d;", inventoryWrites.Load())
+	if diff := gocmp.Diff(expected, string(bs)); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func Test_dependencies_renderOnLoopStart(t *testing.T) {
+	tmp := t.TempDir()
+	inventoryDest := tmp + "/inventory.json"
+	vhostsDest := tmp + "/vhosts.conf"
+
+	conf := cfg.TPLAgent{TemplateSpecs: map[string]*cfg.TemplateSpec{
+		"vhosts": {
+			Raw:             `{{range (dependency "inventory").services}}server {{.}};{{end}}`,
+			Destination:     vhostsDest,
+			RefreshInterval: duration.Duration(time.Hour),
+			DependsOn:       []string{"inventory"},
+		},
+		"inventory": {
+			Raw:             `{"services":["svc1"]}`,
+			Destination:     inventoryDest,
+			RefreshInterval: duration.Duration(time.Hour),
+		},
+	}}
+
+	p := Proc{Logger: newLogger(), TickFunc: RenderAndExec}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Start(ctx, conf)
+	}()
+	defer func() {
+		cancel()
+		<-errCh
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		bs, err := os.ReadFile(vhostsDest)
+		if err == nil {
+			if diff := gocmp.Diff("server svc1;", string(bs)); diff != "" {
+				t.Errorf("(--Want ++Got):\n%s", diff)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dependent was not rendered:%v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func Test_forceRefresh(t *testing.T) {
+	dest := t.TempDir() + "/upstreams.conf"
+	must(os.WriteFile(dest, []byte("server a;"), 0755))
+
+	p := Proc{
+		Logger:            newLogger(),
+		TickFunc:          RenderAndExec,
+		refreshTriggers:   make(map[string]triggerFlow),
+		maxConsecFailures: defaultMaxConsecFailures,
+		configs: []sinkExecConfig{{
+			sinkConfig: sinkConfig{
+				name:       "upstreams",
+				raw:        "{{range .}}server {{.}};{{end}}",
+				dest:       dest,
+				renderOnce: true,
+				guardSpec:  &cfg.GuardSpec{MinSize: 1},
+			},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = p.startTickLoops(ctx)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	violation := &render.GuardViolation{}
+	if err := p.TriggerRefresh("upstreams"); !errors.As(err, &violation) {
+		t.Errorf("expected guard violation got %v", err)
+	}
+
+	if err := p.ForceRefresh("upstreams"); err != nil {
+		t.Errorf("force refresh failed:%v", err)
+	}
+	<-done
+
+	bs, err := os.ReadFile(dest)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(bs) != 0 {
+		t.Errorf("expected forced empty render got %q", string(bs))
+	}
+}
+
+func Test_triggerRefresh_loopStopped(t *testing.T) {
+	var calls atomic.Int32
+	p := Proc{
+		Logger: newLogger(),
+		TickFunc: func(ctx context.Context, _ Renderer, _ CMDExecer, _ any) error {
+			// triggered ticks run until shutdown
+			if calls.Add(1) > 1 {
+				<-ctx.Done()
+			}
+			return nil
+		},
+		refreshTriggers:   make(map[string]triggerFlow),
+		maxConsecFailures: defaultMaxConsecFailures,
+		configs: []sinkExecConfig{{
+			sinkConfig: sinkConfig{
+				name:       "app",
+				raw:        "app",
+				dest:       t.TempDir() + "/app.conf",
+				renderOnce: true,
+			},
+		}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = p.startTickLoops(ctx)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// one trigger is ticking, the other waits
+	// for the loop while ctx is cancelled
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- p.TriggerRefresh("app")
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-results:
+		case <-time.After(2 * time.Second):
+			t.Fatal("trigger blocked on a stopped render loop")
+		}
+	}
+	<-done
+}
+
 func newLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stdout, nil))
 }
+
+func Test_execPending(t *testing.T) {
+	state := execState{dir: t.TempDir(), config: "/etc/tplagent/agent.json"}
+	dest := t.TempDir() + "/out.conf"
+	tpl := actionable.NewTemplate("test", false)
+	must(tpl.Parse("Name {{.name}}"))
+	sink := &render.Sink{Templ: tpl, WriteTo: dest}
+
+	hashFile := t.TempDir() + "/hash"
+	writeHash := &cmdexec.Default{
+		Cmd:     `printf %s "$TPLAGENT_NEW_HASH" > "$1"`,
+		Shell:   true,
+		Args:    []string{hashFile},
+		Timeout: time.Second,
+	}
+
+	failing := true
+	calls := 0
+	execer := &cmdexec.Retry{
+		Execer: execFunc(func(ctx context.Context) error {
+			calls++
+			if failing {
+				return &cmdexec.ExecErr{Status: 1}
+			}
+			return writeHash.ExecContext(ctx)
+		}),
+		PendingFile: makePendingDir(state.templateFile("app", dest), newLogger()),
+	}
+	if execer.PendingFile == "" {
+		t.Fatal("expected a pending file")
+	}
+
+	data := map[string]any{"name": "foo"}
+	err := RenderAndExec(context.Background(), sink, execer, data)
+	execErr := &cmdexec.ExecErr{}
+	if !errors.As(err, &execErr) {
+		t.Fatalf("expected ExecErr got %v", err)
+	}
+	if _, err := os.Stat(state.templateFile("app", dest)); err != nil {
+		t.Errorf("expected the exec to be marked pending:%v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dest)); len(entries) != 1 {
+		t.Errorf("expected only the destination in its dir got %d entries", len(entries))
+	}
+
+	// contents are identical but the
+	// failed exec is still pending
+	failing = false
+	err = RenderAndExec(context.Background(), sink, execer, data)
+	if !errors.As(err, new(execRetried)) || !errors.Is(err, render.ContentsIdentical) {
+		t.Fatalf("expected pending exec to succeed without a write got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 exec calls got %d", calls)
+	}
+	// the pending exec gets the change of the render it belongs to
+	if bs, _ := os.ReadFile(hashFile); string(bs) != sink.LastChange().NewHash {
+		t.Errorf("expected pending exec to get hash %q got %q", sink.LastChange().NewHash, bs)
+	}
+
+	err = RenderAndExec(context.Background(), sink, execer, data)
+	if !errors.Is(err, render.ContentsIdentical) {
+		t.Errorf("expected identical contents without a pending exec got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected exec not to run again got %d calls", calls)
+	}
+}
+
+func Test_tick_pendingExecFails(t *testing.T) {
+	dest := t.TempDir() + "/out.conf"
+	tpl := actionable.NewTemplate("test", false)
+	must(tpl.Parse("Name {{.name}}"))
+	sink := &render.Sink{Templ: tpl, WriteTo: dest}
+	execer := &cmdexec.Retry{
+		Execer: execFunc(func(ctx context.Context) error {
+			return &cmdexec.ExecErr{Status: 1}
+		}),
+		PendingFile: t.TempDir() + "/out.exec-pending",
+	}
+
+	var hooks []bool
+	p := Proc{
+		Logger:     newLogger(),
+		TickFunc:   RenderAndExec,
+		RenderHook: func(name string, changed bool) { hooks = append(hooks, changed) },
+	}
+	sc := sinkExecConfig{sinkConfig: sinkConfig{name: "app", staticData: map[string]any{"name": "foo"}}}
+
+	if err := p.tick(context.Background(), sink, execer, sc); !errors.As(err, new(*cmdexec.ExecErr)) {
+		t.Fatalf("expected ExecErr got %v", err)
+	}
+	// the retry of the pending exec fails
+	// but nothing new was written
+	err := p.tick(context.Background(), sink, execer, sc)
+	if !errors.As(err, new(pendingExecErr)) {
+		t.Errorf("expected pendingExecErr got %v", err)
+	}
+	if diff := gocmp.Diff([]bool{true}, hooks); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func Test_tick_pendingExecRetried(t *testing.T) {
+	dest := t.TempDir() + "/out.conf"
+	tpl := actionable.NewTemplate("test", false)
+	must(tpl.Parse("Name {{.name}}"))
+	sink := &render.Sink{Templ: tpl, WriteTo: dest}
+	failing := true
+	execer := &cmdexec.Retry{
+		Execer: execFunc(func(ctx context.Context) error {
+			if failing {
+				return &cmdexec.ExecErr{Status: 1}
+			}
+			return nil
+		}),
+		PendingFile: t.TempDir() + "/out.exec-pending",
+	}
+
+	var hooks []bool
+	bus := events.NewBus()
+	sub := bus.Subscribe(events.Filter{})
+	defer sub.Close()
+	p := Proc{
+		Logger:     newLogger(),
+		TickFunc:   RenderAndExec,
+		RenderHook: func(name string, changed bool) { hooks = append(hooks, changed) },
+		Events:     bus,
+	}
+	sc := sinkExecConfig{sinkConfig: sinkConfig{name: "app", staticData: map[string]any{"name": "foo"}}}
+
+	if err := p.tick(context.Background(), sink, execer, sc); !errors.As(err, new(*cmdexec.ExecErr)) {
+		t.Fatalf("expected ExecErr got %v", err)
+	}
+	// the retry succeeds but nothing new was written
+	failing = false
+	if err := p.tick(context.Background(), sink, execer, sc); !errors.Is(err, render.ContentsIdentical) {
+		t.Fatalf("expected identical contents got %v", err)
+	}
+	if diff := gocmp.Diff([]bool{true, false}, hooks); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+
+	var types []events.Type
+	for len(sub.Events()) > 0 {
+		types = append(types, (<-sub.Events()).Type)
+	}
+	want := []events.Type{events.RenderWritten, events.ExecResult, events.RenderIdentical, events.ExecResult}
+	if diff := gocmp.Diff(want, types); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func Test_execPending_group(t *testing.T) {
+	state := execState{dir: t.TempDir(), config: "/etc/tplagent/agent.json"}
+	dest := t.TempDir() + "/out.conf"
+	tpl := actionable.NewTemplate("test", false)
+	must(tpl.Parse("Name {{.name}}"))
+
+	groups := makeExecGroups(context.Background(), map[string]*cfg.ExecGroupSpec{
+		"reload": {ExecSpec: cfg.ExecSpec{Cmd: "false"}, Debounce: duration.Duration(time.Millisecond)},
+	}, state, newLogger())
+	group := groups["reload"]
+	retry := group.Execer.(*cmdexec.Retry)
+	if retry.PendingFile != state.groupFile("reload") {
+		t.Fatalf("expected pending file %s got %s", state.groupFile("reload"), retry.PendingFile)
+	}
+
+	failing := true
+	calls := 0
+	retry.Execer = execFunc(func(ctx context.Context) error {
+		calls++
+		if failing {
+			return &cmdexec.ExecErr{Status: 1}
+		}
+		return nil
+	})
+	member := group.Member()
+	renderer := groupRenderer{Renderer: &render.Sink{Templ: tpl, WriteTo: dest}, member: member}
+
+	data := map[string]any{"name": "foo"}
+	err := RenderAndExec(context.Background(), renderer, member, data)
+	execErr := &cmdexec.ExecErr{}
+	if !errors.As(err, &execErr) {
+		t.Fatalf("expected ExecErr got %v", err)
+	}
+
+	failing = false
+	if err := RenderAndExec(context.Background(), renderer, member, data); !errors.As(err, new(execRetried)) {
+		t.Fatalf("expected pending group exec to succeed got %v", err)
+	}
+	err = RenderAndExec(context.Background(), renderer, member, data)
+	if !errors.Is(err, render.ContentsIdentical) {
+		t.Errorf("expected identical contents without a pending exec got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 exec calls got %d", calls)
+	}
+}
+
+func Test_RenderAndExec_webhookMultiOutput(t *testing.T) {
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := io.ReadAll(r.Body)
+		bodies <- string(bs)
+	}))
+	defer srv.Close()
+
+	tpl := actionable.NewTemplate("test", false)
+	tpl.Funcs(multiOutputFuncs(true))
+	must(tpl.Parse(`{{range .}}{{file (printf "%s.conf" .)}}server {{.}};{{end}}`))
+	dest := t.TempDir()
+	sink := &render.Sink{Templ: tpl, WriteTo: dest, MultiOutput: true}
+
+	webhook, err := makeWebhook("upstreams", dest, &cfg.WebhookSpec{URL: srv.URL, Body: `{{.Hash}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RenderAndExec(context.Background(), sink, webhook, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-bodies
+	if got == "" || got != sink.LastChange().NewHash {
+		t.Errorf("expected webhook hash %q got %q", sink.LastChange().NewHash, got)
+	}
+}
+
+func Test_RenderAndExec_groupChange(t *testing.T) {
+	tmp := t.TempDir()
+	envFile := tmp + "/env"
+	groups := makeExecGroups(context.Background(), map[string]*cfg.ExecGroupSpec{
+		"reload": {
+			ExecSpec: cfg.ExecSpec{Cmd: `env > "$1"`, Shell: true, CmdArgs: []string{envFile}},
+			Debounce: duration.Duration(time.Millisecond),
+		},
+	}, execState{dir: tmp}, newLogger())
+
+	tpl := actionable.NewTemplate("test", false)
+	must(tpl.Parse("Name {{.name}}"))
+	dest := tmp + "/app.conf"
+	member := groups["reload"].Member()
+	sink := &render.Sink{Templ: tpl, WriteTo: dest}
+	renderer := groupRenderer{Renderer: sink, member: member, name: "app", dest: dest}
+
+	if err := RenderAndExec(context.Background(), renderer, member, map[string]any{"name": "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	for _, kv := range strings.Split(string(bs), "\n") {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, "TPLAGENT_") {
+			env[k] = v
+		}
+	}
+	change := sink.LastChange()
+	expected := map[string]string{
+		"TPLAGENT_TEMPLATE":    "app",
+		"TPLAGENT_DESTINATION": dest,
+		"TPLAGENT_BACKUP":      "",
+		"TPLAGENT_OLD_HASH":    "",
+		"TPLAGENT_NEW_HASH":    change.NewHash,
+		"TPLAGENT_RENDERED_AT": change.RenderedAt.Format(time.RFC3339),
+	}
+	if diff := gocmp.Diff(expected, env); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func Test_RenderAndExec_cancelStopsBackoff(t *testing.T) {
+	dest := t.TempDir() + "/out.conf"
+	tpl := actionable.NewTemplate("test", false)
+	must(tpl.Parse("Name {{.name}}"))
+	sink := &render.Sink{Templ: tpl, WriteTo: dest}
+
+	var attemptCtxErr error
+	execer := &cmdexec.Retry{
+		Execer: execFunc(func(ctx context.Context) error {
+			attemptCtxErr = ctx.Err()
+			return &cmdexec.ExecErr{Status: 1}
+		}),
+		Retries: 5,
+		Backoff: time.Hour,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := RenderAndExec(ctx, sink, execer, map[string]any{"name": "foo"})
+	if !errors.As(err, new(*cmdexec.ExecErr)) {
+		t.Errorf("expected ExecErr got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected backoff to stop on cancel, took %s", elapsed)
+	}
+	if attemptCtxErr != nil {
+		t.Errorf("expected the attempt not to be cancelled got %v", attemptCtxErr)
+	}
+}
+
+type execFunc func(ctx context.Context) error
+
+func (f execFunc) ExecContext(ctx context.Context) error {
+	return f(ctx)
+}
+
+func Test_events(t *testing.T) {
+	tmp := t.TempDir()
+	bus := events.NewBus()
+	sub := bus.Subscribe(events.Filter{})
+
+	p := Proc{
+		Logger:            newLogger(),
+		TickFunc:          RenderAndExec,
+		Events:            bus,
+		refreshTriggers:   make(map[string]triggerFlow),
+		maxConsecFailures: defaultMaxConsecFailures,
+		configs: sanitizeConfigs(map[string]*cfg.TemplateSpec{
+			"app": {
+				Raw:         "hello",
+				Destination: tmp + "/app.conf",
+				RenderOnce:  true,
+				Exec:        &cfg.ExecSpec{Cmd: "false"},
+			},
+			"broken": {
+				Raw:         "{{.missing.field}}",
+				Destination: tmp + "/broken.conf",
+				RenderOnce:  true,
+				MissingKey:  "error",
+			},
+		}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_ = p.startTickLoops(ctx)
+	sub.Close()
+
+	got := map[string][]events.Type{}
+	for e := range sub.Events() {
+		got[e.Template] = append(got[e.Template], e.Type)
+		if e.Type == events.ExecResult && e.Error == "" {
+			t.Error("expected exec_result to carry the exec error")
+		}
+	}
+	expected := map[string][]events.Type{
+		"app":    {events.TemplateStarted, events.RenderWritten, events.ExecResult, events.TemplateStopped},
+		"broken": {events.TemplateStarted, events.RenderError, events.TemplateStopped},
+	}
+	if diff := gocmp.Diff(expected, got); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func Test_failedAction(t *testing.T) {
+	actions := []cfg.Actions{{Name: "http_json"}, {Name: "sample"}}
+	err := errors.New(`template: x:1:2: executing "x" at <http_json_get>: error calling http_json_get: 404`)
+	if action, ok := failedAction(err, actions); !ok || action != "http_json" {
+		t.Errorf("expected http_json got %q", action)
+	}
+	if _, ok := failedAction(errors.New("render failed"), actions); ok {
+		t.Error("expected no action")
+	}
+}
+
+func TestProc_TemplatesMatching(t *testing.T) {
+	p := Proc{configs: sanitizeConfigs(map[string]*cfg.TemplateSpec{
+		"payments-db":  {Raw: "a", Labels: map[string]string{"team": "payments", "tier": "db"}},
+		"payments-api": {Raw: "a", Labels: map[string]string{"team": "payments"}},
+		"search":       {Raw: "a", Labels: map[string]string{"team": "search"}},
+	})}
+
+	if diff := gocmp.Diff([]string{"payments-api", "payments-db"}, p.TemplatesMatching(map[string]string{"team": "payments"})); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+	if diff := gocmp.Diff([]string{"payments-db"}, p.TemplatesMatching(map[string]string{"team": "payments", "tier": "db"})); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"cmp"
+	"github.com/shubhang93/tplagent/internal/cmdexec"
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/health"
+	"time"
+)
+
+// wedgeMultiple of the refresh interval may pass
+// without a completed tick before a render
+// loop is reported as wedged
+const wedgeMultiple = 3
+
+// loopHealth is the state of a render loop, it
+// outlives the loop so that a loop stopped after
+// too many failures stays visible
+type loopHealth struct {
+	running  bool
+	rendered bool
+	failed   bool
+	failures int
+	lastTick time.Time
+	// wedgeAfter is zero for
+	// templates rendered once
+	wedgeAfter time.Duration
+}
+
+// wedgeAfter leaves room for the exec command, its
+// retries and their backoff on every tick, templates
+// of an exec group also wait for the group debounce
+func wedgeAfter(cfg sinkExecConfig, group *config.ExecGroupSpec) time.Duration {
+	if cfg.renderOnce {
+		return 0
+	}
+	d := wedgeMultiple * cfg.refreshInterval
+	if cfg.execConfig != nil {
+		d += execWait(cfg.execConfig)
+	}
+	if group != nil {
+		d += cmp.Or(time.Duration(group.Debounce), defaultExecGroupDebounce)
+		d += execWait(makeExecConfig(&group.ExecSpec))
+	}
+	return d
+}
+
+// execWait is the longest an exec
+// can take when every attempt fails
+func execWait(ec *execConfig) time.Duration {
+	r := cmdexec.Retry{Retries: ec.retries, Backoff: ec.backoff}
+	return time.Duration(ec.retries+1)*ec.timeout + r.TotalBackoff()
+}
+
+func (p *Proc) loopStarted(cfg sinkExecConfig) {
+	p.healthMU.Lock()
+	defer p.healthMU.Unlock()
+	if p.health == nil {
+		p.health = make(map[string]*loopHealth)
+	}
+	p.health[cfg.name] = &loopHealth{
+		running:    true,
+		lastTick:   time.Now(),
+		wedgeAfter: wedgeAfter(cfg, p.execGroupSpecs[cfg.execGroup]),
+	}
+}
+
+func (p *Proc) updateHealth(name string, update func(h *loopHealth)) {
+	p.healthMU.Lock()
+	defer p.healthMU.Unlock()
+	if h, ok := p.health[name]; ok {
+		update(h)
+	}
+}
+
+func (p *Proc) forgetHealth(name string) {
+	p.healthMU.Lock()
+	defer p.healthMU.Unlock()
+	delete(p.health, name)
+}
+
+// Health reports the render loop of every
+// configured template, templates waiting for
+// their dependencies are not running yet
+func (p *Proc) Health() []health.Template {
+	p.depsMU.RLock()
+	defer p.depsMU.RUnlock()
+	p.healthMU.Lock()
+	defer p.healthMU.Unlock()
+
+	now := time.Now()
+	templates := make([]health.Template, 0, len(p.configs))
+	for _, sc := range p.configs {
+		t := health.Template{Name: sc.name, Required: sc.required}
+		if h, ok := p.health[sc.name]; ok {
+			t.Running = h.running
+			t.Rendered = h.rendered
+			t.Failed = h.failed
+			t.ConsecutiveFailures = h.failures
+			t.LastTick = h.lastTick
+			t.Wedged = h.running && h.wedgeAfter > 0 && now.Sub(h.lastTick) > h.wedgeAfter
+		}
+		templates = append(templates, t)
+	}
+	return templates
+}
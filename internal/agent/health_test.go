@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	gocmp "github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	cfg "github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/duration"
+	"github.com/shubhang93/tplagent/internal/health"
+	"testing"
+	"time"
+)
+
+func TestProc_Health(t *testing.T) {
+	tmp := t.TempDir()
+	p := Proc{
+		Logger:            newLogger(),
+		TickFunc:          RenderAndExec,
+		refreshTriggers:   make(map[string]triggerFlow),
+		maxConsecFailures: 2,
+		configs: sanitizeConfigs(map[string]*cfg.TemplateSpec{
+			"app": {
+				Raw:         "hello",
+				Destination: tmp + "/app.conf",
+				RenderOnce:  true,
+				Required:    true,
+			},
+			"broken": {
+				Raw:             "{{.missing.field}}",
+				Destination:     tmp + "/broken.conf",
+				RefreshInterval: duration.Duration(10 * time.Millisecond),
+				MissingKey:      "error",
+				Required:        true,
+			},
+			"optional": {
+				Raw:             "hello",
+				Destination:     tmp + "/optional.conf",
+				RefreshInterval: duration.Duration(1 * time.Hour),
+			},
+		}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_ = p.startTickLoops(ctx)
+
+	expected := []health.Template{
+		{Name: "app", Required: true, Rendered: true},
+		{Name: "broken", Required: true, Failed: true, ConsecutiveFailures: 2},
+		{Name: "optional"},
+	}
+	got := p.Health()
+	ignoreTick := cmpopts.IgnoreFields(health.Template{}, "LastTick")
+	byName := cmpopts.SortSlices(func(a, b health.Template) bool { return a.Name < b.Name })
+	if diff := gocmp.Diff(expected, got, ignoreTick, byName); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+	if health.Readiness(got).OK {
+		t.Error("expected not ready after broken failed")
+	}
+}
+
+func TestProc_Health_wedged(t *testing.T) {
+	p := Proc{configs: sanitizeConfigs(map[string]*cfg.TemplateSpec{
+		"stuck": {Raw: "a", RefreshInterval: duration.Duration(time.Second)},
+		"once":  {Raw: "a", RenderOnce: true},
+	})}
+	for _, sc := range p.configs {
+		p.loopStarted(sc)
+	}
+	p.updateHealth("stuck", func(h *loopHealth) {
+		h.lastTick = time.Now().Add(-wedgeMultiple*time.Second - time.Second)
+	})
+	p.updateHealth("once", func(h *loopHealth) {
+		h.lastTick = time.Now().Add(-time.Hour)
+	})
+
+	for _, tmpl := range p.Health() {
+		if want := tmpl.Name == "stuck"; tmpl.Wedged != want {
+			t.Errorf("%s: expected wedged to be %t", tmpl.Name, want)
+		}
+	}
+}
+
+func Test_wedgeAfter(t *testing.T) {
+	exec := cfg.ExecSpec{
+		Cmd:          "reload",
+		CmdTimeout:   duration.Duration(5 * time.Second),
+		Retries:      6,
+		RetryBackoff: duration.Duration(4 * time.Second),
+	}
+	tests := map[string]struct {
+		spec     *cfg.TemplateSpec
+		group    *cfg.ExecGroupSpec
+		expected time.Duration
+	}{
+		"no exec": {
+			spec:     &cfg.TemplateSpec{Raw: "a", RefreshInterval: duration.Duration(time.Second)},
+			expected: 3 * time.Second,
+		},
+		"backoff is capped": {
+			spec: &cfg.TemplateSpec{Raw: "a", RefreshInterval: duration.Duration(time.Second), Exec: &exec},
+			// 7 attempts and waits of 4s 8s 16s 30s 30s 30s
+			expected: 3*time.Second + 7*5*time.Second + 118*time.Second,
+		},
+		"exec group": {
+			spec:  &cfg.TemplateSpec{Raw: "a", RefreshInterval: duration.Duration(time.Second), ExecGroup: "reload"},
+			group: &cfg.ExecGroupSpec{ExecSpec: exec, Debounce: duration.Duration(2 * time.Second)},
+			// debounce, 7 attempts and the backoff
+			expected: 3*time.Second + 2*time.Second + 7*5*time.Second + 118*time.Second,
+		},
+		"render once": {
+			spec: &cfg.TemplateSpec{Raw: "a", RenderOnce: true, Exec: &exec},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			sc := sanitizeConfigs(map[string]*cfg.TemplateSpec{"a": tt.spec})[0]
+			if got := wedgeAfter(sc, tt.group); got != tt.expected {
+				t.Errorf("expected %v got %v", tt.expected, got)
+			}
+		})
+	}
+}
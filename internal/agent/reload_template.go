@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/events"
+	"log/slog"
+)
+
+// ReloadTemplate applies conf to the template
+// name without restarting the other render loops.
+// The render loops of name and of the templates
+// depending on it directly or transitively are
+// restarted, the render loop of name is stopped
+// if conf does not have the template
+func (p *Proc) ReloadTemplate(conf config.TPLAgent, name string) error {
+	p.reloadMU.Lock()
+	defer p.reloadMU.Unlock()
+
+	p.Events.Publish(events.Event{Type: events.ReloadBegun, Template: name})
+	if err := p.reloadTemplate(conf, name); err != nil {
+		p.Events.Publish(events.Event{Type: events.ReloadFailed, Template: name, Error: err.Error()})
+		return err
+	}
+	p.Events.Publish(events.Event{Type: events.ReloadCompleted, Template: name})
+	return nil
+}
+
+func (p *Proc) reloadTemplate(conf config.TPLAgent, name string) error {
+	order, err := config.DependencyOrder(conf.TemplateSpecs)
+	if err != nil {
+		return err
+	}
+
+	scs := sanitizeConfigs(conf.TemplateSpecs)
+	addGlobalIncludes(scs, conf.Agent.Includes)
+
+	byName := make(map[string]sinkExecConfig, len(scs))
+	for _, sc := range scs {
+		byName[sc.name] = sc
+	}
+
+	// order has dependencies first so templates
+	// depending on name through others are found
+	affected := map[string]bool{name: true}
+	for _, tmplName := range order {
+		for dep := range byName[tmplName].dependsOn {
+			if affected[dep] {
+				affected[tmplName] = true
+				break
+			}
+		}
+	}
+
+	p.stopLoops(affected)
+
+	// only the configs of restarted loops are
+	// replaced, the other loops keep running
+	// with the config they were started with
+	p.depsMU.Lock()
+	configs := make([]sinkExecConfig, 0, len(p.configs)+1)
+	for _, sc := range p.configs {
+		if !affected[sc.name] {
+			configs = append(configs, sc)
+		}
+	}
+	for _, tmplName := range order {
+		if affected[tmplName] {
+			configs = append(configs, byName[tmplName])
+		}
+	}
+	p.configs = configs
+	p.relinkDependencies()
+	p.depsMU.Unlock()
+
+	for _, tmplName := range order {
+		if !affected[tmplName] {
+			continue
+		}
+		p.Logger.Info("reloading template", slog.String("templ", tmplName))
+		if err := p.spawnLoop(byName[tmplName]); err != nil {
+			return err
+		}
+	}
+	if _, ok := byName[name]; !ok {
+		p.forgetHealth(name)
+		p.Logger.Info("template removed", slog.String("templ", name))
+	}
+	return nil
+}
+
+// stopLoops cancels the render loops
+// of names and waits for them to exit
+func (p *Proc) stopLoops(names map[string]bool) {
+	var stopped []*renderLoop
+	p.loopsMU.Lock()
+	for name := range names {
+		if loop, ok := p.loops[name]; ok {
+			loop.cancel()
+			stopped = append(stopped, loop)
+		}
+	}
+	p.loopsMU.Unlock()
+
+	for _, loop := range stopped {
+		<-loop.done
+	}
+}
+
+// relinkDependencies rebuilds the reverse dependency
+// lookup, gates and change channels of running
+// templates are kept, depsMU must be held
+func (p *Proc) relinkDependencies() {
+	gates := make(map[string]*renderGate, len(p.configs))
+	upstreamChanged := make(map[string]chan struct{}, len(p.configs))
+	p.dependents = make(map[string][]string)
+	for _, sc := range p.configs {
+		gate, ok := p.renderGates[sc.name]
+		if !ok {
+			gate = &renderGate{done: make(chan struct{})}
+		}
+		gates[sc.name] = gate
+
+		changed, ok := p.upstreamChanged[sc.name]
+		if !ok {
+			changed = make(chan struct{}, 1)
+		}
+		upstreamChanged[sc.name] = changed
+
+		for dep := range sc.dependsOn {
+			p.dependents[dep] = append(p.dependents[dep], sc.name)
+		}
+	}
+	p.renderGates = gates
+	p.upstreamChanged = upstreamChanged
+}
@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"context"
+	"maps"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	gocmp "github.com/google/go-cmp/cmp"
+	cfg "github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/events"
+)
+
+func TestProc_ReloadTemplate(t *testing.T) {
+	tmp := t.TempDir()
+	makeConf := func(appRaw string) cfg.TPLAgent {
+		specs := map[string]*cfg.TemplateSpec{
+			"db": {Raw: "db v1", Destination: tmp + "/db.conf", RenderOnce: true},
+		}
+		if appRaw != "" {
+			specs["app"] = &cfg.TemplateSpec{Raw: appRaw, Destination: tmp + "/app.conf", RenderOnce: true}
+		}
+		return cfg.TPLAgent{Agent: cfg.Agent{LogFmt: "text"}, TemplateSpecs: specs}
+	}
+
+	var mu sync.Mutex
+	renders := map[string]int{}
+	p := Proc{
+		Logger:   newLogger(),
+		TickFunc: RenderAndExec,
+		RenderHook: func(name string, _ bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			renders[name]++
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.Start(ctx, makeConf("app v1"))
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	if err := p.ReloadTemplate(makeConf("app v2"), "app"); err != nil {
+		t.Fatalf("reload error:%v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	bs, err := os.ReadFile(tmp + "/app.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "app v2" {
+		t.Errorf("expected app v2 got %s", bs)
+	}
+
+	if err := p.ReloadTemplate(makeConf(""), "app"); err != nil {
+		t.Fatalf("reload error:%v", err)
+	}
+	if diff := gocmp.Diff([]string{"db"}, p.Templates()); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := gocmp.Diff(map[string]int{"app": 2, "db": 1}, renders); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func TestProc_ReloadTemplate_transitiveDependents(t *testing.T) {
+	tmp := t.TempDir()
+	conf := cfg.TPLAgent{Agent: cfg.Agent{LogFmt: "text"}, TemplateSpecs: map[string]*cfg.TemplateSpec{
+		"db":    {Raw: "db", Destination: tmp + "/db.conf", RenderOnce: true},
+		"app":   {Raw: "app", Destination: tmp + "/app.conf", RenderOnce: true, DependsOn: []string{"db"}},
+		"web":   {Raw: "web", Destination: tmp + "/web.conf", RenderOnce: true, DependsOn: []string{"app"}},
+		"other": {Raw: "other", Destination: tmp + "/other.conf", RenderOnce: true},
+	}}
+
+	var mu sync.Mutex
+	renders := map[string]int{}
+	p := Proc{
+		Logger:   newLogger(),
+		TickFunc: RenderAndExec,
+		RenderHook: func(name string, _ bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			renders[name]++
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.Start(ctx, conf)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	started := maps.Clone(renders)
+	mu.Unlock()
+
+	if err := p.ReloadTemplate(conf, "db"); err != nil {
+		t.Fatalf("reload error:%v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	reloaded := map[string]int{}
+	for name, n := range renders {
+		reloaded[name] = n - started[name]
+	}
+	if diff := gocmp.Diff(map[string]int{"db": 1, "app": 1, "web": 1, "other": 0}, reloaded); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func TestProc_ReloadTemplate_failed(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe(events.Filter{})
+	defer sub.Close()
+	p := Proc{Logger: newLogger(), TickFunc: RenderAndExec, Events: bus}
+
+	conf := cfg.TPLAgent{Agent: cfg.Agent{LogFmt: "text"}, TemplateSpecs: map[string]*cfg.TemplateSpec{
+		"a": {Raw: "a", DependsOn: []string{"b"}},
+		"b": {Raw: "b", DependsOn: []string{"a"}},
+	}}
+	if err := p.ReloadTemplate(conf, "a"); err == nil {
+		t.Fatal("expected a dependency cycle error")
+	}
+
+	var types []events.Type
+	for len(sub.Events()) > 0 {
+		e := <-sub.Events()
+		if e.Type == events.ReloadFailed && e.Error == "" {
+			t.Error("expected reload_failed to carry the error")
+		}
+		types = append(types, e.Type)
+	}
+	if diff := gocmp.Diff([]events.Type{events.ReloadBegun, events.ReloadFailed}, types); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func TestProc_ReloadTemplate_keepsOtherConfigs(t *testing.T) {
+	tmp := t.TempDir()
+	makeConf := func(dbTier string, extra bool) cfg.TPLAgent {
+		specs := map[string]*cfg.TemplateSpec{
+			"db":  {Raw: "db", Destination: tmp + "/db.conf", RenderOnce: true, Labels: map[string]string{"tier": dbTier}},
+			"app": {Raw: "app", Destination: tmp + "/app.conf", RenderOnce: true, Labels: map[string]string{"tier": "web"}},
+		}
+		if extra {
+			specs["extra"] = &cfg.TemplateSpec{Raw: "extra", Destination: tmp + "/extra.conf", RenderOnce: true, Labels: map[string]string{"tier": "web"}}
+		}
+		return cfg.TPLAgent{Agent: cfg.Agent{LogFmt: "text"}, TemplateSpecs: specs}
+	}
+
+	p := Proc{Logger: newLogger(), TickFunc: RenderAndExec}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- p.Start(ctx, makeConf("storage", false))
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// db and extra changed on disk but
+	// only app is reloaded
+	if err := p.ReloadTemplate(makeConf("web", true), "app"); err != nil {
+		t.Fatalf("reload error:%v", err)
+	}
+	if diff := gocmp.Diff([]string{"app"}, p.TemplatesMatching(map[string]string{"tier": "web"})); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+	if diff := gocmp.Diff([]string{"db"}, p.TemplatesMatching(map[string]string{"tier": "storage"})); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
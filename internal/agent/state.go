@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// execState names the files marking failed
+// execs across restarts, they are kept in dir
+// and keyed by the config of the agent so that
+// agents sharing dir do not clear each other's,
+// no files are kept without a dir
+type execState struct {
+	dir    string
+	config string
+}
+
+func newExecState(stateDir string, configPath string) execState {
+	dir := defaultStateDir()
+	if stateDir != "" {
+		dir = os.ExpandEnv(stateDir)
+	}
+	if abs, err := filepath.Abs(configPath); err == nil && configPath != "" {
+		configPath = abs
+	}
+	return execState{dir: dir, config: configPath}
+}
+
+// defaultStateDir follows the XDG base directory
+// spec, root agents use /var/lib/tplagent
+func defaultStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "tplagent")
+	}
+	if os.Geteuid() == 0 {
+		return "/var/lib/tplagent"
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "tplagent")
+	}
+	return filepath.Join(os.TempDir(), "tplagent")
+}
+
+// templateFile marks a failed exec of the template,
+// it is kept out of the destination so that other tools
+// reading the destination directory do not see it
+func (s execState) templateFile(name string, dest string) string {
+	if s.dir == "" {
+		return ""
+	}
+	return filepath.Join(s.dir, fmt.Sprintf("template-%s-%s.exec-pending", name, s.key(dest)))
+}
+
+// groupFile marks the failed exec of a group,
+// groups run on behalf of several templates
+func (s execState) groupFile(name string) string {
+	if s.dir == "" {
+		return ""
+	}
+	return filepath.Join(s.dir, fmt.Sprintf("exec-group-%s-%s.exec-pending", name, s.key("")))
+}
+
+func (s execState) key(dest string) string {
+	sum := sha256.Sum256([]byte(s.config + "\x00" + dest))
+	return hex.EncodeToString(sum[:8])
+}
+
+// makePendingDir returns pendingFile or an empty name
+// when its directory cannot be created, the exec is
+// then not run again after failing
+func makePendingDir(pendingFile string, logger *slog.Logger) string {
+	if pendingFile == "" {
+		return ""
+	}
+	if err := os.MkdirAll(filepath.Dir(pendingFile), 0700); err != nil {
+		logger.Error("exec pending dir error", slog.String("error", err.Error()))
+		return ""
+	}
+	return pendingFile
+}
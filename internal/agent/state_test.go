@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_newExecState(t *testing.T) {
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+
+	if got := newExecState("", "agent.json").dir; got != filepath.Join(stateHome, "tplagent") {
+		t.Errorf("expected XDG state dir got %s", got)
+	}
+	t.Setenv("STATE_ROOT", "/srv/state")
+	if got := newExecState("${STATE_ROOT}/tplagent", "agent.json").dir; got != "/srv/state/tplagent" {
+		t.Errorf("expected configured state dir got %s", got)
+	}
+}
+
+func Test_execState_files(t *testing.T) {
+	a := execState{dir: "/var/lib/tplagent", config: "/etc/tplagent/a.json"}
+	b := execState{dir: "/var/lib/tplagent", config: "/etc/tplagent/b.json"}
+
+	if a.templateFile("app", "/etc/app.conf") == b.templateFile("app", "/etc/app.conf") {
+		t.Error("expected agents with different configs to use different files")
+	}
+	if a.templateFile("app", "/etc/app.conf") == a.templateFile("app", "/etc/app-2.conf") {
+		t.Error("expected different destinations to use different files")
+	}
+	if a.groupFile("reload") == b.groupFile("reload") {
+		t.Error("expected groups of different configs to use different files")
+	}
+	if got := (execState{}).templateFile("app", "/etc/app.conf"); got != "" {
+		t.Errorf("expected no file without a state dir got %s", got)
+	}
+}
@@ -1,12 +1,17 @@
 package agent
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/shubhang93/tplagent/internal/actionable"
 	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/render"
 	"github.com/shubhang93/tplagent/internal/tplactions"
+	"gopkg.in/yaml.v3"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
 )
@@ -69,5 +74,145 @@ func parseTemplate(raw string, readFrom string, pt *actionable.Template) error {
 		return err
 	}
 
-	return pt.Parse(string(bs))
+	if err := pt.Parse(string(bs)); err != nil {
+		return fmt.Errorf("%s:%w", expandedPath, err)
+	}
+	return nil
+}
+
+// parseIncludes parses the files matching the glob
+// patterns as templates associated with pt, files are
+// named by their path so that errors point at the
+// file and line, definitions parsed later win
+func parseIncludes(patterns []string, pt *actionable.Template) error {
+	seen := make(map[string]struct{})
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(os.ExpandEnv(pattern))
+		if err != nil {
+			return fmt.Errorf("invalid include pattern %s:%w", pattern, err)
+		}
+		if len(matches) < 1 {
+			return fmt.Errorf("include pattern %s did not match any files", pattern)
+		}
+
+		for _, path := range matches {
+			if _, ok := seen[path]; ok {
+				continue
+			}
+			seen[path] = struct{}{}
+
+			bs, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := pt.ParseNamed(path, string(bs)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const templateFileExt = ".tmpl"
+
+// parseTemplateDir parses every .tmpl file under dir
+// as a named template, the root template renders each
+// of them into a file of the same relative path
+// with the .tmpl extension stripped
+func parseTemplateDir(dir string, delims []string, pt *actionable.Template) error {
+	left, right := "{{", "}}"
+	if len(delims) == 2 {
+		left, right = delims[0], delims[1]
+	}
+
+	var root strings.Builder
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != templateFileExt {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := pt.ParseNamed(rel, string(bs)); err != nil {
+			return err
+		}
+
+		outName := strings.TrimSuffix(rel, templateFileExt)
+		_, _ = fmt.Fprintf(&root, "%sfile %q%s%stemplate %q .%s", left, outName, right, left, rel, right)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if root.Len() < 1 {
+		return fmt.Errorf("no %s files found in %s", templateFileExt, dir)
+	}
+	return pt.Parse(root.String())
+}
+
+// dependencyFuncs returns the functions used to
+// read the last rendered output of the templates
+// listed in depends_on, deps maps the name of
+// each upstream template to its destination
+func dependencyFuncs(deps map[string]string) template.FuncMap {
+	readDep := func(name string) (string, []byte, error) {
+		dest, ok := deps[name]
+		if !ok {
+			return "", nil, fmt.Errorf("template %s is not listed in depends_on", name)
+		}
+		bs, err := os.ReadFile(dest)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading output of %s:%w", name, err)
+		}
+		return dest, bs, nil
+	}
+
+	return template.FuncMap{
+		"dependency": func(name string) (any, error) {
+			dest, bs, err := readDep(name)
+			if err != nil {
+				return nil, err
+			}
+
+			var data any
+			switch filepath.Ext(dest) {
+			case ".yaml", ".yml":
+				err = yaml.Unmarshal(bs, &data)
+			default:
+				err = json.Unmarshal(bs, &data)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error parsing output of %s:%w", name, err)
+			}
+			return data, nil
+		},
+		"dependencyRaw": func(name string) (string, error) {
+			_, bs, err := readDep(name)
+			return string(bs), err
+		},
+	}
+}
+
+// multiOutputFuncs returns the file function
+// which starts a new output file when the
+// template is rendered in multi output mode
+func multiOutputFuncs(enabled bool) template.FuncMap {
+	return template.FuncMap{
+		"file": func(name string) (string, error) {
+			if !enabled {
+				return "", fmt.Errorf("file %s:multi_output is not enabled", name)
+			}
+			return render.FileMarker(name)
+		},
+	}
 }
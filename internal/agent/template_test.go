@@ -6,8 +6,11 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/shubhang93/tplagent/internal/actionable"
 	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/render"
 	"github.com/shubhang93/tplagent/internal/tplactions"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"text/template"
@@ -205,3 +208,112 @@ hello Foo`
 		}
 	})
 }
+
+func Test_parseTemplateDir(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"app.conf.tmpl":         "port=<<.port>>",
+		"sites/default.tmpl":    "host=<<.host>>",
+		"sites/ignored.conf":    "not a template",
+		"sites/nested/api.tmpl": "api=<<.host>>:<<.port>>",
+	}
+	for name, contents := range files {
+		path := filepath.Join(src, name)
+		must(os.MkdirAll(filepath.Dir(path), 0755))
+		must(os.WriteFile(path, []byte(contents), 0755))
+	}
+
+	templ := actionable.NewTemplate("tree", false)
+	setTemplateDelims(templ, []string{"<<", ">>"})
+	templ.Funcs(multiOutputFuncs(true))
+	if err := parseTemplateDir(src, []string{"<<", ">>"}, templ); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dest := t.TempDir()
+	sink := render.Sink{Templ: templ, WriteTo: dest, MultiOutput: true}
+	if err := sink.Render(map[string]any{"port": 8080, "host": "foo"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]string{
+		"app.conf":         "port=8080",
+		"sites/default":    "host=foo",
+		"sites/nested/api": "api=foo:8080",
+	}
+	for name, want := range expected {
+		bs, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if diff := cmp.Diff(want, string(bs)); diff != "" {
+			t.Errorf("%s (--Want ++Got):\n%s", name, diff)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "sites/ignored")); !os.IsNotExist(err) {
+		t.Error("non template file was rendered")
+	}
+
+	t.Run("empty dir", func(t *testing.T) {
+		templ := actionable.NewTemplate("tree", false)
+		if err := parseTemplateDir(t.TempDir(), nil, templ); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func Test_parseIncludes(t *testing.T) {
+	dir := t.TempDir()
+	must(os.WriteFile(dir+"/upstream.tmpl", []byte(`{{define "upstream"}}upstream {{.name}} { server {{.addr}}; }{{end}}`), 0755))
+	must(os.WriteFile(dir+"/footer.tmpl", []byte(`{{define "footer"}}# managed by tplagent{{end}}`), 0755))
+	must(os.WriteFile(dir+"/broken.partial", []byte("ok\n{{.name"), 0755))
+
+	t.Run("includes are shared across files", func(t *testing.T) {
+		templ := actionable.NewTemplate("test", false)
+		raw := `{{template "upstream" .}}
+{{block "footer" .}}# default footer{{end}}`
+		if err := parseTemplate(raw, "", templ); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := parseIncludes([]string{dir + "/*.tmpl"}, templ); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var buff bytes.Buffer
+		if err := templ.Execute(&buff, map[string]string{"name": "api", "addr": "10.0.0.1"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := `upstream api { server 10.0.0.1; }
+# managed by tplagent`
+		if diff := cmp.Diff(expected, buff.String()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("errors report the file and line", func(t *testing.T) {
+		templ := actionable.NewTemplate("test", false)
+		err := parseIncludes([]string{dir + "/*.partial"}, templ)
+		if err == nil {
+			t.Error("expected an error")
+			return
+		}
+		if want := dir + "/broken.partial:2"; !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error %q to contain %q", err.Error(), want)
+		}
+	})
+
+	t.Run("pattern without matches", func(t *testing.T) {
+		templ := actionable.NewTemplate("test", false)
+		if err := parseIncludes([]string{dir + "/*.missing"}, templ); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
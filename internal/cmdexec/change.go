@@ -0,0 +1,109 @@
+package cmdexec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Change is the context of the render which
+// triggered an exec, it is passed to the command
+// as TPLAGENT_* env vars and is the data for the
+// args and env values of commands which expand
+// templates
+type Change struct {
+	Template    string
+	Destination string
+	Backup      string
+	OldHash     string
+	NewHash     string
+	RenderedAt  time.Time
+}
+
+type changeKey struct{}
+
+func WithChange(ctx context.Context, change Change) context.Context {
+	return context.WithValue(ctx, changeKey{}, change)
+}
+
+func changeFrom(ctx context.Context) Change {
+	change, _ := ctx.Value(changeKey{}).(Change)
+	return change
+}
+
+func (c Change) environ() []string {
+	renderedAt := ""
+	if !c.RenderedAt.IsZero() {
+		renderedAt = c.RenderedAt.Format(time.RFC3339)
+	}
+	return []string{
+		"TPLAGENT_TEMPLATE=" + c.Template,
+		"TPLAGENT_DESTINATION=" + c.Destination,
+		"TPLAGENT_BACKUP=" + c.Backup,
+		"TPLAGENT_OLD_HASH=" + c.OldHash,
+		"TPLAGENT_NEW_HASH=" + c.NewHash,
+		"TPLAGENT_RENDERED_AT=" + renderedAt,
+	}
+}
+
+// ExpandValue executes value as a template with
+// change as its data, values without actions
+// are returned as is
+func ExpandValue(value string, change Change) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	t, err := template.New("value").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, change); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// sampleChange stands in for a render when
+// values are checked before any render happened
+var sampleChange = Change{
+	Template:    "template",
+	Destination: "/destination",
+	Backup:      "/destination.bak",
+	OldHash:     strings.Repeat("0", 64),
+	NewHash:     strings.Repeat("f", 64),
+	RenderedAt:  time.Unix(0, 0).UTC(),
+}
+
+// CheckValue reports the errors ExpandValue
+// would return for value at exec time
+func CheckValue(value string) error {
+	_, err := ExpandValue(value, sampleChange)
+	return err
+}
+
+func expandArgs(args []string, change Change) ([]string, error) {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		value, err := ExpandValue(arg, change)
+		if err != nil {
+			return nil, fmt.Errorf("expand arg %q:%w", arg, err)
+		}
+		expanded[i] = value
+	}
+	return expanded, nil
+}
+
+func expandEnv(env map[string]string, change Change) (map[string]string, error) {
+	expanded := make(map[string]string, len(env))
+	for k, v := range env {
+		value, err := ExpandValue(v, change)
+		if err != nil {
+			return nil, fmt.Errorf("expand env %s:%w", k, err)
+		}
+		expanded[k] = value
+	}
+	return expanded, nil
+}
@@ -0,0 +1,81 @@
+package cmdexec
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefault_change(t *testing.T) {
+	temp := t.TempDir()
+	out := filepath.Join(temp, "out")
+	renderedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	d := Default{
+		Cmd: `printf '%s|%s|%s|%s|%s|%s|%s|%s' "$1" "$TPLAGENT_TEMPLATE" "$TPLAGENT_DESTINATION" "$TPLAGENT_BACKUP" ` +
+			`"$TPLAGENT_OLD_HASH" "$TPLAGENT_NEW_HASH" "$TPLAGENT_RENDERED_AT" "$SHORT_HASH" > ` + out,
+		Args:            []string{"reload {{.Template}}"},
+		Env:             map[string]string{"SHORT_HASH": `{{slice .NewHash 0 4}}`},
+		Shell:           true,
+		Timeout:         5 * time.Second,
+		ExpandTemplates: true,
+		Template:        "nginx-conf",
+		Destination:     "/etc/nginx/nginx.conf",
+	}
+
+	ctx := WithChange(context.Background(), Change{
+		Backup:     "/etc/nginx/nginx.conf.bak",
+		OldHash:    "0000aaaa",
+		NewHash:    "ffffbbbb",
+		RenderedAt: renderedAt,
+	})
+	if err := d.ExecContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "reload nginx-conf|nginx-conf|/etc/nginx/nginx.conf|/etc/nginx/nginx.conf.bak|0000aaaa|ffffbbbb|2024-05-01T10:00:00Z|ffff"
+	if got := string(bs); got != expected {
+		t.Errorf("expected %s got %s", expected, got)
+	}
+}
+
+func TestDefault_changeWithoutExpansion(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	d := Default{
+		Cmd:     `printf '%s|%s' "$1" "$FORMAT" > ` + out,
+		Args:    []string{"{{.State.Pid}}"},
+		Env:     map[string]string{"FORMAT": "{{json .}}"},
+		Shell:   true,
+		Timeout: 5 * time.Second,
+	}
+	if err := d.ExecContext(WithChange(context.Background(), Change{Template: "app"})); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{{.State.Pid}}|{{json .}}"; string(bs) != expected {
+		t.Errorf("expected %s got %s", expected, string(bs))
+	}
+}
+
+func TestExpandValue(t *testing.T) {
+	change := Change{Template: "app"}
+	if got, err := ExpandValue("{{ .Template }}.conf", change); err != nil || got != "app.conf" {
+		t.Errorf("expected app.conf got %q %v", got, err)
+	}
+	if got, err := ExpandValue("no actions", change); err != nil || got != "no actions" {
+		t.Errorf("expected value as is got %q %v", got, err)
+	}
+	if _, err := ExpandValue("{{ .Unknown }}", change); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
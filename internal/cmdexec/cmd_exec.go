@@ -1,24 +1,56 @@
 package cmdexec
 
 import (
-	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
 	"os/exec"
+	"syscall"
 	"time"
 )
 
+const defaultOutputLimit = 4096
+
+// waitDelay bounds the wait for the output
+// pipes after the process group is killed
+const waitDelay = time.Second
+
 type Default struct {
 	Args    []string
 	Cmd     string
 	Env     map[string]string
 	Timeout time.Duration
+	// Shell runs Cmd with /bin/sh -c,
+	// Args are passed as $1, $2...
+	Shell bool
+	Dir   string
+	// InheritEnv adds the environment of
+	// the agent to Env
+	InheritEnv bool
+	// OutputLimit is the number of bytes of stdout
+	// and stderr kept each, defaults to 4096
+	OutputLimit int
+	// User and Group run the command as a different
+	// user, both accept names and numeric IDs
+	User  string
+	Group string
+	// ExpandTemplates executes Args and Env
+	// values as templates against the Change
+	ExpandTemplates bool
+	Logger          *slog.Logger
+	// Template and Destination are passed
+	// to the command as part of the Change
+	Template    string
+	Destination string
 }
 
 type ExecErr struct {
 	Status int
 	Stderr []byte
+	Stdout []byte
 }
 
 func (e *ExecErr) Error() string {
@@ -30,16 +62,61 @@ func (d *Default) ExecContext(ctx context.Context) error {
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, d.Timeout)
 	defer cancel()
 
-	cmdPath, err := exec.LookPath(d.Cmd)
+	change := changeFrom(ctx)
+	// commands of exec groups have no template
+	// of their own and keep the one of ctx
+	change.Template = cmp.Or(d.Template, change.Template)
+	change.Destination = cmp.Or(d.Destination, change.Destination)
+
+	args, env := d.Args, d.Env
+	if d.ExpandTemplates {
+		var err error
+		if args, err = expandArgs(d.Args, change); err != nil {
+			return err
+		}
+		if env, err = expandEnv(d.Env, change); err != nil {
+			return err
+		}
+	}
+
+	name := d.Cmd
+	if d.Shell {
+		name, args = "/bin/sh", append([]string{"-c", d.Cmd, "sh"}, args...)
+	}
+
+	cmdPath, err := exec.LookPath(name)
 	if errors.Is(err, exec.ErrNotFound) {
 		return err
 	}
 
-	cmd := exec.CommandContext(ctxWithTimeout, cmdPath, d.Args...)
+	cmd := exec.CommandContext(ctxWithTimeout, cmdPath, args...)
+	cmd.Dir = d.Dir
 
-	setEnv(cmd, d.Env)
-	stderr := bytes.Buffer{}
-	cmd.Stderr = &stderr
+	cred, err := lookupCredential(d.User, d.Group)
+	if err != nil {
+		return err
+	}
+
+	// the command runs in its own process
+	// group so that the children it spawns
+	// are killed with it on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+
+	setEnv(cmd, env, d.InheritEnv)
+	cmd.Env = append(cmd.Env, change.environ()...)
+
+	limit := d.OutputLimit
+	if limit <= 0 {
+		limit = defaultOutputLimit
+	}
+	stdout := &limitedBuffer{limit: limit}
+	stderr := &limitedBuffer{limit: limit}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	runErr := cmd.Run()
 
@@ -48,16 +125,29 @@ func (d *Default) ExecContext(ctx context.Context) error {
 		return &ExecErr{
 			Status: exitErr.ExitCode(),
 			Stderr: stderr.Bytes(),
+			Stdout: stdout.Bytes(),
 		}
 	}
 
 	if runErr != nil {
 		return fmt.Errorf("command failed with error:%w", runErr)
 	}
+
+	if d.Logger != nil && (stdout.Len() > 0 || stderr.Len() > 0) {
+		d.Logger.Info("exec output",
+			slog.String("cmd", d.Cmd),
+			slog.String("stdout", string(stdout.Bytes())),
+			slog.String("stderr", string(stderr.Bytes())))
+	}
 	return nil
 }
 
-func setEnv(c *exec.Cmd, env map[string]string) {
+func setEnv(c *exec.Cmd, env map[string]string, inherit bool) {
+	// a nil Env inherits the environment
+	c.Env = []string{}
+	if inherit {
+		c.Env = os.Environ()
+	}
 	for k, v := range env {
 		c.Env = append(c.Env, fmt.Sprintf("%s=%s", k, v))
 	}
@@ -1,9 +1,15 @@
 package cmdexec
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -108,3 +114,124 @@ echo "hello world from script"
 	}
 
 }
+
+func TestDefault_options(t *testing.T) {
+	t.Run("shell mode passes args as positional params", func(t *testing.T) {
+		temp := t.TempDir()
+		d := Default{
+			Cmd:     `echo -n "$1-$2" > out`,
+			Args:    []string{"a", "b"},
+			Shell:   true,
+			Dir:     temp,
+			Timeout: 5 * time.Second,
+		}
+		if err := d.ExecContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		bs, err := os.ReadFile(filepath.Join(temp, "out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(bs); got != "a-b" {
+			t.Errorf("expected a-b got %s", got)
+		}
+	})
+
+	t.Run("env is inherited", func(t *testing.T) {
+		t.Setenv("TPLAGENT_INHERITED", "yes")
+		cases := map[bool]int{true: 0, false: 3}
+		for inherit, status := range cases {
+			d := Default{
+				Cmd:        `[ "$TPLAGENT_INHERITED$EXTRA" = "yes1" ] || exit 3`,
+				Env:        map[string]string{"EXTRA": "1"},
+				InheritEnv: inherit,
+				Shell:      true,
+				Timeout:    5 * time.Second,
+			}
+			err := d.ExecContext(context.Background())
+			var execErr *ExecErr
+			if status == 0 && err != nil {
+				t.Errorf("inherit %v:expected no error got %v", inherit, err)
+			}
+			if status != 0 && (!errors.As(err, &execErr) || execErr.Status != status) {
+				t.Errorf("inherit %v:expected status %d got %v", inherit, status, err)
+			}
+		}
+	})
+
+	t.Run("output is captured up to the limit", func(t *testing.T) {
+		d := Default{
+			Cmd:         `echo -n 0123456789; echo -n oops >&2; exit 1`,
+			Shell:       true,
+			OutputLimit: 4,
+			Timeout:     5 * time.Second,
+		}
+		err := d.ExecContext(context.Background())
+		var execErr *ExecErr
+		if !errors.As(err, &execErr) {
+			t.Fatalf("expected ExecErr got %v", err)
+		}
+		if got := string(execErr.Stdout); got != "0123...(6 bytes truncated)" {
+			t.Errorf("unexpected stdout %q", got)
+		}
+		if got := string(execErr.Stderr); got != "oops" {
+			t.Errorf("unexpected stderr %q", got)
+		}
+	})
+
+	t.Run("process group is killed on timeout", func(t *testing.T) {
+		temp := t.TempDir()
+		pidFile := filepath.Join(temp, "child.pid")
+		d := Default{
+			Cmd:     fmt.Sprintf(`sleep 30 & echo $! > %s; wait`, pidFile),
+			Shell:   true,
+			Timeout: 300 * time.Millisecond,
+		}
+		start := time.Now()
+		if err := d.ExecContext(context.Background()); err == nil {
+			t.Fatal("expected timeout error")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("exec took %s", elapsed)
+		}
+
+		bs, err := os.ReadFile(pidFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if processAlive(pid) {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+			t.Errorf("child process %d leaked", pid)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		d := Default{
+			Cmd:     "true",
+			User:    "no-such-user-tplagent",
+			Timeout: 5 * time.Second,
+		}
+		if err := d.ExecContext(context.Background()); err == nil {
+			t.Error("expected lookup error")
+		}
+	})
+}
+
+// processAlive ignores zombies, orphans may
+// not be reaped right away inside containers
+func processAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) < 1 || fields[0] != "Z"
+}
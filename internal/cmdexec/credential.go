@@ -0,0 +1,100 @@
+package cmdexec
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// lookupCredential resolves user and group names or IDs,
+// the primary group of the user is used if group is
+// empty, nil is returned if both are empty, commands
+// run as a user only keep the groups of that user.
+// Numeric users without a passwd entry have no primary
+// group and require group to be set
+func lookupCredential(userName string, groupName string) (*syscall.Credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+
+	cred := &syscall.Credential{
+		Uid:         uint32(syscall.Getuid()),
+		Gid:         uint32(syscall.Getgid()),
+		NoSetGroups: true,
+	}
+
+	if userName != "" {
+		uid, gid, groups, err := lookupUser(userName)
+		if errors.Is(err, errNoPrimaryGroup) && groupName != "" {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cred.Uid, cred.Gid, cred.Groups = uid, gid, groups
+		// the supplementary groups of the
+		// agent are replaced with groups
+		cred.NoSetGroups = false
+	}
+
+	if groupName != "" {
+		gid, err := lookupGroup(groupName)
+		if err != nil {
+			return nil, err
+		}
+		cred.Gid = gid
+	}
+	return cred, nil
+}
+
+// errNoPrimaryGroup is returned for numeric users
+// without a passwd entry, falling back to the gid
+// of the agent could run the command as root's group
+var errNoPrimaryGroup = errors.New("no primary group")
+
+func lookupUser(name string) (uid uint32, gid uint32, groups []uint32, err error) {
+	var u *user.User
+	if id, convErr := strconv.ParseUint(name, 10, 32); convErr == nil {
+		u, err = user.LookupId(name)
+		if errors.As(err, new(user.UnknownUserIdError)) {
+			// numeric IDs need not have a passwd
+			// entry and get no supplementary groups
+			return uint32(id), 0, []uint32{}, fmt.Errorf("user %s has no passwd entry, set group:%w", name, errNoPrimaryGroup)
+		}
+	} else {
+		u, err = user.Lookup(name)
+	}
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("lookup user %s:%w", name, err)
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("lookup groups of user %s:%w", name, err)
+	}
+	groups = make([]uint32, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		groups = append(groups, parseID(id))
+	}
+	return parseID(u.Uid), parseID(u.Gid), groups, nil
+}
+
+func lookupGroup(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("lookup group %s:%w", name, err)
+	}
+	return parseID(g.Gid), nil
+}
+
+// parseID parses IDs returned by os/user
+// which are always numeric on unix
+func parseID(id string) uint32 {
+	n, _ := strconv.ParseUint(id, 10, 32)
+	return uint32(n)
+}
@@ -0,0 +1,65 @@
+package cmdexec
+
+import (
+	"errors"
+	"os/user"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_lookupCredential(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	groupIDs, err := current.GroupIds()
+	if err != nil {
+		t.Skip(err)
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		groups = append(groups, parseID(id))
+	}
+	uid, gid := uint32(syscall.Getuid()), uint32(syscall.Getgid())
+
+	tests := map[string]struct {
+		user     string
+		group    string
+		expected *syscall.Credential
+	}{
+		"none": {},
+		"user name": {
+			user:     current.Username,
+			expected: &syscall.Credential{Uid: parseID(current.Uid), Gid: parseID(current.Gid), Groups: groups},
+		},
+		"unknown numeric user": {
+			user:     "4000000",
+			group:    "4000001",
+			expected: &syscall.Credential{Uid: 4000000, Gid: 4000001, Groups: []uint32{}},
+		},
+		"group only keeps the agent groups": {
+			group:    strconv.Itoa(int(gid)),
+			expected: &syscall.Credential{Uid: uid, Gid: gid, NoSetGroups: true},
+		},
+	}
+	t.Run("unknown numeric user without group", func(t *testing.T) {
+		if _, err := lookupCredential("4000000", ""); !errors.Is(err, errNoPrimaryGroup) {
+			t.Errorf("expected %v got %v", errNoPrimaryGroup, err)
+		}
+	})
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cred, err := lookupCredential(tc.user, tc.group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, cred); diff != "" {
+				t.Errorf("(--Want ++Got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package cmdexec
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type execer interface {
+	ExecContext(ctx context.Context) error
+}
+
+// Group runs one command on behalf of several templates,
+// execs requested within Debounce of each other are
+// coalesced into a single run which starts once no member
+// is rendering, runs never overlap and every member
+// waiting on a run receives its result. A run gets the
+// Change of the member which joined it last with a change
+type Group struct {
+	Execer   execer
+	Debounce time.Duration
+	// Context is passed to the runs of Execer,
+	// defaults to context.Background()
+	Context context.Context
+
+	mu        sync.Mutex
+	rendering int
+	pending   *batch
+	runMu     sync.Mutex
+}
+
+type batch struct {
+	timer   *time.Timer
+	expired bool
+	done    chan struct{}
+	err     error
+	change  *Change
+}
+
+// GroupMember is the execer of a single
+// template taking part in a Group
+type GroupMember struct {
+	group  *Group
+	joined *batch
+}
+
+func (g *Group) Member() *GroupMember {
+	return &GroupMember{group: g}
+}
+
+// Track runs render, a pending run of the group does
+// not start until render returns, a successful render
+// joins the pending run with its change, if any,
+// before the run can start
+func (m *GroupMember) Track(render func() (*Change, error)) error {
+	g := m.group
+	g.mu.Lock()
+	g.rendering++
+	g.mu.Unlock()
+
+	change, err := render()
+
+	g.mu.Lock()
+	if err == nil {
+		m.joined = g.join()
+		if change != nil {
+			m.joined.change = change
+		}
+	}
+	g.rendering--
+	b := g.pending
+	ready := g.rendering == 0 && b != nil && b.expired
+	g.mu.Unlock()
+
+	if ready {
+		go g.fire(b)
+	}
+	return err
+}
+
+// ExecContext waits for the run joined by the
+// last render and returns its result, without
+// a render it joins the pending run with the
+// Change of ctx
+func (m *GroupMember) ExecContext(ctx context.Context) error {
+	g := m.group
+	b := m.joined
+	m.joined = nil
+	if b == nil {
+		g.mu.Lock()
+		b = g.join()
+		if change, ok := ctx.Value(changeKey{}).(Change); ok {
+			b.change = &change
+		}
+		g.mu.Unlock()
+	}
+
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending reports if the last run of the group
+// failed and is run again on a later exec
+func (m *GroupMember) Pending() bool {
+	pe, ok := m.group.Execer.(interface{ Pending() bool })
+	return ok && pe.Pending()
+}
+
+// join must be called with mu held, every join
+// pushes the start of the pending run by Debounce
+func (g *Group) join() *batch {
+	b := g.pending
+	if b == nil {
+		b = &batch{done: make(chan struct{})}
+		g.pending = b
+		b.timer = time.AfterFunc(g.Debounce, func() {
+			g.fire(b)
+		})
+		return b
+	}
+	b.expired = false
+	b.timer.Reset(g.Debounce)
+	return b
+}
+
+func (g *Group) fire(b *batch) {
+	g.mu.Lock()
+	if g.pending != b {
+		// the batch has already run
+		g.mu.Unlock()
+		return
+	}
+	b.expired = true
+	if g.rendering > 0 {
+		// the last render to finish
+		// fires the batch again
+		g.mu.Unlock()
+		return
+	}
+	g.pending = nil
+	change := b.change
+	g.mu.Unlock()
+
+	g.runMu.Lock()
+	defer g.runMu.Unlock()
+	ctx := g.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if change != nil {
+		ctx = WithChange(ctx, *change)
+	}
+	b.err = g.Execer.ExecContext(ctx)
+	close(b.done)
+}
@@ -0,0 +1,167 @@
+package cmdexec
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingExecer struct {
+	runs    atomic.Int32
+	running atomic.Int32
+	overlap atomic.Bool
+	delay   time.Duration
+	err     error
+}
+
+func (c *countingExecer) ExecContext(_ context.Context) error {
+	if c.running.Add(1) > 1 {
+		c.overlap.Store(true)
+	}
+	defer c.running.Add(-1)
+	c.runs.Add(1)
+	time.Sleep(c.delay)
+	return c.err
+}
+
+func renderAndExec(m *GroupMember, render func() error) error {
+	if err := m.Track(func() (*Change, error) { return nil, render() }); err != nil {
+		return err
+	}
+	return m.ExecContext(context.Background())
+}
+
+func TestGroup(t *testing.T) {
+	t.Run("execs are coalesced and results reported to all members", func(t *testing.T) {
+		execErr := errors.New("reload failed")
+		ex := &countingExecer{err: execErr}
+		g := &Group{Execer: ex, Debounce: 50 * time.Millisecond}
+
+		var wg sync.WaitGroup
+		errs := make([]error, 8)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = renderAndExec(g.Member(), func() error { return nil })
+			}()
+		}
+		wg.Wait()
+
+		if runs := ex.runs.Load(); runs != 1 {
+			t.Errorf("expected 1 run got %d", runs)
+		}
+		for i, err := range errs {
+			if !errors.Is(err, execErr) {
+				t.Errorf("member %d expected exec error got %v", i, err)
+			}
+		}
+	})
+
+	t.Run("run waits for renders in progress", func(t *testing.T) {
+		ex := &countingExecer{}
+		g := &Group{Execer: ex, Debounce: 10 * time.Millisecond}
+
+		slowStarted := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = renderAndExec(g.Member(), func() error {
+				close(slowStarted)
+				time.Sleep(100 * time.Millisecond)
+				return nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			<-slowStarted
+			_ = renderAndExec(g.Member(), func() error { return nil })
+		}()
+		wg.Wait()
+
+		if runs := ex.runs.Load(); runs != 1 {
+			t.Errorf("expected 1 run got %d", runs)
+		}
+	})
+
+	t.Run("failed renders do not exec", func(t *testing.T) {
+		ex := &countingExecer{}
+		g := &Group{Execer: ex, Debounce: 10 * time.Millisecond}
+
+		err := renderAndExec(g.Member(), func() error { return errors.New("render failed") })
+		if err == nil {
+			t.Error("expected render error")
+		}
+		time.Sleep(30 * time.Millisecond)
+		if runs := ex.runs.Load(); runs != 0 {
+			t.Errorf("expected 0 runs got %d", runs)
+		}
+	})
+
+	t.Run("runs never overlap", func(t *testing.T) {
+		ex := &countingExecer{delay: 50 * time.Millisecond}
+		g := &Group{Execer: ex, Debounce: time.Millisecond}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				time.Sleep(time.Duration(i) * 20 * time.Millisecond)
+				_ = renderAndExec(g.Member(), func() error { return nil })
+			}()
+		}
+		wg.Wait()
+
+		if ex.overlap.Load() {
+			t.Error("group runs overlapped")
+		}
+	})
+
+	t.Run("run gets the change of the member", func(t *testing.T) {
+		var got Change
+		g := &Group{Debounce: 10 * time.Millisecond, Execer: execerFunc(func(ctx context.Context) error {
+			got = changeFrom(ctx)
+			return nil
+		})}
+		m := g.Member()
+		change := Change{Template: "app", Destination: "/etc/app.conf", NewHash: "abc"}
+		if err := m.Track(func() (*Change, error) { return &change, nil }); err != nil {
+			t.Fatal(err)
+		}
+		// the run starts before the
+		// member waits for its result
+		time.Sleep(50 * time.Millisecond)
+		if err := m.ExecContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got != change {
+			t.Errorf("expected change %+v got %+v", change, got)
+		}
+	})
+
+	t.Run("exec without render gets the change of ctx", func(t *testing.T) {
+		var got Change
+		g := &Group{Debounce: 10 * time.Millisecond, Execer: execerFunc(func(ctx context.Context) error {
+			got = changeFrom(ctx)
+			return nil
+		})}
+		change := Change{Template: "app", NewHash: "abc"}
+		if err := g.Member().ExecContext(WithChange(context.Background(), change)); err != nil {
+			t.Fatal(err)
+		}
+		if got != change {
+			t.Errorf("expected change %+v got %+v", change, got)
+		}
+	})
+}
+
+type execerFunc func(ctx context.Context) error
+
+func (f execerFunc) ExecContext(ctx context.Context) error {
+	return f(ctx)
+}
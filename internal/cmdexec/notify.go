@@ -0,0 +1,96 @@
+package cmdexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Notify signals a running process instead of running
+// a command, the process is looked up by its pidfile
+// or by its name on every notification
+type Notify struct {
+	PIDFile     string
+	ProcessName string
+	Signal      syscall.Signal
+}
+
+type NotifyErr struct {
+	Target string
+	Err    error
+}
+
+func (e *NotifyErr) Error() string {
+	return fmt.Sprintf("notify %s failed:%s", e.Target, e.Err.Error())
+}
+
+func (e *NotifyErr) Unwrap() error {
+	return e.Err
+}
+
+func (n *Notify) ExecContext(_ context.Context) error {
+	pids, err := n.resolvePIDs()
+	if err != nil {
+		return &NotifyErr{Target: n.target(), Err: err}
+	}
+
+	for _, pid := range pids {
+		if err := signalPID(pid, n.Signal); err != nil {
+			return &NotifyErr{Target: n.target(), Err: err}
+		}
+	}
+	return nil
+}
+
+func (n *Notify) target() string {
+	if n.PIDFile != "" {
+		return n.PIDFile
+	}
+	return n.ProcessName
+}
+
+func (n *Notify) resolvePIDs() ([]int, error) {
+	if n.PIDFile == "" {
+		pids, err := findByName(n.ProcessName)
+		if err != nil {
+			return nil, err
+		}
+		if len(pids) < 1 {
+			return nil, fmt.Errorf("no process named %s", n.ProcessName)
+		}
+		return pids, nil
+	}
+
+	contents, err := os.ReadFile(n.PIDFile)
+	if err != nil {
+		return nil, fmt.Errorf("read pidfile:%w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil || pid < 1 {
+		return nil, fmt.Errorf("invalid pid in pidfile:%q", strings.TrimSpace(string(contents)))
+	}
+	return []int{pid}, nil
+}
+
+func signalPID(pid int, sig syscall.Signal) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	// signal 0 only checks if the
+	// process is alive
+	if err := proc.Signal(syscall.Signal(0)); err != nil {
+		if errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("process %d is not running", pid)
+		}
+		return fmt.Errorf("process %d:%w", pid, err)
+	}
+	if err := proc.Signal(sig); err != nil {
+		return fmt.Errorf("signal %s to process %d:%w", sig, pid, err)
+	}
+	return nil
+}
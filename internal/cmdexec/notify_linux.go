@@ -0,0 +1,52 @@
+package cmdexec
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findByName matches name against the command name of
+// every process in /proc, the kernel cuts the command
+// name to 15 bytes so the base name of argv[0] is
+// matched too for longer names
+func findByName(name string) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	self := os.Getpid()
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		comm, err := os.ReadFile("/proc/" + entry.Name() + "/comm")
+		if err != nil {
+			// the process exited
+			continue
+		}
+		if strings.TrimSpace(string(comm)) == name || argv0(entry.Name()) == name {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// argv0 returns the base name of the first argument
+// of pid, kernel threads do not have any arguments
+func argv0(pid string) string {
+	cmdline, err := os.ReadFile("/proc/" + pid + "/cmdline")
+	if err != nil {
+		return ""
+	}
+	arg, _, _ := bytes.Cut(cmdline, []byte{0})
+	if len(arg) == 0 {
+		return ""
+	}
+	return filepath.Base(string(arg))
+}
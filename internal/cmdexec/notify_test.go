@@ -0,0 +1,126 @@
+package cmdexec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startSleeper(t *testing.T, path string) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command(path, "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	return cmd
+}
+
+func waitSignaled(t *testing.T, cmd *exec.Cmd, sig syscall.Signal) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("process was not signalled")
+	}
+	status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !status.Signaled() || status.Signal() != sig {
+		t.Errorf("expected process to be stopped by %s got %s", sig, cmd.ProcessState)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not found")
+	}
+
+	t.Run("signal by pidfile", func(t *testing.T) {
+		cmd := startSleeper(t, sleepPath)
+		pidFile := filepath.Join(t.TempDir(), "app.pid")
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		n := Notify{PIDFile: pidFile, Signal: syscall.SIGTERM}
+		if err := n.ExecContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		waitSignaled(t, cmd, syscall.SIGTERM)
+	})
+
+	signalByName := func(t *testing.T, name string) {
+		bin := filepath.Join(t.TempDir(), name)
+		bs, err := os.ReadFile(sleepPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(bin, bs, 0755); err != nil {
+			t.Fatal(err)
+		}
+		cmd := startSleeper(t, bin)
+
+		n := Notify{ProcessName: name, Signal: syscall.SIGTERM}
+		if err := n.ExecContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		waitSignaled(t, cmd, syscall.SIGTERM)
+	}
+
+	t.Run("signal by process name", func(t *testing.T) {
+		signalByName(t, "tpla-notify-tst")
+	})
+
+	t.Run("signal by process name longer than comm", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("pgrep matches the truncated command name")
+		}
+		signalByName(t, "tplagent-notify-long-name")
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		tmp := t.TempDir()
+		cmd := exec.Command(sleepPath, "0")
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+		deadPIDFile := filepath.Join(tmp, "dead.pid")
+		if err := os.WriteFile(deadPIDFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		invalidPIDFile := filepath.Join(tmp, "invalid.pid")
+		if err := os.WriteFile(invalidPIDFile, []byte("abc"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cases := map[string]Notify{
+			"missing pidfile": {PIDFile: filepath.Join(tmp, "missing.pid"), Signal: syscall.SIGHUP},
+			"invalid pid":     {PIDFile: invalidPIDFile, Signal: syscall.SIGHUP},
+			"dead process":    {PIDFile: deadPIDFile, Signal: syscall.SIGHUP},
+			"unknown name":    {ProcessName: "no-such-proc-x", Signal: syscall.SIGHUP},
+		}
+		for name, n := range cases {
+			t.Run(name, func(t *testing.T) {
+				err := n.ExecContext(context.Background())
+				notifyErr := &NotifyErr{}
+				if !errors.As(err, &notifyErr) {
+					t.Errorf("expected NotifyErr got %v", err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package cmdexec
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// limitedBuffer keeps the first limit bytes
+// written to it and counts the rest
+type limitedBuffer struct {
+	buff    bytes.Buffer
+	limit   int
+	dropped int
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	room := l.limit - l.buff.Len()
+	if room < len(p) {
+		l.dropped += len(p) - max(room, 0)
+		if room > 0 {
+			l.buff.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return l.buff.Write(p)
+}
+
+func (l *limitedBuffer) Len() int {
+	return l.buff.Len()
+}
+
+func (l *limitedBuffer) Bytes() []byte {
+	if l.dropped == 0 {
+		return l.buff.Bytes()
+	}
+	return fmt.Appendf(bytes.Clone(l.buff.Bytes()), "...(%d bytes truncated)", l.dropped)
+}
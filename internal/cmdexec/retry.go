@@ -0,0 +1,101 @@
+package cmdexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultRetryBackoff = time.Second
+const maxRetryBackoff = 30 * time.Second
+
+// Retry runs Execer again up to Retries times when it
+// fails, the wait between attempts starts at Backoff and
+// doubles after every attempt. PendingFile, if set, exists
+// from the start of an exec until it succeeds, so a failed
+// exec can be run again on later ticks and after restarts.
+// Cancelling ctx stops the backoff, an attempt which is
+// already running is left to finish
+type Retry struct {
+	Execer      execer
+	Retries     int
+	Backoff     time.Duration
+	PendingFile string
+}
+
+func (r *Retry) ExecContext(ctx context.Context) error {
+	if err := r.markPending(); err != nil {
+		return err
+	}
+
+	backoff := r.firstBackoff()
+	for attempt := 0; ; attempt++ {
+		err := r.Execer.ExecContext(context.WithoutCancel(ctx))
+		if err == nil {
+			return r.clearPending()
+		}
+		if attempt >= r.Retries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+// TotalBackoff returns the time spent waiting
+// between attempts when every attempt fails
+func (r *Retry) TotalBackoff() time.Duration {
+	var total time.Duration
+	backoff := r.firstBackoff()
+	for i := 0; i < r.Retries; i++ {
+		total += backoff
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+	return total
+}
+
+func (r *Retry) firstBackoff() time.Duration {
+	if r.Backoff <= 0 {
+		return defaultRetryBackoff
+	}
+	return r.Backoff
+}
+
+// Pending reports if the last exec did not succeed
+func (r *Retry) Pending() bool {
+	if r.PendingFile == "" {
+		return false
+	}
+	_, err := os.Stat(r.PendingFile)
+	return err == nil
+}
+
+func (r *Retry) markPending() error {
+	if r.PendingFile == "" {
+		return nil
+	}
+	if err := os.WriteFile(r.PendingFile, nil, 0644); err != nil {
+		return fmt.Errorf("error marking exec pending:%w", err)
+	}
+	return nil
+}
+
+func (r *Retry) clearPending() error {
+	if r.PendingFile == "" {
+		return nil
+	}
+	err := os.Remove(r.PendingFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error clearing exec pending:%w", err)
+	}
+	return nil
+}
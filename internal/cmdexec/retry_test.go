@@ -0,0 +1,85 @@
+package cmdexec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type flakyExecer struct {
+	failures int
+	calls    int
+}
+
+func (f *flakyExecer) ExecContext(_ context.Context) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return &ExecErr{Status: 1}
+	}
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("retries until the exec succeeds", func(t *testing.T) {
+		pending := filepath.Join(t.TempDir(), "out.exec-pending")
+		ex := &flakyExecer{failures: 2}
+		r := Retry{Execer: ex, Retries: 3, Backoff: time.Millisecond, PendingFile: pending}
+
+		if err := r.ExecContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if ex.calls != 3 {
+			t.Errorf("expected 3 calls got %d", ex.calls)
+		}
+		if r.Pending() {
+			t.Error("expected exec not to be pending")
+		}
+	})
+
+	t.Run("exec stays pending after the last retry", func(t *testing.T) {
+		pending := filepath.Join(t.TempDir(), "out.exec-pending")
+		ex := &flakyExecer{failures: 5}
+		r := Retry{Execer: ex, Retries: 1, Backoff: time.Millisecond, PendingFile: pending}
+
+		err := r.ExecContext(context.Background())
+		execErr := &ExecErr{}
+		if !errors.As(err, &execErr) {
+			t.Fatalf("expected ExecErr got %v", err)
+		}
+		if ex.calls != 2 {
+			t.Errorf("expected 2 calls got %d", ex.calls)
+		}
+		if !r.Pending() {
+			t.Error("expected exec to be pending")
+		}
+		if _, err := os.Stat(pending); err != nil {
+			t.Errorf("expected pending file:%v", err)
+		}
+
+		// a later tick runs the exec again
+		ex.failures = 0
+		if err := r.ExecContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if r.Pending() {
+			t.Error("expected pending flag to be cleared")
+		}
+	})
+
+	t.Run("cancelled context stops retries", func(t *testing.T) {
+		ex := &flakyExecer{failures: 5}
+		r := Retry{Execer: ex, Retries: 5, Backoff: time.Hour}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := r.ExecContext(ctx); err == nil {
+			t.Error("expected error")
+		}
+		if ex.calls != 1 {
+			t.Errorf("expected 1 call got %d", ex.calls)
+		}
+	})
+}
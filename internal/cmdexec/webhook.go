@@ -0,0 +1,158 @@
+package cmdexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const unixScheme = "unix://"
+
+// WebhookData is passed to the body
+// template of a Webhook
+type WebhookData struct {
+	Name        string
+	Destination string
+	// Hash is the hex encoded sha256 of the render,
+	// of the whole output in multi output mode
+	Hash string
+}
+
+// Webhook sends an HTTP request after a render, URL can
+// be a unix socket in the form unix:///path/to.sock:/req/path
+type Webhook struct {
+	Name        string
+	Destination string
+	Method      string
+	URL         string
+	Headers     map[string]string
+	Body        *template.Template
+	Timeout     time.Duration
+	// ExpectedStatus defaults to any 2xx status
+	ExpectedStatus []int
+}
+
+type WebhookErr struct {
+	URL    string
+	Status int
+	Err    error
+}
+
+func (e *WebhookErr) Error() string {
+	if e.Status > 0 {
+		return fmt.Sprintf("webhook %s failed with status %d", e.URL, e.Status)
+	}
+	return fmt.Sprintf("webhook %s failed:%s", e.URL, e.Err.Error())
+}
+
+func (e *WebhookErr) Unwrap() error {
+	return e.Err
+}
+
+func (w *Webhook) ExecContext(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	status, err := w.send(ctx)
+	if err != nil {
+		return &WebhookErr{URL: w.URL, Err: err}
+	}
+	if !w.expected(status) {
+		return &WebhookErr{URL: w.URL, Status: status}
+	}
+	return nil
+}
+
+func (w *Webhook) send(ctx context.Context) (int, error) {
+	body, err := w.renderBody(changeFrom(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	client := http.DefaultClient
+	reqURL := w.URL
+	if socket, reqPath, ok := parseUnixURL(w.URL); ok {
+		client = unixClient(socket)
+		reqURL = "http://unix" + reqPath
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.Method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain the body so that the
+	// connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// renderBody uses the hash of the change instead
+// of reading the destination, which a later render
+// could have replaced
+func (w *Webhook) renderBody(change Change) ([]byte, error) {
+	if w.Body == nil {
+		return nil, nil
+	}
+
+	var buff bytes.Buffer
+	data := WebhookData{
+		Name:        w.Name,
+		Destination: w.Destination,
+		Hash:        change.NewHash,
+	}
+	if err := w.Body.Execute(&buff, data); err != nil {
+		return nil, fmt.Errorf("webhook body error:%w", err)
+	}
+	return buff.Bytes(), nil
+}
+
+func (w *Webhook) expected(status int) bool {
+	if len(w.ExpectedStatus) < 1 {
+		return status >= 200 && status < 300
+	}
+	return slices.Contains(w.ExpectedStatus, status)
+}
+
+// parseUnixURL splits unix:///path/to.sock:/req/path
+// into the socket path and the request path
+func parseUnixURL(rawURL string) (socket string, reqPath string, ok bool) {
+	rest, ok := strings.CutPrefix(rawURL, unixScheme)
+	if !ok {
+		return "", "", false
+	}
+	socket, reqPath, found := strings.Cut(rest, ":")
+	if !found || reqPath == "" {
+		reqPath = "/"
+	}
+	return socket, reqPath, true
+}
+
+func unixClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			// a client is created for every
+			// request, nothing to reuse
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
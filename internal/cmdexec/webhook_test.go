@@ -0,0 +1,129 @@
+package cmdexec
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+)
+
+type recordedReq struct {
+	method string
+	path   string
+	header string
+	body   string
+}
+
+func recordingHandler(status int, reqs chan<- recordedReq) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs <- recordedReq{
+			method: r.Method,
+			path:   r.URL.Path,
+			header: r.Header.Get("X-Token"),
+			body:   string(body),
+		}
+		w.WriteHeader(status)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "envoy.yaml")
+	hash := "ffffbbbb"
+
+	body := template.Must(template.New("body").Parse(`{"name":"{{.Name}}","dest":"{{.Destination}}","hash":"{{.Hash}}"}`))
+
+	newWebhook := func(url string) *Webhook {
+		return &Webhook{
+			Name:        "envoy",
+			Destination: dest,
+			Method:      http.MethodPost,
+			URL:         url,
+			Headers:     map[string]string{"X-Token": "secret"},
+			Body:        body,
+			Timeout:     time.Second,
+		}
+	}
+
+	t.Run("request is sent", func(t *testing.T) {
+		reqs := make(chan recordedReq, 1)
+		srv := httptest.NewServer(recordingHandler(http.StatusOK, reqs))
+		defer srv.Close()
+
+		ctx := WithChange(context.Background(), Change{NewHash: hash})
+		if err := newWebhook(srv.URL + "/reload").ExecContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		got := <-reqs
+		expected := recordedReq{
+			method: http.MethodPost,
+			path:   "/reload",
+			header: "secret",
+			body:   `{"name":"envoy","dest":"` + dest + `","hash":"` + hash + `"}`,
+		}
+		if got != expected {
+			t.Errorf("expected %+v got %+v", expected, got)
+		}
+	})
+
+	t.Run("unexpected status fails", func(t *testing.T) {
+		reqs := make(chan recordedReq, 1)
+		srv := httptest.NewServer(recordingHandler(http.StatusServiceUnavailable, reqs))
+		defer srv.Close()
+
+		err := newWebhook(srv.URL).ExecContext(context.Background())
+		webhookErr := &WebhookErr{}
+		if !errors.As(err, &webhookErr) || webhookErr.Status != http.StatusServiceUnavailable {
+			t.Errorf("expected WebhookErr with status 503 got %v", err)
+		}
+	})
+
+	t.Run("expected status list", func(t *testing.T) {
+		reqs := make(chan recordedReq, 1)
+		srv := httptest.NewServer(recordingHandler(http.StatusAccepted, reqs))
+		defer srv.Close()
+
+		w := newWebhook(srv.URL)
+		w.ExpectedStatus = []int{http.StatusOK}
+		if err := w.ExecContext(context.Background()); err == nil {
+			t.Error("expected 202 to be rejected")
+		}
+	})
+
+	t.Run("unix socket", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "admin.sock")
+		lis, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqs := make(chan recordedReq, 1)
+		srv := &http.Server{Handler: recordingHandler(http.StatusOK, reqs)}
+		go func() {
+			_ = srv.Serve(lis)
+		}()
+		defer srv.Close()
+
+		if err := newWebhook("unix://" + socket + ":/runtime_modify").ExecContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-reqs; got.path != "/runtime_modify" {
+			t.Errorf("expected path /runtime_modify got %s", got.path)
+		}
+	})
+
+	t.Run("connection failure", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "missing.sock")
+		err := newWebhook("unix://" + socket).ExecContext(context.Background())
+		webhookErr := &WebhookErr{}
+		if !errors.As(err, &webhookErr) {
+			t.Errorf("expected WebhookErr got %v", err)
+		}
+	})
+}
@@ -1,16 +1,21 @@
 package config
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/shubhang93/tplagent/internal/cmdexec"
 	"github.com/shubhang93/tplagent/internal/duration"
+	"github.com/shubhang93/tplagent/internal/escape"
 	"github.com/shubhang93/tplagent/internal/fatal"
-	"gopkg.in/yaml.v3"
+	"github.com/shubhang93/tplagent/internal/outformat"
+	"github.com/shubhang93/tplagent/internal/signame"
 	"io"
 	"log/slog"
 	"os"
-	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 	"unicode"
 )
@@ -20,11 +25,118 @@ var allowedLogFmts = map[string]struct{}{
 	"text": {},
 }
 
+const (
+	ModeReplace      = "replace"
+	ModeManagedBlock = "managed_block"
+)
+
+var allowedModes = map[string]struct{}{
+	"":               {},
+	ModeReplace:      {},
+	ModeManagedBlock: {},
+}
+
 type Agent struct {
 	LogLevel               slog.Level `json:"log_level" yaml:"log_level"`
 	LogFmt                 string     `json:"log_fmt" yaml:"log_fmt"`
 	MaxConsecutiveFailures int        `json:"max_consecutive_failures" yaml:"max_consecutive_failures"`
 	HTTPListenerAddr       string     `json:"http_listener_addr" yaml:"http_listener_addr"`
+	Includes               []string   `json:"includes,omitempty" yaml:"includes,omitempty"`
+	// Supervise configures the child
+	// started by tplagent exec
+	Supervise *SuperviseSpec `json:"supervise,omitempty" yaml:"supervise,omitempty"`
+	// ExecGroups are commands shared by
+	// templates joining them with exec_group
+	ExecGroups map[string]*ExecGroupSpec `json:"exec_groups,omitempty" yaml:"exec_groups,omitempty"`
+	// Listener secures the http listener
+	Listener *ListenerSpec `json:"listener,omitempty" yaml:"listener,omitempty"`
+	// Hooks are signed webhooks served at
+	// POST /hooks/{id} refreshing templates
+	Hooks map[string]*HookSpec `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	// IncludeDirs contain config fragments whose
+	// templates are merged into this config
+	IncludeDirs []string `json:"include_dirs,omitempty" yaml:"include_dirs,omitempty"`
+	// StateDir keeps the state surviving agent
+	// restarts, like execs left pending
+	StateDir string `json:"state_dir,omitempty" yaml:"state_dir,omitempty"`
+}
+
+type HookSpec struct {
+	// SecretFile contains the shared HMAC secret
+	SecretFile      string `json:"secret_file" yaml:"secret_file"`
+	SignatureHeader string `json:"signature_header,omitempty" yaml:"signature_header,omitempty"`
+	// TimestampHeader enables replay protection, the
+	// timestamp is signed along with the body and
+	// must be within MaxSkew of the agent clock
+	TimestampHeader string            `json:"timestamp_header,omitempty" yaml:"timestamp_header,omitempty"`
+	MaxSkew         duration.Duration `json:"max_skew,omitempty" yaml:"max_skew,omitempty"`
+	// Templates and templates matching
+	// every label of Selector are refreshed
+	Templates []string          `json:"templates,omitempty" yaml:"templates,omitempty"`
+	Selector  map[string]string `json:"selector,omitempty" yaml:"selector,omitempty"`
+}
+
+const (
+	ScopeRead    = "read"
+	ScopeTrigger = "trigger"
+	ScopeAdmin   = "admin"
+)
+
+var allowedScopes = map[string]struct{}{
+	ScopeRead:    {},
+	ScopeTrigger: {},
+	ScopeAdmin:   {},
+}
+
+type TokenSpec struct {
+	// File contains the bearer token
+	File  string `json:"file" yaml:"file"`
+	Scope string `json:"scope" yaml:"scope"`
+}
+
+type ListenerSpec struct {
+	TLSCertFile string `json:"tls_cert_file,omitempty" yaml:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty" yaml:"tls_key_file,omitempty"`
+	// ClientCAFile verifies client certificates,
+	// ClientScopes maps their common names to scopes
+	ClientCAFile string            `json:"client_ca_file,omitempty" yaml:"client_ca_file,omitempty"`
+	ClientScopes map[string]string `json:"client_scopes,omitempty" yaml:"client_scopes,omitempty"`
+	Tokens       []TokenSpec       `json:"tokens,omitempty" yaml:"tokens,omitempty"`
+	// SocketMode and SocketGroup set the permissions
+	// of a unix socket listener, SocketMode is octal
+	SocketMode  string `json:"socket_mode,omitempty" yaml:"socket_mode,omitempty"`
+	SocketGroup string `json:"socket_group,omitempty" yaml:"socket_group,omitempty"`
+	// AllowedUIDs and AllowedGIDs restrict callers
+	// of a unix socket listener by their peer credentials
+	AllowedUIDs []int `json:"allowed_uids,omitempty" yaml:"allowed_uids,omitempty"`
+	AllowedGIDs []int `json:"allowed_gids,omitempty" yaml:"allowed_gids,omitempty"`
+}
+
+// UnixSocketScheme prefixes http listener
+// addresses of unix sockets
+const UnixSocketScheme = "unix://"
+
+// SocketPath returns the path of a unix socket
+// listener address, ok is false for tcp addresses
+func SocketPath(addr string) (path string, ok bool) {
+	return strings.CutPrefix(addr, UnixSocketScheme)
+}
+
+type ExecGroupSpec struct {
+	ExecSpec `yaml:",inline"`
+	// Debounce is the window in which
+	// execs are coalesced into one run
+	Debounce duration.Duration `json:"debounce,omitempty" yaml:"debounce,omitempty"`
+}
+
+const OnChangeRestart = "restart"
+
+type SuperviseSpec struct {
+	EnvFile     string            `json:"env_file,omitempty" yaml:"env_file,omitempty"`
+	Templates   []string          `json:"templates,omitempty" yaml:"templates,omitempty"`
+	OnChange    string            `json:"on_change,omitempty" yaml:"on_change,omitempty"`
+	KillSignal  string            `json:"kill_signal,omitempty" yaml:"kill_signal,omitempty"`
+	KillTimeout duration.Duration `json:"kill_timeout,omitempty" yaml:"kill_timeout,omitempty"`
 }
 
 type Actions struct {
@@ -37,6 +149,47 @@ type ExecSpec struct {
 	CmdArgs    []string          `json:"cmd_args" yaml:"cmd_args"`
 	CmdTimeout duration.Duration `json:"cmd_timeout" yaml:"cmd_timeout"`
 	Env        map[string]string `json:"env" yaml:"env"`
+	Shell      bool              `json:"shell,omitempty" yaml:"shell,omitempty"`
+	Dir        string            `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// InheritEnv defaults to true
+	InheritEnv  *bool  `json:"inherit_env,omitempty" yaml:"inherit_env,omitempty"`
+	OutputLimit int    `json:"output_limit,omitempty" yaml:"output_limit,omitempty"`
+	User        string `json:"user,omitempty" yaml:"user,omitempty"`
+	Group       string `json:"group,omitempty" yaml:"group,omitempty"`
+	// Retries is the number of times a failed
+	// command is run again, waiting RetryBackoff
+	// before the first retry and doubling it after
+	Retries      int               `json:"retries,omitempty" yaml:"retries,omitempty"`
+	RetryBackoff duration.Duration `json:"retry_backoff,omitempty" yaml:"retry_backoff,omitempty"`
+	// ExpandTemplates executes cmd_args and env
+	// values as templates against the change
+	ExpandTemplates bool `json:"expand_templates,omitempty" yaml:"expand_templates,omitempty"`
+}
+
+// NotifySpec signals a running process
+// as an alternative to an ExecSpec
+type NotifySpec struct {
+	PIDFile     string `json:"pid_file,omitempty" yaml:"pid_file,omitempty"`
+	ProcessName string `json:"process_name,omitempty" yaml:"process_name,omitempty"`
+	Signal      string `json:"signal" yaml:"signal"`
+}
+
+// WebhookSpec sends an HTTP request after
+// a render as an alternative to an ExecSpec
+type WebhookSpec struct {
+	Method         string            `json:"method,omitempty" yaml:"method,omitempty"`
+	URL            string            `json:"url" yaml:"url"`
+	Headers        map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body           string            `json:"body,omitempty" yaml:"body,omitempty"`
+	Timeout        duration.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	ExpectedStatus []int             `json:"expected_status,omitempty" yaml:"expected_status,omitempty"`
+}
+
+type GuardSpec struct {
+	MinSize          int      `json:"min_size,omitempty" yaml:"min_size,omitempty"`
+	MaxShrinkPercent float64  `json:"max_shrink_percent,omitempty" yaml:"max_shrink_percent,omitempty"`
+	Contains         []string `json:"contains,omitempty" yaml:"contains,omitempty"`
+	Matches          []string `json:"matches,omitempty" yaml:"matches,omitempty"`
 }
 
 type TemplateSpec struct {
@@ -53,61 +206,104 @@ type TemplateSpec struct {
 	RefreshOnTrigger   bool              `json:"refresh_on_trigger" yaml:"refresh_on_trigger"`
 	RenderOnce         bool              `json:"render_once,omitempty" yaml:"render_once,omitempty"`
 	MissingKey         string            `json:"missing_key" yaml:"missing_key"`
+	DependsOn          []string          `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	MultiOutput        bool              `json:"multi_output,omitempty" yaml:"multi_output,omitempty"`
+	SourceDir          string            `json:"source_dir,omitempty" yaml:"source_dir,omitempty"`
+	DestinationDir     string            `json:"destination_dir,omitempty" yaml:"destination_dir,omitempty"`
+	Includes           []string          `json:"includes,omitempty" yaml:"includes,omitempty"`
+	Mode               string            `json:"mode,omitempty" yaml:"mode,omitempty"`
+	BlockMarkers       []string          `json:"block_markers,omitempty" yaml:"block_markers,omitempty"`
+	OutputFormat       string            `json:"output_format,omitempty" yaml:"output_format,omitempty"`
+	Canonicalize       string            `json:"canonicalize,omitempty" yaml:"canonicalize,omitempty"`
+	Guard              *GuardSpec        `json:"guard,omitempty" yaml:"guard,omitempty"`
+	Escape             string            `json:"escape,omitempty" yaml:"escape,omitempty"`
 
-	Exec *ExecSpec `json:"exec" yaml:"exec"`
+	Exec    *ExecSpec    `json:"exec" yaml:"exec"`
+	Notify  *NotifySpec  `json:"notify,omitempty" yaml:"notify,omitempty"`
+	Webhook *WebhookSpec `json:"webhook,omitempty" yaml:"webhook,omitempty"`
+	// ExecGroup joins an exec group
+	// defined in the agent block
+	ExecGroup string `json:"exec_group,omitempty" yaml:"exec_group,omitempty"`
+	// Labels are matched by hook selectors
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Required templates must render
+	// before the agent reports ready
+	Required bool `json:"required,omitempty" yaml:"required,omitempty"`
 }
 
 type TPLAgent struct {
 	Agent         Agent                    `json:"agent" yaml:"agent"`
-	TemplateSpecs map[string]*TemplateSpec `json:"templates" yaml:"template_specs"`
+	TemplateSpecs map[string]*TemplateSpec `json:"templates" yaml:"templates"`
 }
 
 func ReadFromFile(path string) (TPLAgent, error) {
+	c, _, err := ReadWithFragments(path)
+	return c, err
+}
 
+// ReadWithFragments reads the config at path merged with
+// the fragments of its include_dirs, owners maps the
+// templates defined by fragments to their file
+func ReadWithFragments(path string) (c TPLAgent, owners map[string]string, err error) {
 	expandedPath := os.ExpandEnv(path)
-	confFile, err := os.Open(expandedPath)
+	c, locate, err := decodeFile(expandedPath)
 	if err != nil {
-		return TPLAgent{}, fatal.NewError(fmt.Errorf("read config:%w", err))
+		return TPLAgent{}, nil, err
 	}
 
-	ext := filepath.Ext(expandedPath)
-	if len(ext) > 0 {
-		ext = ext[1:]
+	position := linePosition(locate)
+	owners, position, err = mergeFragments(&c, expandedPath, position)
+	if err != nil {
+		return TPLAgent{}, nil, fatal.NewError(err)
 	}
-
-	return Read(confFile, ext)
+	if err := Validate(&c); err != nil {
+		return TPLAgent{}, nil, fatal.NewError(withPositions(err, position))
+	}
+	return c, owners, nil
 }
 
-type decoder interface {
-	Decode(v any) error
-}
+func decodeFile(path string) (TPLAgent, Locator, error) {
+	confFile, err := os.Open(path)
+	if err != nil {
+		return TPLAgent{}, nil, fatal.NewError(fmt.Errorf("read config:%w", err))
+	}
 
-func Read(rr io.Reader, configFormat string) (TPLAgent, error) {
-	var c TPLAgent
+	defer confFile.Close()
 
-	var cfgDecoder decoder
-	switch configFormat {
-	case "json":
-		cfgDecoder = json.NewDecoder(rr)
-	case "yaml,yml":
-		cfgDecoder = yaml.NewDecoder(rr)
-	default:
-		return TPLAgent{}, fmt.Errorf("unknown config format:%s", configFormat)
-	}
+	return decode(confFile, FormatFromPath(path))
+}
 
-	if err := cfgDecoder.Decode(&c); err != nil {
-		return TPLAgent{}, fatal.NewError(fmt.Errorf("config decode error:%w", err))
+func Read(rr io.Reader, configFormat string) (TPLAgent, error) {
+	c, locate, err := decode(rr, configFormat)
+	if err != nil {
+		return TPLAgent{}, err
 	}
 	if err := Validate(&c); err != nil {
-		return TPLAgent{}, fatal.NewError(err)
+		return TPLAgent{}, fatal.NewError(withPositions(err, linePosition(locate)))
 	}
 	return c, nil
 }
 
+func decode(rr io.Reader, configFormat string) (TPLAgent, Locator, error) {
+	format, err := lookupFormat(configFormat)
+	if err != nil {
+		return TPLAgent{}, nil, err
+	}
+	src, err := io.ReadAll(rr)
+	if err != nil {
+		return TPLAgent{}, nil, fatal.NewError(fmt.Errorf("read config:%w", err))
+	}
+	c, locate, err := format.Decode(src)
+	if err != nil {
+		return TPLAgent{}, nil, fatal.NewError(fmt.Errorf("config decode error:%w", err))
+	}
+	return c, locate, nil
+}
+
 func Validate(c *TPLAgent) error {
 	var valErrs []error
 	if _, ok := allowedLogFmts[c.Agent.LogFmt]; !ok {
-		valErrs = append(valErrs, fmt.Errorf("validate:invalid log format"))
+		valErrs = append(valErrs, atKey(fmt.Errorf("validate:invalid log format"), "agent", "log_fmt"))
 	}
 
 	for tmplName, tmplConfig := range c.TemplateSpecs {
@@ -120,34 +316,335 @@ func Validate(c *TPLAgent) error {
 			return fmt.Errorf(`validate:invalid template name: %s only "_" and "-" are allowed with alphabets`, tmplName)
 		}
 
-		refrInterval := tmplConfig.RefreshInterval
-		if refrInterval > 0 && refrInterval < duration.Duration(1*time.Second) {
-			refrIntErr := fmt.Errorf("validate:refresh interval should be >= 1s tmpl name:%s", tmplName)
-			valErrs = append(valErrs, refrIntErr)
+		for _, err := range validateTemplate(tmplName, tmplConfig, c) {
+			valErrs = append(valErrs, atKey(err, "templates", tmplName))
 		}
+	}
+
+	if _, err := DependencyOrder(c.TemplateSpecs); err != nil {
+		valErrs = append(valErrs, err)
+	}
+
+	if err := validateSupervise(c.Agent.Supervise, c.TemplateSpecs); err != nil {
+		valErrs = append(valErrs, atKey(err, "agent", "supervise"))
+	}
+
+	if err := validateListener(c.Agent.HTTPListenerAddr, c.Agent.Listener); err != nil {
+		valErrs = append(valErrs, atKey(err, "agent", "listener"))
+	}
 
-		if tmplConfig.Source == "" && tmplConfig.Raw == "" {
-			srcEmptyErr := fmt.Errorf("validate:expected one of Source OR Raw to be provided tmpl %s", tmplName)
-			valErrs = append(valErrs, srcEmptyErr)
+	for hookID, hook := range c.Agent.Hooks {
+		if err := validateHook(hookID, hook, c.TemplateSpecs); err != nil {
+			valErrs = append(valErrs, atKey(err, "agent", "hooks", hookID))
 		}
+	}
 
-		if len(tmplConfig.Actions) < 1 {
+	for groupName, group := range c.Agent.ExecGroups {
+		if group == nil || group.Cmd == "" {
+			valErrs = append(valErrs, atKey(fmt.Errorf("validate:exec group %s requires cmd", groupName), "agent", "exec_groups", groupName))
 			continue
 		}
-		actionValErrs := validateActionConfigs(tmplConfig.Actions)
-		if actionValErrs != nil {
-			actionValErrs = fmt.Errorf("validate:action invalid for %s:%w", tmplName, actionValErrs)
-			valErrs = append(valErrs, actionValErrs)
+		if err := validateExecTemplates(groupName, &group.ExecSpec); err != nil {
+			valErrs = append(valErrs, atKey(err, "agent", "exec_groups", groupName))
 		}
+	}
+
+	return errors.Join(valErrs...)
+
+}
+
+func validateTemplate(tmplName string, tmplConfig *TemplateSpec, c *TPLAgent) []error {
+	var valErrs []error
+
+	refrInterval := tmplConfig.RefreshInterval
+	if refrInterval > 0 && refrInterval < duration.Duration(1*time.Second) {
+		refrIntErr := fmt.Errorf("validate:refresh interval should be >= 1s tmpl name:%s", tmplName)
+		valErrs = append(valErrs, refrIntErr)
+	}
 
-		delimLen := len(tmplConfig.TemplateDelimiters)
-		if delimLen > 0 && delimLen != 2 {
-			valErrs = append(valErrs, fmt.Errorf("validate: invalid tplactions delimiters for %s", tmplName))
+	if tmplConfig.Source == "" && tmplConfig.Raw == "" && tmplConfig.SourceDir == "" {
+		srcEmptyErr := fmt.Errorf("validate:expected one of Source OR Raw OR SourceDir to be provided tmpl %s", tmplName)
+		valErrs = append(valErrs, srcEmptyErr)
+	}
+
+	if tmplConfig.SourceDir != "" && tmplConfig.DestinationDir == "" {
+		valErrs = append(valErrs, fmt.Errorf("validate:destination_dir is required with source_dir tmpl %s", tmplName))
+	}
+
+	if tmplConfig.SourceDir != "" && tmplConfig.HTML {
+		valErrs = append(valErrs, fmt.Errorf("validate:source_dir is not supported for html templates tmpl %s", tmplName))
+	}
+
+	if err := validateMode(tmplName, tmplConfig); err != nil {
+		valErrs = append(valErrs, err)
+	}
+
+	if err := validateOutputFormat(tmplName, tmplConfig); err != nil {
+		valErrs = append(valErrs, err)
+	}
+
+	if tmplConfig.Escape != "" && !escape.ValidMode(tmplConfig.Escape) {
+		valErrs = append(valErrs, fmt.Errorf("validate:invalid escape mode %s tmpl %s", tmplConfig.Escape, tmplName))
+	}
+
+	if tmplConfig.Escape != "" && tmplConfig.HTML {
+		valErrs = append(valErrs, fmt.Errorf("validate:escape cannot be used with html tmpl %s", tmplName))
+	}
+
+	if err := validateExecTemplates(tmplName, tmplConfig.Exec); err != nil {
+		valErrs = append(valErrs, err)
+	}
+
+	if err := validateNotify(tmplName, tmplConfig); err != nil {
+		valErrs = append(valErrs, err)
+	}
+
+	if err := validateWebhook(tmplName, tmplConfig); err != nil {
+		valErrs = append(valErrs, err)
+	}
+
+	if err := validateExecGroup(tmplName, tmplConfig, c.Agent.ExecGroups); err != nil {
+		valErrs = append(valErrs, err)
+	}
+
+	if err := validateGuard(tmplName, tmplConfig.Guard); err != nil {
+		valErrs = append(valErrs, err)
+	}
+
+	if tmplConfig.MultiOutput && tmplConfig.HTML {
+		valErrs = append(valErrs, fmt.Errorf("validate:multi_output is not supported for html templates tmpl %s", tmplName))
+	}
+
+	if len(tmplConfig.Actions) < 1 {
+		return valErrs
+	}
+	actionValErrs := validateActionConfigs(tmplConfig.Actions)
+	if actionValErrs != nil {
+		actionValErrs = fmt.Errorf("validate:action invalid for %s:%w", tmplName, actionValErrs)
+		valErrs = append(valErrs, actionValErrs)
+	}
+
+	delimLen := len(tmplConfig.TemplateDelimiters)
+	if delimLen > 0 && delimLen != 2 {
+		valErrs = append(valErrs, fmt.Errorf("validate: invalid tplactions delimiters for %s", tmplName))
+	}
+	return valErrs
+}
+
+func validateMode(tmplName string, tmplConfig *TemplateSpec) error {
+	if _, ok := allowedModes[tmplConfig.Mode]; !ok {
+		return fmt.Errorf("validate:invalid mode %s tmpl %s", tmplConfig.Mode, tmplName)
+	}
+
+	markersLen := len(tmplConfig.BlockMarkers)
+	if markersLen > 0 && markersLen != 2 {
+		return fmt.Errorf("validate:expected BEGIN and END block markers tmpl %s", tmplName)
+	}
+	if markersLen == 2 && (tmplConfig.BlockMarkers[0] == "" || tmplConfig.BlockMarkers[1] == "" ||
+		tmplConfig.BlockMarkers[0] == tmplConfig.BlockMarkers[1]) {
+		return fmt.Errorf("validate:block markers should be non empty and distinct tmpl %s", tmplName)
+	}
+
+	if tmplConfig.Mode == ModeManagedBlock && (tmplConfig.MultiOutput || tmplConfig.SourceDir != "") {
+		return fmt.Errorf("validate:managed_block mode cannot be used with multi_output or source_dir tmpl %s", tmplName)
+	}
+	return nil
+}
+
+func validateOutputFormat(tmplName string, tmplConfig *TemplateSpec) error {
+	if tmplConfig.OutputFormat == "" {
+		if tmplConfig.Canonicalize != "" {
+			return fmt.Errorf("validate:canonicalize requires output_format tmpl %s", tmplName)
 		}
+		return nil
+	}
+	if err := outformat.Validate(tmplConfig.OutputFormat, tmplConfig.Canonicalize); err != nil {
+		return fmt.Errorf("validate:%w tmpl %s", err, tmplName)
 	}
+	return nil
+}
 
-	return errors.Join(valErrs...)
+func validateGuard(tmplName string, guard *GuardSpec) error {
+	if guard == nil {
+		return nil
+	}
+	if guard.MinSize < 0 {
+		return fmt.Errorf("validate:guard min_size should be >= 0 tmpl %s", tmplName)
+	}
+	if guard.MaxShrinkPercent < 0 || guard.MaxShrinkPercent > 100 {
+		return fmt.Errorf("validate:guard max_shrink_percent should be between 0 and 100 tmpl %s", tmplName)
+	}
+	for _, pattern := range guard.Matches {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("validate:guard invalid pattern tmpl %s:%w", tmplName, err)
+		}
+	}
+	return nil
+}
+
+// validateExecTemplates checks the cmd_args and
+// env values which are expanded as templates
+func validateExecTemplates(tmplName string, exec *ExecSpec) error {
+	if exec == nil {
+		return nil
+	}
+	if exec.Retries < 0 {
+		return fmt.Errorf("validate:exec retries should be >= 0 tmpl %s", tmplName)
+	}
+	if !exec.ExpandTemplates {
+		return nil
+	}
+	for _, arg := range exec.CmdArgs {
+		if err := cmdexec.CheckValue(arg); err != nil {
+			return fmt.Errorf("validate:exec cmd_args tmpl %s:%w", tmplName, err)
+		}
+	}
+	for k, v := range exec.Env {
+		if err := cmdexec.CheckValue(v); err != nil {
+			return fmt.Errorf("validate:exec env %s tmpl %s:%w", k, tmplName, err)
+		}
+	}
+	return nil
+}
+
+func validateNotify(tmplName string, tmplConfig *TemplateSpec) error {
+	notify := tmplConfig.Notify
+	if notify == nil {
+		return nil
+	}
+	if tmplConfig.Exec != nil {
+		return fmt.Errorf("validate:exec and notify cannot be used together tmpl %s", tmplName)
+	}
+	if (notify.PIDFile == "") == (notify.ProcessName == "") {
+		return fmt.Errorf("validate:notify expects one of pid_file OR process_name tmpl %s", tmplName)
+	}
+	if _, err := signame.Parse(notify.Signal); err != nil {
+		return fmt.Errorf("validate:notify tmpl %s:%w", tmplName, err)
+	}
+	return nil
+}
 
+func validateWebhook(tmplName string, tmplConfig *TemplateSpec) error {
+	webhook := tmplConfig.Webhook
+	if webhook == nil {
+		return nil
+	}
+	if tmplConfig.Exec != nil || tmplConfig.Notify != nil {
+		return fmt.Errorf("validate:webhook cannot be used with exec or notify tmpl %s", tmplName)
+	}
+	if webhook.URL == "" {
+		return fmt.Errorf("validate:webhook url is required tmpl %s", tmplName)
+	}
+	if _, err := template.New("body").Parse(webhook.Body); err != nil {
+		return fmt.Errorf("validate:webhook body tmpl %s:%w", tmplName, err)
+	}
+	for _, status := range webhook.ExpectedStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("validate:webhook invalid expected status %d tmpl %s", status, tmplName)
+		}
+	}
+	return nil
+}
+
+func validateExecGroup(tmplName string, tmplConfig *TemplateSpec, groups map[string]*ExecGroupSpec) error {
+	if tmplConfig.ExecGroup == "" {
+		return nil
+	}
+	if tmplConfig.Exec != nil || tmplConfig.Notify != nil || tmplConfig.Webhook != nil {
+		return fmt.Errorf("validate:exec_group cannot be used with exec, notify or webhook tmpl %s", tmplName)
+	}
+	if _, ok := groups[tmplConfig.ExecGroup]; !ok {
+		return fmt.Errorf("validate:unknown exec group %s tmpl %s", tmplConfig.ExecGroup, tmplName)
+	}
+	return nil
+}
+
+func validateListener(addr string, spec *ListenerSpec) error {
+	socketPath, unixSocket := SocketPath(addr)
+	if unixSocket && socketPath == "" {
+		return errors.New("validate:listener unix socket path cannot be empty")
+	}
+	if spec == nil {
+		return nil
+	}
+	hasSocketOpts := spec.SocketMode != "" || spec.SocketGroup != "" ||
+		len(spec.AllowedUIDs) > 0 || len(spec.AllowedGIDs) > 0
+	if hasSocketOpts && !unixSocket {
+		return errors.New("validate:listener socket options require a unix:// listener address")
+	}
+	if spec.SocketMode != "" {
+		if mode, err := strconv.ParseUint(spec.SocketMode, 8, 32); err != nil || mode > 0777 {
+			return fmt.Errorf("validate:listener invalid socket_mode %s", spec.SocketMode)
+		}
+	}
+	if (spec.TLSCertFile == "") != (spec.TLSKeyFile == "") {
+		return errors.New("validate:listener expects both tls_cert_file and tls_key_file")
+	}
+	if spec.ClientCAFile != "" && spec.TLSCertFile == "" {
+		return errors.New("validate:listener client_ca_file requires tls")
+	}
+	// without a client ca no certificate is
+	// verified and the scopes never apply
+	if len(spec.ClientScopes) > 0 && spec.ClientCAFile == "" {
+		return errors.New("validate:listener client_scopes requires client_ca_file")
+	}
+	for _, t := range spec.Tokens {
+		if t.File == "" {
+			return errors.New("validate:listener token file cannot be empty")
+		}
+		if _, ok := allowedScopes[t.Scope]; !ok {
+			return fmt.Errorf("validate:listener invalid scope %s", t.Scope)
+		}
+	}
+	for cn, scope := range spec.ClientScopes {
+		if _, ok := allowedScopes[scope]; !ok {
+			return fmt.Errorf("validate:listener invalid scope %s for client %s", scope, cn)
+		}
+	}
+	return nil
+}
+
+func validateHook(id string, spec *HookSpec, specs map[string]*TemplateSpec) error {
+	if id == "" || !hasValidTemplName(id) {
+		return fmt.Errorf(`validate:invalid hook id: %s only "_" and "-" are allowed with alphabets`, id)
+	}
+	if spec == nil || spec.SecretFile == "" {
+		return fmt.Errorf("validate:hook %s requires secret_file", id)
+	}
+	if len(spec.Templates) < 1 && len(spec.Selector) < 1 {
+		return fmt.Errorf("validate:hook %s requires templates or selector", id)
+	}
+	for _, name := range spec.Templates {
+		if _, ok := specs[name]; !ok {
+			return fmt.Errorf("validate:hook %s refers to unknown template %s", id, name)
+		}
+	}
+	if spec.MaxSkew < 0 {
+		return fmt.Errorf("validate:hook %s max_skew cannot be negative", id)
+	}
+	return nil
+}
+
+func validateSupervise(spec *SuperviseSpec, specs map[string]*TemplateSpec) error {
+	if spec == nil {
+		return nil
+	}
+	for _, name := range spec.Templates {
+		if _, ok := specs[name]; !ok {
+			return fmt.Errorf("validate:supervise unknown template %s", name)
+		}
+	}
+	if spec.OnChange != "" && spec.OnChange != OnChangeRestart {
+		if _, err := signame.Parse(spec.OnChange); err != nil {
+			return fmt.Errorf("validate:supervise on_change should be restart or a signal:%w", err)
+		}
+	}
+	if spec.KillSignal != "" {
+		if _, err := signame.Parse(spec.KillSignal); err != nil {
+			return fmt.Errorf("validate:supervise kill_signal:%w", err)
+		}
+	}
+	return nil
 }
 
 func hasValidTemplName(tmplName string) bool {
@@ -224,7 +224,7 @@ func Test_config_fatalErrors(t *testing.T) {
 
 	t.Run("config decoding fails", func(t *testing.T) {
 		var buff bytes.Buffer
-		err := WriteTo(&buff, 1, 2)
+		err := WriteTo(&buff, 1, 2, "json")
 		if err != nil {
 			t.Error(err)
 			return
@@ -246,7 +246,7 @@ func Test_config_fatalErrors(t *testing.T) {
 	})
 	t.Run("config validation fails", func(t *testing.T) {
 		var buff bytes.Buffer
-		err := WriteTo(&buff, 1, 2)
+		err := WriteTo(&buff, 1, 2, "json")
 		if err != nil {
 			t.Error(err)
 			return
@@ -278,3 +278,388 @@ func Test_config_fatalErrors(t *testing.T) {
 	})
 
 }
+
+func Test_dependencyOrder(t *testing.T) {
+	t.Run("dependencies are ordered before dependents", func(t *testing.T) {
+		specs := map[string]*TemplateSpec{
+			"vhosts":    {Raw: "vhosts", DependsOn: []string{"inventory", "upstreams"}},
+			"upstreams": {Raw: "upstreams", DependsOn: []string{"inventory"}},
+			"inventory": {Raw: "inventory"},
+		}
+		order, err := DependencyOrder(specs)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected := []string{"inventory", "upstreams", "vhosts"}
+		if diff := cmp.Diff(expected, order); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
+	t.Run("validation fails for cycles and unknown dependencies", func(t *testing.T) {
+		tests := map[string]map[string]*TemplateSpec{
+			"cycle": {
+				"a": {Raw: "a", DependsOn: []string{"b"}},
+				"b": {Raw: "b", DependsOn: []string{"c"}},
+				"c": {Raw: "c", DependsOn: []string{"a"}},
+			},
+			"self dependency": {
+				"a": {Raw: "a", DependsOn: []string{"a"}},
+			},
+			"unknown dependency": {
+				"a": {Raw: "a", DependsOn: []string{"foo"}},
+			},
+			"multi output dependency": {
+				"a": {Raw: "a", DependsOn: []string{"b"}},
+				"b": {Raw: "b", Destination: "/etc/b", MultiOutput: true},
+			},
+			"source dir dependency": {
+				"a": {Raw: "a", DependsOn: []string{"b"}},
+				"b": {SourceDir: "/etc/b.d", DestinationDir: "/etc/b"},
+			},
+		}
+		for name, specs := range tests {
+			t.Run(name, func(t *testing.T) {
+				c := TPLAgent{
+					Agent:         Agent{LogFmt: "text"},
+					TemplateSpecs: specs,
+				}
+				if err := Validate(&c); err == nil {
+					t.Error("expected error got nil")
+				} else {
+					t.Log(err)
+				}
+			})
+		}
+	})
+}
+
+func Test_validateNotify(t *testing.T) {
+	tests := map[string]struct {
+		spec    *TemplateSpec
+		wantErr bool
+	}{
+		"pid file": {
+			spec: &TemplateSpec{Raw: "a", Notify: &NotifySpec{PIDFile: "/run/nginx.pid", Signal: "SIGHUP"}},
+		},
+		"process name": {
+			spec: &TemplateSpec{Raw: "a", Notify: &NotifySpec{ProcessName: "nginx", Signal: "hup"}},
+		},
+		"pid file and process name": {
+			spec:    &TemplateSpec{Raw: "a", Notify: &NotifySpec{PIDFile: "/run/nginx.pid", ProcessName: "nginx", Signal: "SIGHUP"}},
+			wantErr: true,
+		},
+		"unknown signal": {
+			spec:    &TemplateSpec{Raw: "a", Notify: &NotifySpec{PIDFile: "/run/nginx.pid", Signal: "SIGFOO"}},
+			wantErr: true,
+		},
+		"exec and notify": {
+			spec: &TemplateSpec{
+				Raw:    "a",
+				Exec:   &ExecSpec{Cmd: "echo"},
+				Notify: &NotifySpec{PIDFile: "/run/nginx.pid", Signal: "SIGHUP"},
+			},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := TPLAgent{
+				Agent:         Agent{LogFmt: "text"},
+				TemplateSpecs: map[string]*TemplateSpec{"a": tt.spec},
+			}
+			err := Validate(&c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateExecTemplates(t *testing.T) {
+	tests := map[string]struct {
+		exec    *ExecSpec
+		wantErr bool
+	}{
+		"actions are kept as is by default": {
+			exec: &ExecSpec{Cmd: "docker", CmdArgs: []string{"inspect", "-f", "{{.State.Pid}}", "app"}},
+		},
+		"expanded args": {
+			exec: &ExecSpec{
+				Cmd:             "reload.sh",
+				CmdArgs:         []string{"--config", "{{.Destination}}"},
+				Env:             map[string]string{"CONFIG_VERSION": "{{slice .NewHash 0 8}}"},
+				ExpandTemplates: true,
+			},
+		},
+		"unknown field in expanded arg": {
+			exec:    &ExecSpec{Cmd: "docker", CmdArgs: []string{"{{.State.Pid}}"}, ExpandTemplates: true},
+			wantErr: true,
+		},
+		"unknown field in expanded env": {
+			exec:    &ExecSpec{Cmd: "reload.sh", Env: map[string]string{"DEST": "{{.Dest}}"}, ExpandTemplates: true},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := TPLAgent{
+				Agent:         Agent{LogFmt: "text"},
+				TemplateSpecs: map[string]*TemplateSpec{"a": {Raw: "a", Exec: tt.exec}},
+			}
+			err := Validate(&c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateWebhook(t *testing.T) {
+	tests := map[string]struct {
+		spec    *TemplateSpec
+		wantErr bool
+	}{
+		"valid": {
+			spec: &TemplateSpec{Raw: "a", Webhook: &WebhookSpec{URL: "http://localhost:9901/reload", Body: `{"hash":"{{.Hash}}"}`}},
+		},
+		"missing url": {
+			spec:    &TemplateSpec{Raw: "a", Webhook: &WebhookSpec{}},
+			wantErr: true,
+		},
+		"invalid body": {
+			spec:    &TemplateSpec{Raw: "a", Webhook: &WebhookSpec{URL: "http://localhost", Body: "{{.Hash"}},
+			wantErr: true,
+		},
+		"invalid status": {
+			spec:    &TemplateSpec{Raw: "a", Webhook: &WebhookSpec{URL: "http://localhost", ExpectedStatus: []int{42}}},
+			wantErr: true,
+		},
+		"exec and webhook": {
+			spec:    &TemplateSpec{Raw: "a", Exec: &ExecSpec{Cmd: "echo"}, Webhook: &WebhookSpec{URL: "http://localhost"}},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := TPLAgent{
+				Agent:         Agent{LogFmt: "text"},
+				TemplateSpecs: map[string]*TemplateSpec{"a": tt.spec},
+			}
+			err := Validate(&c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateMode(t *testing.T) {
+	tests := map[string]struct {
+		markers []string
+		wantErr bool
+	}{
+		"default markers": {},
+		"custom markers":  {markers: []string{"# BEGIN", "# END"}},
+		"empty BEGIN marker": {
+			markers: []string{"", "# END"},
+			wantErr: true,
+		},
+		"empty END marker": {
+			markers: []string{"# BEGIN", ""},
+			wantErr: true,
+		},
+		"same markers": {
+			markers: []string{"# tplagent", "# tplagent"},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := TPLAgent{
+				Agent: Agent{LogFmt: "text"},
+				TemplateSpecs: map[string]*TemplateSpec{"a": {
+					Raw:          "a",
+					Mode:         ModeManagedBlock,
+					BlockMarkers: tt.markers,
+				}},
+			}
+			err := Validate(&c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateExecGroup(t *testing.T) {
+	groups := map[string]*ExecGroupSpec{
+		"nginx": {ExecSpec: ExecSpec{Cmd: "service", CmdArgs: []string{"nginx", "reload"}}},
+	}
+	tests := map[string]struct {
+		spec    *TemplateSpec
+		groups  map[string]*ExecGroupSpec
+		wantErr bool
+	}{
+		"valid": {
+			spec:   &TemplateSpec{Raw: "a", ExecGroup: "nginx"},
+			groups: groups,
+		},
+		"unknown group": {
+			spec:    &TemplateSpec{Raw: "a", ExecGroup: "envoy"},
+			groups:  groups,
+			wantErr: true,
+		},
+		"exec and exec group": {
+			spec:    &TemplateSpec{Raw: "a", ExecGroup: "nginx", Exec: &ExecSpec{Cmd: "echo"}},
+			groups:  groups,
+			wantErr: true,
+		},
+		"group without cmd": {
+			spec:    &TemplateSpec{Raw: "a", ExecGroup: "nginx"},
+			groups:  map[string]*ExecGroupSpec{"nginx": {}},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := TPLAgent{
+				Agent:         Agent{LogFmt: "text", ExecGroups: tt.groups},
+				TemplateSpecs: map[string]*TemplateSpec{"a": tt.spec},
+			}
+			err := Validate(&c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateListener(t *testing.T) {
+	tests := map[string]struct {
+		addr    string
+		spec    *ListenerSpec
+		wantErr bool
+	}{
+		"tokens": {
+			spec: &ListenerSpec{Tokens: []TokenSpec{{File: "/etc/tplagent/token", Scope: ScopeRead}}},
+		},
+		"mtls": {
+			spec: &ListenerSpec{
+				TLSCertFile:  "/etc/tplagent/tls.crt",
+				TLSKeyFile:   "/etc/tplagent/tls.key",
+				ClientCAFile: "/etc/tplagent/ca.crt",
+				ClientScopes: map[string]string{"ops": ScopeAdmin},
+			},
+		},
+		"cert without key": {
+			spec:    &ListenerSpec{TLSCertFile: "/etc/tplagent/tls.crt"},
+			wantErr: true,
+		},
+		"client ca without tls": {
+			spec:    &ListenerSpec{ClientCAFile: "/etc/tplagent/ca.crt"},
+			wantErr: true,
+		},
+		"client scopes without client ca": {
+			spec: &ListenerSpec{
+				TLSCertFile:  "/etc/tplagent/tls.crt",
+				TLSKeyFile:   "/etc/tplagent/tls.key",
+				ClientScopes: map[string]string{"ops": ScopeAdmin},
+			},
+			wantErr: true,
+		},
+		"invalid token scope": {
+			spec:    &ListenerSpec{Tokens: []TokenSpec{{File: "/etc/tplagent/token", Scope: "root"}}},
+			wantErr: true,
+		},
+		"unix socket": {
+			addr: "unix:///run/tplagent.sock",
+			spec: &ListenerSpec{SocketMode: "0660", SocketGroup: "tplagent", AllowedUIDs: []int{0}},
+		},
+		"empty socket path": {
+			addr:    "unix://",
+			wantErr: true,
+		},
+		"socket options on tcp": {
+			addr:    "localhost:6000",
+			spec:    &ListenerSpec{AllowedGIDs: []int{0}},
+			wantErr: true,
+		},
+		"invalid socket mode": {
+			addr:    "unix:///run/tplagent.sock",
+			spec:    &ListenerSpec{SocketMode: "0999"},
+			wantErr: true,
+		},
+		"invalid client scope": {
+			spec: &ListenerSpec{
+				TLSCertFile:  "/etc/tplagent/tls.crt",
+				TLSKeyFile:   "/etc/tplagent/tls.key",
+				ClientCAFile: "/etc/tplagent/ca.crt",
+				ClientScopes: map[string]string{"ops": "write"},
+			},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := TPLAgent{
+				Agent:         Agent{LogFmt: "text", HTTPListenerAddr: tt.addr, Listener: tt.spec},
+				TemplateSpecs: map[string]*TemplateSpec{"a": {Raw: "a"}},
+			}
+			err := Validate(&c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_validateHook(t *testing.T) {
+	tests := map[string]struct {
+		id      string
+		spec    *HookSpec
+		wantErr bool
+	}{
+		"templates": {
+			id:   "git",
+			spec: &HookSpec{SecretFile: "/etc/tplagent/git.secret", Templates: []string{"a"}},
+		},
+		"selector": {
+			id:   "vault",
+			spec: &HookSpec{SecretFile: "/etc/tplagent/vault.secret", Selector: map[string]string{"team": "payments"}},
+		},
+		"missing secret": {
+			id:      "git",
+			spec:    &HookSpec{Templates: []string{"a"}},
+			wantErr: true,
+		},
+		"no targets": {
+			id:      "git",
+			spec:    &HookSpec{SecretFile: "/etc/tplagent/git.secret"},
+			wantErr: true,
+		},
+		"unknown template": {
+			id:      "git",
+			spec:    &HookSpec{SecretFile: "/etc/tplagent/git.secret", Templates: []string{"b"}},
+			wantErr: true,
+		},
+		"invalid id": {
+			id:      "git/main",
+			spec:    &HookSpec{SecretFile: "/etc/tplagent/git.secret", Templates: []string{"a"}},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := TPLAgent{
+				Agent:         Agent{LogFmt: "text", Hooks: map[string]*HookSpec{tt.id: tt.spec}},
+				TemplateSpecs: map[string]*TemplateSpec{"a": {Raw: "a"}},
+			}
+			err := Validate(&c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DependencyOrder returns the template names sorted
+// such that every template appears after all the
+// templates it depends on, an error is returned if
+// a dependency is unknown, renders many files or
+// the dependencies form a cycle
+func DependencyOrder(specs map[string]*TemplateSpec) ([]string, error) {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(specs))
+	order := make([]string, 0, len(specs))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := append(slices.Clone(path[slices.Index(path, name):]), name)
+			return fmt.Errorf("validate:dependency cycle %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range specs[name].DependsOn {
+			depSpec, ok := specs[dep]
+			if !ok {
+				return fmt.Errorf("validate:unknown dependency %s for tmpl %s", dep, name)
+			}
+			// dependencies are read from a single
+			// destination file
+			if depSpec.MultiOutput || depSpec.SourceDir != "" {
+				return fmt.Errorf("validate:dependency %s for tmpl %s uses multi_output or source_dir", dep, name)
+			}
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Format decodes and encodes
+// configs written in one syntax
+type Format interface {
+	// Decode returns the config and a Locator
+	// for its keys, the Locator can be nil
+	Decode(src []byte) (TPLAgent, Locator, error)
+	Encode(wr io.Writer, c TPLAgent, indent int) error
+}
+
+// Locator returns the source line of the
+// deepest key of path found in the config
+type Locator func(path ...string) (line int, ok bool)
+
+var formats = map[string]Format{}
+
+// extensions maps file extensions
+// and aliases to format names
+var extensions = map[string]string{}
+
+// RegisterFormat adds a format read by ReadFromFile
+// from files having its name or one of extensions
+func RegisterFormat(name string, f Format, exts ...string) {
+	if _, ok := formats[name]; ok {
+		panic(fmt.Sprintf("config format %s already exists", name))
+	}
+	formats[name] = f
+	extensions[name] = name
+	for _, ext := range exts {
+		extensions[ext] = name
+	}
+}
+
+func init() {
+	RegisterFormat("json", jsonFormat{})
+	RegisterFormat("yaml", yamlFormat{}, "yml")
+	RegisterFormat("toml", tomlFormat{})
+	RegisterFormat("hcl", hclFormat{})
+}
+
+// Formats returns the names
+// of the registered formats
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func lookupFormat(name string) (Format, error) {
+	if f, ok := formats[extensions[name]]; ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("unknown config format:%s", name)
+}
+
+// keyErr is a validation error
+// of the config key at path
+type keyErr struct {
+	path []string
+	err  error
+}
+
+func (k keyErr) Error() string {
+	return k.err.Error()
+}
+
+func (k keyErr) Unwrap() error {
+	return k.err
+}
+
+func atKey(err error, path ...string) error {
+	if err == nil {
+		return nil
+	}
+	return keyErr{path: path, err: err}
+}
+
+// positioner describes the source
+// position of a config key
+type positioner func(path ...string) (string, bool)
+
+func linePosition(locate Locator) positioner {
+	return func(path ...string) (string, bool) {
+		if locate == nil {
+			return "", false
+		}
+		line, ok := locate(path...)
+		return fmt.Sprintf("line %d", line), ok
+	}
+}
+
+// withPositions prefixes validation errors
+// with the source position of their key
+func withPositions(err error, position positioner) error {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = slices.Clone(joined.Unwrap())
+	}
+	for i, e := range errs {
+		var ke keyErr
+		if !errors.As(e, &ke) {
+			continue
+		}
+		if pos, ok := position(ke.path...); ok {
+			errs[i] = fmt.Errorf("%s: %w", pos, e)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// keyLines indexes the source
+// lines of the config keys
+type keyLines map[string]int
+
+func keyPath(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+func (kl keyLines) add(path []string, line int) {
+	if _, ok := kl[keyPath(path)]; !ok {
+		kl[keyPath(path)] = line
+	}
+}
+
+func (kl keyLines) locate(path ...string) (int, bool) {
+	for i := len(path); i > 0; i-- {
+		if line, ok := kl[keyPath(path[:i])]; ok {
+			return line, true
+		}
+	}
+	return 0, false
+}
+
+func lineAt(src []byte, offset int64) int {
+	offset = min(offset, int64(len(src)))
+	return 1 + strings.Count(string(src[:offset]), "\n")
+}
+
+// toTree converts c to the generic values
+// encoded by formats without struct encoding
+// of their own, null values are dropped
+func toTree(c TPLAgent) (map[string]any, error) {
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(bs)))
+	dec.UseNumber()
+	var tree map[string]any
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return dropNulls(tree).(map[string]any), nil
+}
+
+func dropNulls(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, elem := range v {
+			if elem == nil {
+				delete(v, k)
+				continue
+			}
+			v[k] = dropNulls(elem)
+		}
+	case []any:
+		for i, elem := range v {
+			v[i] = dropNulls(elem)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// fromTree decodes configs parsed into generic
+// values by formats without struct decoding of
+// their own, action configs become JSON messages
+func fromTree(tree map[string]any, lines keyLines) (TPLAgent, error) {
+	var c TPLAgent
+	bs, err := json.Marshal(tree)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(bs, &c)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		err = fmt.Errorf("cannot use %s as %s for %s", typeErr.Value, typeErr.Type, typeErr.Field)
+		if line, ok := lines.locate(strings.Split(typeErr.Field, ".")...); ok {
+			err = fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return c, err
+}
@@ -0,0 +1,356 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// hclFormat maps nested structs to blocks, maps of
+// structs to labeled blocks and slices of structs
+// to repeated blocks, everything else is an attribute
+//
+//	agent {
+//	  log_fmt = "text"
+//	}
+//	templates "nginx-conf" {
+//	  source = "/etc/nginx/nginx.conf.tmpl"
+//	  exec {
+//	    cmd = "service"
+//	  }
+//	}
+type hclFormat struct{}
+
+var rawMessageType = reflect.TypeOf(RawMessage{})
+
+func (hclFormat) Decode(src []byte) (TPLAgent, Locator, error) {
+	file, diags := hclsyntax.ParseConfig(src, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return TPLAgent{}, nil, hclErr(diags)
+	}
+	lines := keyLines{}
+	tree, diags := hclTree(file.Body.(*hclsyntax.Body), reflect.TypeOf(TPLAgent{}), nil, lines)
+	if diags.HasErrors() {
+		return TPLAgent{}, nil, hclErr(diags)
+	}
+	c, err := fromTree(tree, lines)
+	if err != nil {
+		return TPLAgent{}, nil, err
+	}
+	return c, lines.locate, nil
+}
+
+func (hclFormat) Encode(wr io.Writer, c TPLAgent, _ int) error {
+	tree, err := toTree(c)
+	if err != nil {
+		return err
+	}
+	f := hclwrite.NewEmptyFile()
+	if err := writeHCLBody(f.Body(), tree, reflect.TypeOf(c)); err != nil {
+		return err
+	}
+	_, err = wr.Write(f.Bytes())
+	return err
+}
+
+func hclErr(diags hcl.Diagnostics) error {
+	var errs []error
+	for _, d := range diags.Errs() {
+		var diag *hcl.Diagnostic
+		if errors.As(d, &diag) && diag.Subject != nil {
+			errs = append(errs, fmt.Errorf("line %d: %s; %s", diag.Subject.Start.Line, diag.Summary, diag.Detail))
+			continue
+		}
+		errs = append(errs, d)
+	}
+	return errors.Join(errs...)
+}
+
+// hclTree converts body to generic values, t is the
+// struct decoded from body or nil if it has no schema
+func hclTree(body *hclsyntax.Body, t reflect.Type, path []string, lines keyLines) (map[string]any, hcl.Diagnostics) {
+	tree := map[string]any{}
+	for name, attr := range body.Attributes {
+		val, diags := attr.Expr.Value(envVariables(attr.Expr))
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		v, err := ctyToAny(val)
+		if err != nil {
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid value",
+				Detail:   err.Error(),
+				Subject:  attr.SrcRange.Ptr(),
+			}}
+		}
+		tree[name] = v
+		lines.add(append(slices.Clone(path), name), attr.SrcRange.Start.Line)
+	}
+
+	for _, block := range body.Blocks {
+		blockPath := append(slices.Clone(path), block.Type)
+		ft, ok := hclFieldType(t, block.Type)
+		if t != nil && !ok {
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Unsupported block type",
+				Detail:   fmt.Sprintf("Blocks of type %q are not expected here.", block.Type),
+				Subject:  block.TypeRange.Ptr(),
+			}}
+		}
+
+		var diags hcl.Diagnostics
+		switch {
+		case isHCLBlock(ft) && len(block.Labels) == 0:
+			if _, dup := tree[block.Type]; dup {
+				return nil, hcl.Diagnostics{{
+					Severity: hcl.DiagError,
+					Summary:  "Duplicate block",
+					Detail:   fmt.Sprintf("Only one %q block is allowed.", block.Type),
+					Subject:  block.TypeRange.Ptr(),
+				}}
+			}
+			lines.add(blockPath, block.TypeRange.Start.Line)
+			tree[block.Type], diags = hclTree(block.Body, deref(ft), blockPath, lines)
+		case ft != nil && ft.Kind() == reflect.Slice && isHCLBlock(ft.Elem()) && len(block.Labels) == 0:
+			lines.add(blockPath, block.TypeRange.Start.Line)
+			var elem map[string]any
+			elem, diags = hclTree(block.Body, deref(ft.Elem()), blockPath, lines)
+			list, _ := tree[block.Type].([]any)
+			tree[block.Type] = append(list, elem)
+		case ft != nil && ft.Kind() == reflect.Map && isHCLBlock(ft.Elem()) && len(block.Labels) == 1:
+			labelPath := append(blockPath, block.Labels[0])
+			lines.add(labelPath, block.TypeRange.Start.Line)
+			m, _ := tree[block.Type].(map[string]any)
+			if m == nil {
+				m = map[string]any{}
+				tree[block.Type] = m
+			}
+			m[block.Labels[0]], diags = hclTree(block.Body, deref(ft.Elem()), labelPath, lines)
+		case t == nil || isHCLValue(ft):
+			// values without a schema like action
+			// configs nest labeled blocks as objects
+			lines.add(blockPath, block.TypeRange.Start.Line)
+			parent := tree
+			key := block.Type
+			for _, label := range block.Labels {
+				child, _ := parent[key].(map[string]any)
+				if child == nil {
+					child = map[string]any{}
+					parent[key] = child
+				}
+				parent, key = child, label
+			}
+			parent[key], diags = hclTree(block.Body, nil, blockPath, lines)
+		default:
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid block labels",
+				Detail:   fmt.Sprintf("Blocks of type %q take %d labels.", block.Type, hclLabels(ft)),
+				Subject:  block.TypeRange.Ptr(),
+			}}
+		}
+		if diags.HasErrors() {
+			return nil, diags
+		}
+	}
+	return tree, nil
+}
+
+// envVariables keeps ${VAR} in strings as is so that
+// it is expanded from the environment like in the
+// other formats, $${VAR} is the HCL escape for it
+func envVariables(expr hcl.Expression) *hcl.EvalContext {
+	vars := map[string]cty.Value{}
+	for _, traversal := range expr.Variables() {
+		if len(traversal) == 1 {
+			name := traversal.RootName()
+			vars[name] = cty.StringVal("${" + name + "}")
+		}
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+	return &hcl.EvalContext{Variables: vars}
+}
+
+func writeHCLBody(body *hclwrite.Body, tree map[string]any, t reflect.Type) error {
+	for _, key := range hclKeyOrder(tree, t) {
+		v := tree[key]
+		ft, _ := hclFieldType(t, key)
+		switch {
+		case isHCLBlock(ft):
+			m, _ := v.(map[string]any)
+			if err := writeHCLBody(body.AppendNewBlock(key, nil).Body(), m, deref(ft)); err != nil {
+				return err
+			}
+		case ft != nil && ft.Kind() == reflect.Slice && isHCLBlock(ft.Elem()):
+			list, _ := v.([]any)
+			for _, elem := range list {
+				m, _ := elem.(map[string]any)
+				if err := writeHCLBody(body.AppendNewBlock(key, nil).Body(), m, deref(ft.Elem())); err != nil {
+					return err
+				}
+			}
+		case ft != nil && ft.Kind() == reflect.Map && isHCLBlock(ft.Elem()):
+			m, _ := v.(map[string]any)
+			labels := make([]string, 0, len(m))
+			for label := range m {
+				labels = append(labels, label)
+			}
+			slices.Sort(labels)
+			for _, label := range labels {
+				elem, _ := m[label].(map[string]any)
+				if err := writeHCLBody(body.AppendNewBlock(key, []string{label}).Body(), elem, deref(ft.Elem())); err != nil {
+					return err
+				}
+			}
+		default:
+			val, err := anyToCty(v)
+			if err != nil {
+				return fmt.Errorf("encode %s:%w", key, err)
+			}
+			body.SetAttributeValue(key, val)
+		}
+	}
+	return nil
+}
+
+// hclKeyOrder follows the field order of
+// t, keys without a field come last
+func hclKeyOrder(tree map[string]any, t reflect.Type) []string {
+	var keys []string
+	seen := map[string]bool{}
+	if t != nil {
+		for _, name := range jsonFieldNames(t) {
+			if _, ok := tree[name]; ok {
+				keys = append(keys, name)
+				seen[name] = true
+			}
+		}
+	}
+	var rest []string
+	for key := range tree {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	slices.Sort(rest)
+	return append(keys, rest...)
+}
+
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			names = append(names, jsonFieldNames(field.Type)...)
+			continue
+		}
+		if name := jsonFieldName(field); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// hclFieldType returns the type of the
+// field of struct t encoded as name
+func hclFieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if ft, ok := hclFieldType(field.Type, name); ok {
+				return ft, true
+			}
+			continue
+		}
+		if jsonFieldName(field) == name {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
+
+func deref(t reflect.Type) reflect.Type {
+	if t != nil && t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+func isHCLBlock(t reflect.Type) bool {
+	t = deref(t)
+	return t != nil && t.Kind() == reflect.Struct && t != rawMessageType
+}
+
+// isHCLValue reports if blocks of t
+// are decoded without a schema
+func isHCLValue(t reflect.Type) bool {
+	t = deref(t)
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Map:
+		return !isHCLBlock(t.Elem())
+	}
+	return t == rawMessageType
+}
+
+func hclLabels(t reflect.Type) int {
+	if t.Kind() == reflect.Map {
+		return 1
+	}
+	return 0
+}
+
+func ctyToAny(val cty.Value) (any, error) {
+	bs, err := ctyjson.Marshal(val, val.Type())
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	dec := json.NewDecoder(strings.NewReader(string(bs)))
+	dec.UseNumber()
+	err = dec.Decode(&v)
+	return v, err
+}
+
+func anyToCty(v any) (cty.Value, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	ty, err := ctyjson.ImpliedType(bs)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	return ctyjson.Unmarshal(bs, ty)
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+type jsonFormat struct{}
+
+func (jsonFormat) Decode(src []byte) (TPLAgent, Locator, error) {
+	var c TPLAgent
+	err := json.NewDecoder(bytes.NewReader(src)).Decode(&c)
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return c, nil, fmt.Errorf("line %d: %w", lineAt(src, syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		return c, nil, fmt.Errorf("line %d: %w", lineAt(src, typeErr.Offset), err)
+	case err != nil:
+		return c, nil, err
+	}
+	return c, jsonKeyLines(src).locate, nil
+}
+
+func (jsonFormat) Encode(wr io.Writer, c TPLAgent, indent int) error {
+	jd := json.NewEncoder(wr)
+	jd.SetIndent("", strings.Repeat(" ", indent))
+	return jd.Encode(c)
+}
+
+// jsonKeyLines walks the tokens of src since
+// encoding/json does not report key positions
+func jsonKeyLines(src []byte) keyLines {
+	type frame struct {
+		object  bool
+		keyNext bool
+		key     string
+		path    []string
+	}
+
+	lines := keyLines{}
+	var stack []*frame
+	valueDone := func() {
+		if len(stack) > 0 && stack[len(stack)-1].object {
+			stack[len(stack)-1].keyNext = true
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(src))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return lines
+		}
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			stack = stack[:len(stack)-1]
+			valueDone()
+			continue
+		}
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && top.object && top.keyNext {
+			top.key, _ = tok.(string)
+			top.keyNext = false
+			lines.add(append(slices.Clone(top.path), top.key), lineAt(src, dec.InputOffset()))
+			continue
+		}
+
+		d, ok := tok.(json.Delim)
+		if !ok {
+			valueDone()
+			continue
+		}
+		var path []string
+		if top != nil {
+			path = top.path
+			if top.object {
+				path = append(slices.Clone(path), top.key)
+			}
+		}
+		stack = append(stack, &frame{object: d == '{', keyNext: d == '{', path: path})
+	}
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatFromPath(t *testing.T) {
+	tests := map[string]string{
+		"/etc/tplagent/config.json": "json",
+		"/etc/tplagent/config.yml":  "yaml",
+		"/etc/tplagent/config.yaml": "yaml",
+		"/etc/tplagent/config.toml": "toml",
+		"/etc/tplagent/config.hcl":  "hcl",
+		"/etc/tplagent/config.ini":  "ini",
+	}
+	for path, expected := range tests {
+		if got := FormatFromPath(path); got != expected {
+			t.Errorf("%s: expected %s got %s", path, expected, got)
+		}
+	}
+}
+
+func TestRead_validationLines(t *testing.T) {
+	sources := map[string]string{
+		"json": `{
+  "agent": {
+    "log_fmt": "xml"
+  },
+  "templates": {
+    "app": {
+      "raw": "hello",
+      "refresh_interval": "500ms"
+    }
+  }
+}`,
+		"yaml": `agent:
+  log_fmt: xml
+
+templates:
+  app:
+    raw: hello
+    refresh_interval: 500ms
+`,
+		"toml": `[agent]
+log_fmt = "xml"
+
+[templates.app]
+raw = "hello"
+refresh_interval = "500ms"
+`,
+		"hcl": `agent {
+  log_fmt = "xml"
+}
+
+templates "app" {
+  raw              = "hello"
+  refresh_interval = "500ms"
+}
+`,
+	}
+	expectedLines := map[string][]string{
+		"json": {"line 3: validate:invalid log format", "line 6: validate:refresh interval"},
+		"yaml": {"line 2: validate:invalid log format", "line 5: validate:refresh interval"},
+		"toml": {"line 2: validate:invalid log format", "line 4: validate:refresh interval"},
+		"hcl":  {"line 2: validate:invalid log format", "line 5: validate:refresh interval"},
+	}
+
+	for format, src := range sources {
+		t.Run(format, func(t *testing.T) {
+			_, err := Read(strings.NewReader(src), format)
+			if err == nil {
+				t.Fatal("expected validation error")
+			}
+			for _, expected := range expectedLines[format] {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected %q in:\n%s", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRead_decodeErrorLines(t *testing.T) {
+	sources := map[string]string{
+		"json": "{\n  \"agent\": {\n    \"log_fmt\": \"text\",\n  }\n}",
+		"yaml": "agent:\n  log_fmt: text\n    max: [\n",
+		"toml": "[agent]\nlog_fmt = \"text\"\nmax_consecutive_failures = \n",
+		"hcl":  "agent {\n  log_fmt = \"text\"\n  max_consecutive_failures = \n}\n",
+	}
+	for format, src := range sources {
+		t.Run(format, func(t *testing.T) {
+			_, err := Read(strings.NewReader(src), format)
+			if err == nil {
+				t.Fatal("expected decode error")
+			}
+			if !strings.Contains(err.Error(), "line 3") && !strings.Contains(err.Error(), "line 4") {
+				t.Errorf("expected the error line in:\n%s", err)
+			}
+		})
+	}
+}
+
+func TestRead_hcl(t *testing.T) {
+	src := `agent {
+  log_fmt   = "text"
+  log_level = "INFO"
+}
+
+templates "nginx-conf" {
+  source           = "${TEMPLATE_DIR}/nginx.conf.tmpl"
+  destination      = "/etc/nginx/nginx.conf"
+  refresh_interval = "15s"
+
+  actions {
+    name = "httpjson"
+    config {
+      base_url = "http://localhost"
+      headers {
+        Accept = "application/json"
+      }
+    }
+  }
+
+  exec {
+    cmd      = "service"
+    cmd_args = ["nginx", "reload"]
+    env = {
+      DATA_DIR = "/var/lib/data"
+    }
+  }
+}
+`
+	c, err := Read(strings.NewReader(src), "hcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := c.TemplateSpecs["nginx-conf"]
+	if spec == nil || spec.Exec == nil || spec.Exec.Env["DATA_DIR"] != "/var/lib/data" {
+		t.Fatalf("unexpected template spec %+v", spec)
+	}
+	if spec.Source != "${TEMPLATE_DIR}/nginx.conf.tmpl" {
+		t.Errorf("expected ${TEMPLATE_DIR} to be kept for env expansion got %s", spec.Source)
+	}
+
+	var buff strings.Builder
+	if err := Encode(&buff, c, "hcl"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buff.String(), `"$${TEMPLATE_DIR}/nginx.conf.tmpl"`) {
+		t.Errorf("expected the encoded source to be escaped in:\n%s", buff.String())
+	}
+
+	var actionConf struct {
+		BaseURL string            `json:"base_url"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := spec.Actions[0].Config.Decode(&actionConf); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{"Accept": "application/json"}, actionConf.Headers); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+
+	_, err = Read(strings.NewReader("agent {\n  log_fmt = \"text\"\n}\nagent {\n}\n"), "hcl")
+	if err == nil || !strings.Contains(err.Error(), "line 4: Duplicate block") {
+		t.Errorf("expected a duplicate block error got %v", err)
+	}
+}
+
+func TestRead_toml(t *testing.T) {
+	src := `[agent]
+log_fmt = "text"
+log_level = "INFO"
+
+[templates.nginx-conf]
+source = "/etc/nginx/nginx.conf.tmpl"
+destination = "/etc/nginx/nginx.conf"
+refresh_interval = "15s"
+raw = '''
+{{ .name }}
+[not.a.table]
+'''
+
+[[templates.nginx-conf.actions]]
+name = "httpjson"
+config = { base_url = "http://localhost", retries = 3 }
+
+[templates.nginx-conf.exec]
+cmd = "service"
+cmd_args = ["nginx", "reload"]
+`
+	c, err := Read(strings.NewReader(src), "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := c.TemplateSpecs["nginx-conf"]
+	if spec == nil || spec.Exec == nil || spec.Exec.Cmd != "service" {
+		t.Fatalf("unexpected template spec %+v", spec)
+	}
+	var actionConf struct {
+		BaseURL string `json:"base_url"`
+		Retries int    `json:"retries"`
+	}
+	if err := spec.Actions[0].Config.Decode(&actionConf); err != nil {
+		t.Fatal(err)
+	}
+	if actionConf.BaseURL != "http://localhost" || actionConf.Retries != 3 {
+		t.Errorf("unexpected action config %+v", actionConf)
+	}
+
+	lines := tomlKeyLines([]byte(src))
+	if line, _ := lines.locate("templates", "nginx-conf", "exec", "cmd"); line != 19 {
+		t.Errorf("expected exec.cmd on line 19 got %d", line)
+	}
+	if _, ok := lines.locate("not"); ok {
+		t.Error("expected multiline strings to be skipped")
+	}
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/BurntSushi/toml"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type tomlFormat struct{}
+
+func (tomlFormat) Decode(src []byte) (TPLAgent, Locator, error) {
+	var tree map[string]any
+	if _, err := toml.NewDecoder(bytes.NewReader(src)).Decode(&tree); err != nil {
+		return TPLAgent{}, nil, err
+	}
+	lines := tomlKeyLines(src)
+	c, err := fromTree(tree, lines)
+	if err != nil {
+		return TPLAgent{}, nil, err
+	}
+	return c, lines.locate, nil
+}
+
+func (tomlFormat) Encode(wr io.Writer, c TPLAgent, indent int) error {
+	tree, err := toTree(c)
+	if err != nil {
+		return err
+	}
+	te := toml.NewEncoder(wr)
+	te.Indent = strings.Repeat(" ", indent)
+	return te.Encode(tree)
+}
+
+const tomlKeyPart = `(?:"(?:[^"\\]|\\.)*"|'[^']*'|[A-Za-z0-9_-]+)`
+
+var tomlDottedKey = tomlKeyPart + `(?:\s*\.\s*` + tomlKeyPart + `)*`
+var tomlTableRe = regexp.MustCompile(`^\[\[?\s*(` + tomlDottedKey + `)\s*\]\]?`)
+var tomlAssignRe = regexp.MustCompile(`^(` + tomlDottedKey + `)\s*=`)
+var tomlKeyPartRe = regexp.MustCompile(tomlKeyPart)
+
+// tomlKeyLines scans table headers and key
+// assignments since the toml decoder does
+// not report key positions
+func tomlKeyLines(src []byte) keyLines {
+	lines := keyLines{}
+	var table []string
+	inMultiline := false
+
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if inMultiline {
+			inMultiline = !closesMultiline(line)
+			continue
+		}
+
+		if m := tomlTableRe.FindStringSubmatch(line); m != nil {
+			table = splitTOMLKey(m[1])
+			lines.add(table, lineNum)
+			continue
+		}
+		if m := tomlAssignRe.FindStringSubmatch(line); m != nil {
+			lines.add(append(slices.Clone(table), splitTOMLKey(m[1])...), lineNum)
+			inMultiline = opensMultiline(line[len(m[0]):])
+		}
+	}
+	return lines
+}
+
+func splitTOMLKey(dotted string) []string {
+	parts := tomlKeyPartRe.FindAllString(dotted, -1)
+	for i, p := range parts {
+		switch {
+		case strings.HasPrefix(p, `"`):
+			if unquoted, err := strconv.Unquote(p); err == nil {
+				parts[i] = unquoted
+			}
+		case strings.HasPrefix(p, "'"):
+			parts[i] = strings.Trim(p, "'")
+		}
+	}
+	return parts
+}
+
+func opensMultiline(value string) bool {
+	for _, delim := range []string{`"""`, `'''`} {
+		if strings.Count(value, delim)%2 == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func closesMultiline(line string) bool {
+	return strings.Contains(line, `"""`) || strings.Contains(line, `'''`)
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v3"
+	"io"
+	"slices"
+)
+
+type yamlFormat struct{}
+
+func (yamlFormat) Decode(src []byte) (TPLAgent, Locator, error) {
+	var c TPLAgent
+	var root yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(src)).Decode(&root); err != nil {
+		return c, nil, err
+	}
+	if err := root.Decode(&c); err != nil {
+		return c, nil, err
+	}
+	lines := keyLines{}
+	yamlKeyLines(&root, nil, lines)
+	return c, lines.locate, nil
+}
+
+func (yamlFormat) Encode(wr io.Writer, c TPLAgent, indent int) error {
+	ye := yaml.NewEncoder(wr)
+	ye.SetIndent(indent)
+	if err := ye.Encode(c); err != nil {
+		return err
+	}
+	return ye.Close()
+}
+
+func yamlKeyLines(n *yaml.Node, path []string, lines keyLines) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range n.Content {
+			yamlKeyLines(child, path, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			keyPath := append(slices.Clone(path), key.Value)
+			lines.add(keyPath, key.Line)
+			yamlKeyLines(n.Content[i+1], keyPath, lines)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// FragmentFiles returns the fragments in the include_dirs of c
+// in name order, relative dirs are resolved against the
+// directory of the config at path, hidden files and
+// files of unknown formats are skipped
+func FragmentFiles(c TPLAgent, path string) ([]string, error) {
+	var files []string
+	for _, dir := range c.Agent.IncludeDirs {
+		dir = os.ExpandEnv(dir)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(path), dir)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("read include dir:%w", err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			if _, err := lookupFormat(FormatFromPath(name)); err != nil {
+				continue
+			}
+			file := filepath.Join(dir, name)
+			if sameFile(file, path) {
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
+
+// mergeFragments adds the templates of the fragments of c, the
+// returned positioner locates keys of fragment templates
+// in their file and every other key with position
+func mergeFragments(c *TPLAgent, path string, position positioner) (map[string]string, positioner, error) {
+	files, err := FragmentFiles(*c, path)
+	if err != nil || len(files) == 0 {
+		return nil, position, err
+	}
+	if c.TemplateSpecs == nil {
+		c.TemplateSpecs = make(map[string]*TemplateSpec)
+	}
+
+	owners := map[string]string{}
+	locators := map[string]Locator{}
+	var dupErrs []error
+	for _, file := range files {
+		frag, locate, err := decodeFile(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fragment %s:%w", file, err)
+		}
+		if !reflect.ValueOf(frag.Agent).IsZero() {
+			return nil, nil, fmt.Errorf("fragment %s:only templates can be defined in fragments", file)
+		}
+		locators[file] = locate
+
+		names := make([]string, 0, len(frag.TemplateSpecs))
+		for name := range frag.TemplateSpecs {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if _, dup := c.TemplateSpecs[name]; dup {
+				definedIn := cmp.Or(owners[name], path)
+				dupErrs = append(dupErrs, fmt.Errorf("validate:template %s is defined in %s and %s", name, definedIn, file))
+				continue
+			}
+			c.TemplateSpecs[name] = frag.TemplateSpecs[name]
+			owners[name] = file
+		}
+	}
+	if len(dupErrs) > 0 {
+		return nil, nil, errors.Join(dupErrs...)
+	}
+
+	merged := func(keyPath ...string) (string, bool) {
+		if len(keyPath) < 2 || keyPath[0] != "templates" {
+			return position(keyPath...)
+		}
+		file, ok := owners[keyPath[1]]
+		if !ok {
+			return position(keyPath...)
+		}
+		if pos, ok := linePosition(locators[file])(keyPath...); ok {
+			return file + " " + pos, true
+		}
+		return file, true
+	}
+	return owners, merged, nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shubhang93/tplagent/internal/fatal"
+)
+
+const fragmentsMain = `{
+  "agent": {
+    "log_fmt": "text",
+    "include_dirs": ["conf.d"]
+  },
+  "templates": {
+    "app-conf": {
+      "raw": "app",
+      "destination": "/tmp/app.conf"
+    }
+  }
+}`
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadWithFragments(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.json":          fragmentsMain,
+		"conf.d/db.yaml":       "templates:\n  db-conf:\n    raw: db\n    destination: /tmp/db.conf\n",
+		"conf.d/cache.json":    `{"templates":{"cache-conf":{"raw":"cache","destination":"/tmp/cache.conf"}}}`,
+		"conf.d/.db.yaml.swp":  "not a config",
+		"conf.d/README":        "not a config",
+		"conf.d/nested/x.json": `{"templates":{"nested":{"raw":"x","destination":"/tmp/x"}}}`,
+	})
+
+	c, owners, err := ReadWithFragments(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range c.TemplateSpecs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if diff := cmp.Diff([]string{"app-conf", "cache-conf", "db-conf"}, names); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+	expectedOwners := map[string]string{
+		"cache-conf": filepath.Join(dir, "conf.d", "cache.json"),
+		"db-conf":    filepath.Join(dir, "conf.d", "db.yaml"),
+	}
+	if diff := cmp.Diff(expectedOwners, owners); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+
+	fromFile, err := ReadFromFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fromFile.TemplateSpecs) != 3 {
+		t.Errorf("expected ReadFromFile to merge fragments got %d templates", len(fromFile.TemplateSpecs))
+	}
+}
+
+func TestReadWithFragments_errors(t *testing.T) {
+	tests := map[string]struct {
+		files    map[string]string
+		expected []string
+	}{
+		"duplicate templates": {
+			files: map[string]string{
+				"conf.d/a.json": `{"templates":{"app-conf":{"raw":"a","destination":"/tmp/a"},"db-conf":{"raw":"a","destination":"/tmp/a"}}}`,
+				"conf.d/b.yaml": "templates:\n  db-conf:\n    raw: b\n    destination: /tmp/b\n",
+			},
+			expected: []string{
+				"template app-conf is defined in {dir}/config.json and {dir}/conf.d/a.json",
+				"template db-conf is defined in {dir}/conf.d/a.json and {dir}/conf.d/b.yaml",
+			},
+		},
+		"agent in fragment": {
+			files: map[string]string{
+				"conf.d/a.json": `{"agent":{"log_level":"DEBUG"}}`,
+			},
+			expected: []string{"fragment {dir}/conf.d/a.json:only templates can be defined in fragments"},
+		},
+		"fragment validation": {
+			files: map[string]string{
+				"conf.d/a.yaml": "templates:\n  db-conf:\n    raw: db\n\n  cache-conf:\n    raw: cache\n    destination: /tmp/cache\n    refresh_interval: 10ms\n",
+			},
+			expected: []string{"{dir}/conf.d/a.yaml line 5: validate:refresh interval"},
+		},
+		"fragment decode": {
+			files: map[string]string{
+				"conf.d/a.json": `{"templates":`,
+			},
+			expected: []string{"fragment {dir}/conf.d/a.json:"},
+		},
+		"missing dir": {
+			files:    map[string]string{},
+			expected: []string{"read include dir:"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			tc.files["config.json"] = fragmentsMain
+			writeFiles(t, dir, tc.files)
+
+			_, _, err := ReadWithFragments(filepath.Join(dir, "config.json"))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !fatal.Is(err) {
+				t.Errorf("expected a fatal error got %v", err)
+			}
+			for _, expected := range tc.expected {
+				expected = strings.ReplaceAll(expected, "{dir}", dir)
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("expected %q in:\n%s", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFragmentFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml":     "agent:\n  include_dirs: [., $FRAG_DIR]\n",
+		"b.toml":          "",
+		"a.yml":           "",
+		"other/c.hcl":     "",
+		"other/notes.txt": "",
+	})
+	t.Setenv("FRAG_DIR", filepath.Join(dir, "other"))
+
+	c, _, err := decodeFile(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := FragmentFiles(c, filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "a.yml"),
+		filepath.Join(dir, "b.toml"),
+		filepath.Join(dir, "other", "c.hcl"),
+	}
+	if diff := cmp.Diff(expected, files); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
@@ -1,24 +1,15 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/shubhang93/tplagent/internal/duration"
 	"io"
 	"log/slog"
-	"strings"
 	"time"
 )
 
-func WriteTo(wr io.Writer, numBlocks int, indent int) error {
-	starter := generate(numBlocks)
-
-	jd := json.NewEncoder(wr)
-	jd.SetIndent("", strings.Repeat(" ", indent))
-	if err := jd.Encode(starter); err != nil {
-		return err
-	}
-	return nil
+func WriteTo(wr io.Writer, numBlocks int, indent int, format string) error {
+	return encode(wr, generate(numBlocks), format, indent)
 }
 
 func generate(numBlocks int) TPLAgent {
@@ -0,0 +1,403 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fullConfigJSON sets every config field,
+// Test_fullConfigCoversEveryField fails
+// when a new field is missing here
+const fullConfigJSON = `{
+  "agent": {
+    "log_level": "WARN",
+    "log_fmt": "json",
+    "max_consecutive_failures": 5,
+    "http_listener_addr": "localhost:6000",
+    "includes": ["/etc/tplagent/partials/*.tmpl"],
+    "supervise": {
+      "env_file": "/run/app.env",
+      "templates": ["app-conf"],
+      "on_change": "restart",
+      "kill_signal": "SIGTERM",
+      "kill_timeout": "10s"
+    },
+    "exec_groups": {
+      "nginx": {
+        "cmd": "nginx",
+        "cmd_args": ["-s", "reload"],
+        "cmd_timeout": "5s",
+        "env": {"PATH": "/usr/sbin"},
+        "shell": true,
+        "dir": "/etc/nginx",
+        "inherit_env": true,
+        "output_limit": 1024,
+        "user": "nginx",
+        "group": "nginx",
+        "retries": 2,
+        "retry_backoff": "1s",
+        "expand_templates": true,
+        "debounce": "500ms"
+      }
+    },
+    "listener": {
+      "tls_cert_file": "/etc/tplagent/cert.pem",
+      "tls_key_file": "/etc/tplagent/key.pem",
+      "client_ca_file": "/etc/tplagent/ca.pem",
+      "client_scopes": {"deployer": "admin"},
+      "tokens": [{"file": "/etc/tplagent/token", "scope": "read"}],
+      "socket_mode": "0660",
+      "socket_group": "tplagent",
+      "allowed_uids": [1000],
+      "allowed_gids": [1000]
+    },
+    "hooks": {
+      "git": {
+        "secret_file": "/etc/tplagent/git.secret",
+        "signature_header": "X-Hub-Signature-256",
+        "timestamp_header": "X-Timestamp",
+        "max_skew": "5m",
+        "templates": ["app-conf"],
+        "selector": {"team": "payments"}
+      }
+    },
+    "include_dirs": ["/etc/tplagent/conf.d"],
+    "state_dir": "/var/lib/tplagent"
+  },
+  "templates": {
+    "app-conf": {
+      "actions": [
+        {
+          "name": "httpjson",
+          "config": {"base_url": "http://localhost", "timeout": "10s", "retries": 3, "headers": {"Accept": "application/json"}}
+        }
+      ],
+      "template_delimiters": ["<<", ">>"],
+      "source": "/etc/tplagent/app.tmpl",
+      "raw": "hello <<.name>>",
+      "destination": "${APP_ROOT}/app.conf",
+      "html": true,
+      "static_data": {"name": "app", "max_connections": 100, "hosts": ["a", "b"]},
+      "refresh_interval": "15s",
+      "refresh_on_trigger": true,
+      "render_once": true,
+      "missing_key": "error",
+      "depends_on": ["base-conf"],
+      "multi_output": true,
+      "source_dir": "/etc/tplagent/app.d",
+      "destination_dir": "/etc/app/conf.d",
+      "includes": ["/etc/tplagent/app/*.tmpl"],
+      "mode": "managed_block",
+      "block_markers": ["# BEGIN", "# END"],
+      "output_format": "yaml",
+      "canonicalize": "json",
+      "guard": {
+        "min_size": 10,
+        "max_shrink_percent": 50.5,
+        "contains": ["server"],
+        "matches": ["^listen"]
+      },
+      "escape": "shell",
+      "exec": {
+        "cmd": "systemctl",
+        "cmd_args": ["reload", "app"],
+        "cmd_timeout": "30s",
+        "env": {"DATA_DIR": "${STATE_DIR}/data"},
+        "shell": true,
+        "dir": "/var/lib/app",
+        "inherit_env": true,
+        "output_limit": 2048,
+        "user": "app",
+        "group": "app",
+        "retries": 3,
+        "retry_backoff": "2s",
+        "expand_templates": true
+      },
+      "notify": {
+        "pid_file": "/run/app.pid",
+        "process_name": "app",
+        "signal": "SIGHUP"
+      },
+      "webhook": {
+        "method": "POST",
+        "url": "http://localhost:8080/reload",
+        "headers": {"Authorization": "Bearer token"},
+        "body": "{}",
+        "timeout": "3s",
+        "expected_status": [200, 204]
+      },
+      "exec_group": "nginx",
+      "labels": {"team": "payments"},
+      "required": true
+    }
+  }
+}
+`
+
+const fullConfigYAML = `agent:
+  log_level: WARN
+  log_fmt: json
+  max_consecutive_failures: 5
+  http_listener_addr: localhost:6000
+  includes:
+    - /etc/tplagent/partials/*.tmpl
+  supervise:
+    env_file: /run/app.env
+    templates: [app-conf]
+    on_change: restart
+    kill_signal: SIGTERM
+    kill_timeout: 10s
+  exec_groups:
+    nginx:
+      cmd: nginx
+      cmd_args: ["-s", "reload"]
+      cmd_timeout: 5s
+      env:
+        PATH: /usr/sbin
+      shell: true
+      dir: /etc/nginx
+      inherit_env: true
+      output_limit: 1024
+      user: nginx
+      group: nginx
+      retries: 2
+      retry_backoff: 1s
+      expand_templates: true
+      debounce: 500ms
+  listener:
+    tls_cert_file: /etc/tplagent/cert.pem
+    tls_key_file: /etc/tplagent/key.pem
+    client_ca_file: /etc/tplagent/ca.pem
+    client_scopes:
+      deployer: admin
+    tokens:
+      - file: /etc/tplagent/token
+        scope: read
+    socket_mode: "0660"
+    socket_group: tplagent
+    allowed_uids: [1000]
+    allowed_gids: [1000]
+  hooks:
+    git:
+      secret_file: /etc/tplagent/git.secret
+      signature_header: X-Hub-Signature-256
+      timestamp_header: X-Timestamp
+      max_skew: 5m
+      templates: [app-conf]
+      selector:
+        team: payments
+  include_dirs: [/etc/tplagent/conf.d]
+  state_dir: /var/lib/tplagent
+templates:
+  app-conf:
+    actions:
+      - name: httpjson
+        config:
+          base_url: http://localhost
+          timeout: 10s
+          retries: 3
+          headers:
+            Accept: application/json
+    template_delimiters: ["<<", ">>"]
+    source: /etc/tplagent/app.tmpl
+    raw: hello <<.name>>
+    destination: ${APP_ROOT}/app.conf
+    html: true
+    static_data:
+      name: app
+      max_connections: 100
+      hosts: [a, b]
+    refresh_interval: 15s
+    refresh_on_trigger: true
+    render_once: true
+    missing_key: error
+    depends_on: [base-conf]
+    multi_output: true
+    source_dir: /etc/tplagent/app.d
+    destination_dir: /etc/app/conf.d
+    includes:
+      - /etc/tplagent/app/*.tmpl
+    mode: managed_block
+    block_markers: ["# BEGIN", "# END"]
+    output_format: yaml
+    canonicalize: json
+    guard:
+      min_size: 10
+      max_shrink_percent: 50.5
+      contains: [server]
+      matches: ["^listen"]
+    escape: shell
+    exec:
+      cmd: systemctl
+      cmd_args: [reload, app]
+      cmd_timeout: 30s
+      env:
+        DATA_DIR: ${STATE_DIR}/data
+      shell: true
+      dir: /var/lib/app
+      inherit_env: true
+      output_limit: 2048
+      user: app
+      group: app
+      retries: 3
+      retry_backoff: 2s
+      expand_templates: true
+    notify:
+      pid_file: /run/app.pid
+      process_name: app
+      signal: SIGHUP
+    webhook:
+      method: POST
+      url: http://localhost:8080/reload
+      headers:
+        Authorization: Bearer token
+      body: "{}"
+      timeout: 3s
+      expected_status: [200, 204]
+    exec_group: nginx
+    labels:
+      team: payments
+    required: true
+`
+
+// normalize compares configs decoded from different
+// formats through their JSON encoding since raw
+// messages and static data keep format specific values
+func normalize(t *testing.T, c TPLAgent) any {
+	t.Helper()
+	bs, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("marshal error:%v", err)
+	}
+	var v any
+	if err := json.Unmarshal(bs, &v); err != nil {
+		t.Fatalf("unmarshal error:%v", err)
+	}
+	return v
+}
+
+func mustDecode(t *testing.T, src string, format string) TPLAgent {
+	t.Helper()
+	c, _, err := decode(strings.NewReader(src), format)
+	if err != nil {
+		t.Fatalf("%s decode error:%v", format, err)
+	}
+	return c
+}
+
+// zeroFields returns the paths of the exported fields
+// left unset, elements of maps and slices are walked
+func zeroFields(v reflect.Value, path string) []string {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return []string{path}
+		}
+		return zeroFields(v.Elem(), path)
+	case reflect.Map:
+		if v.Len() == 0 {
+			return []string{path}
+		}
+		var zero []string
+		iter := v.MapRange()
+		for iter.Next() {
+			zero = append(zero, zeroFields(iter.Value(), path+"["+iter.Key().String()+"]")...)
+		}
+		return zero
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return []string{path}
+		}
+		var zero []string
+		for i := 0; i < v.Len(); i++ {
+			zero = append(zero, zeroFields(v.Index(i), path+"[]")...)
+		}
+		return zero
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(RawMessage{}) {
+			if v.IsZero() {
+				return []string{path}
+			}
+			return nil
+		}
+		var zero []string
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			zero = append(zero, zeroFields(v.Field(i), path+"."+field.Name)...)
+		}
+		return zero
+	default:
+		if v.IsZero() {
+			return []string{path}
+		}
+		return nil
+	}
+}
+
+func Test_fullConfigCoversEveryField(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		src := fullConfigJSON
+		if format == "yaml" {
+			src = fullConfigYAML
+		}
+		c := mustDecode(t, src, format)
+		if zero := zeroFields(reflect.ValueOf(c), "TPLAgent"); len(zero) > 0 {
+			t.Errorf("%s fixture leaves fields unset:\n%s", format, strings.Join(zero, "\n"))
+		}
+	}
+}
+
+func Test_yamlParity(t *testing.T) {
+	fromJSON := mustDecode(t, fullConfigJSON, "json")
+	fromYAML := mustDecode(t, fullConfigYAML, "yaml")
+
+	if diff := cmp.Diff(normalize(t, fromJSON), normalize(t, fromYAML)); diff != "" {
+		t.Errorf("(--JSON ++YAML):\n%s", diff)
+	}
+
+	type httpJSONConfig struct {
+		BaseURL string            `json:"base_url" yaml:"base_url"`
+		Timeout string            `json:"timeout" yaml:"timeout"`
+		Retries int               `json:"retries" yaml:"retries"`
+		Headers map[string]string `json:"headers" yaml:"headers"`
+	}
+	var jsonAction, yamlAction httpJSONConfig
+	if err := fromJSON.TemplateSpecs["app-conf"].Actions[0].Config.Decode(&jsonAction); err != nil {
+		t.Fatal(err)
+	}
+	if err := fromYAML.TemplateSpecs["app-conf"].Actions[0].Config.Decode(&yamlAction); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(jsonAction, yamlAction); diff != "" {
+		t.Errorf("(--JSON ++YAML):\n%s", diff)
+	}
+}
+
+func Test_roundTrip(t *testing.T) {
+	sources := map[string]string{"json": fullConfigJSON, "yaml": fullConfigYAML}
+	for from, src := range sources {
+		for _, to := range Formats() {
+			t.Run(from+" to "+to, func(t *testing.T) {
+				original := mustDecode(t, src, from)
+
+				var buff bytes.Buffer
+				if err := Encode(&buff, original, to); err != nil {
+					t.Fatalf("encode error:%v", err)
+				}
+				decoded := mustDecode(t, buff.String(), to)
+
+				if diff := cmp.Diff(normalize(t, original), normalize(t, decoded)); diff != "" {
+					t.Errorf("(--Want ++Got):\n%s", diff)
+				}
+			})
+		}
+	}
+}
@@ -10,12 +10,31 @@ type RawMessage struct {
 	jsonMessage json.RawMessage
 }
 
-func (r *RawMessage) MarshalYAML() (interface{}, error) {
-	return r.yamlNode, nil
+func (r RawMessage) MarshalYAML() (interface{}, error) {
+	if r.yamlNode != nil || r.jsonMessage == nil {
+		return r.yamlNode, nil
+	}
+	// read from a json config
+	var v any
+	if err := json.Unmarshal(r.jsonMessage, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
 }
 
-func (r *RawMessage) MarshalJSON() ([]byte, error) {
-	return r.jsonMessage, nil
+func (r RawMessage) MarshalJSON() ([]byte, error) {
+	if r.yamlNode == nil {
+		if r.jsonMessage == nil {
+			return []byte("null"), nil
+		}
+		return r.jsonMessage, nil
+	}
+	// read from a yaml config
+	var v any
+	if err := r.yamlNode.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
 }
 
 func (r *RawMessage) UnmarshalYAML(value *yaml.Node) error {
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FormatFromPath returns the config format
+// registered for the file extension
+func FormatFromPath(path string) string {
+	ext := filepath.Ext(path)
+	if len(ext) > 0 {
+		ext = ext[1:]
+	}
+	if name, ok := extensions[ext]; ok {
+		return name
+	}
+	return ext
+}
+
+// Encode writes c to wr in the given format
+func Encode(wr io.Writer, c TPLAgent, format string) error {
+	return encode(wr, c, format, 2)
+}
+
+func encode(wr io.Writer, c TPLAgent, format string, indent int) error {
+	f, err := lookupFormat(format)
+	if err != nil {
+		return err
+	}
+	return f.Encode(wr, c, indent)
+}
+
+// WriteFile atomically replaces the config at path,
+// the format is picked from the file extension
+func WriteFile(path string, c TPLAgent) error {
+	expandedPath := os.ExpandEnv(path)
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(expandedPath); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(expandedPath), "."+filepath.Base(expandedPath)+".*")
+	if err != nil {
+		return fmt.Errorf("write config:%w", err)
+	}
+	defer func() {
+		// no-op once renamed
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err := Encode(tmp, c, FormatFromPath(expandedPath)); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write config:%w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write config:%w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write config:%w", err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("write config:%w", err)
+	}
+	if err := os.Rename(tmp.Name(), expandedPath); err != nil {
+		return fmt.Errorf("write config:%w", err)
+	}
+	return nil
+}
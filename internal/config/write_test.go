@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shubhang93/tplagent/internal/duration"
+)
+
+func TestWriteFile(t *testing.T) {
+	conf := TPLAgent{
+		Agent: Agent{LogFmt: "text"},
+		TemplateSpecs: map[string]*TemplateSpec{
+			"app-conf": {
+				Raw:             "hello {{.name}}",
+				Destination:     "/tmp/app.conf",
+				RefreshInterval: duration.Duration(5 * time.Second),
+				Actions: []Actions{{
+					Name:   "httpjson",
+					Config: NewJSONRawMessage([]byte(`{"base_url":"http://localhost"}`)),
+				}},
+			},
+		},
+	}
+
+	for _, format := range []string{"json", "yaml", "yml"} {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config."+format)
+			if err := os.WriteFile(path, nil, 0640); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteFile(path, conf); err != nil {
+				t.Fatalf("WriteFile error:%v", err)
+			}
+
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isJSON := strings.HasPrefix(string(contents), "{"); isJSON != (format == "json") {
+				t.Errorf("expected %s contents got:\n%s", format, contents)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0640 {
+				t.Errorf("expected mode 0640 got %o", fi.Mode().Perm())
+			}
+
+			got, err := ReadFromFile(path)
+			if err != nil {
+				t.Fatalf("ReadFromFile error:%v", err)
+			}
+			spec := got.TemplateSpecs["app-conf"]
+			if spec == nil || spec.RefreshInterval != duration.Duration(5*time.Second) {
+				t.Fatalf("unexpected template spec %+v", spec)
+			}
+			var actionConf map[string]string
+			if err := spec.Actions[0].Config.Decode(&actionConf); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(map[string]string{"base_url": "http://localhost"}, actionConf); diff != "" {
+				t.Errorf("(--Want ++Got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -3,15 +3,16 @@ package duration
 import (
 	"bytes"
 	"fmt"
+	"gopkg.in/yaml.v3"
 	"time"
 )
 
 type Duration time.Duration
 
-func (r *Duration) MarshalJSON() ([]byte, error) {
+func (r Duration) MarshalJSON() ([]byte, error) {
 	bs := []byte{'"'}
 	bs = append(bs)
-	bs = append(bs, time.Duration(*r).String()...)
+	bs = append(bs, time.Duration(r).String()...)
 	bs = append(bs, '"')
 	return bs, nil
 }
@@ -25,3 +26,19 @@ func (r *Duration) UnmarshalJSON(bs []byte) error {
 	*r = Duration(dur)
 	return nil
 }
+
+func (r Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(r).String(), nil
+}
+
+func (r *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("invalid duration: expected a string at line %d", value.Line)
+	}
+	dur, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("invalid duration string:%w", err)
+	}
+	*r = Duration(dur)
+	return nil
+}
@@ -0,0 +1,287 @@
+// Package escape rewrites parsed text templates so that
+// every value written by an action is escaped for the
+// output format, in the same way html/template escapes
+// values for HTML. The context of each action is
+// tracked through the text preceding it, values inside
+// quoted strings are escaped as string contents and
+// values outside strings are encoded as whole values.
+package escape
+
+import (
+	"fmt"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	JSON  = "json"
+	YAML  = "yaml"
+	Shell = "shell"
+)
+
+var modes = map[string]struct{}{
+	JSON:  {},
+	YAML:  {},
+	Shell: {},
+}
+
+func ValidMode(mode string) bool {
+	_, ok := modes[mode]
+	return ok
+}
+
+type state int
+
+const (
+	stateText state = iota
+	stateDoubleQuoted
+	stateSingleQuoted
+	stateComment
+)
+
+func (s state) String() string {
+	switch s {
+	case stateDoubleQuoted:
+		return "double quoted string"
+	case stateSingleQuoted:
+		return "single quoted string"
+	case stateComment:
+		return "comment"
+	default:
+		return "text"
+	}
+}
+
+type context struct {
+	state state
+	// escaped is set after a backslash
+	// inside a double quoted string
+	escaped bool
+	// prev is the last byte written and prevSig
+	// the last byte which is not a space or a tab
+	prev    byte
+	prevSig byte
+}
+
+func (c context) eq(o context) bool {
+	return c.state == o.state && c.escaped == o.escaped
+}
+
+type edit struct {
+	pipe    *parse.PipeNode
+	escaper string
+}
+
+type escaper struct {
+	mode  string
+	skip  map[string]struct{}
+	edits []edit
+}
+
+// Rewrite appends an escaper to the pipeline of every
+// action in the templates associated with t, Funcs must
+// be registered with t before it is executed. Actions
+// ending with one of the skip functions are not
+// escaped and reset the context to text
+func Rewrite(t *template.Template, mode string, skip ...string) error {
+	if !ValidMode(mode) {
+		return fmt.Errorf("unknown escape mode %s", mode)
+	}
+
+	e := escaper{mode: mode, skip: make(map[string]struct{}, len(skip))}
+	for _, name := range skip {
+		e.skip[name] = struct{}{}
+	}
+
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil || tmpl.Tree.Root == nil {
+			continue
+		}
+		if _, err := e.walk(context{}, tmpl.Tree.Root); err != nil {
+			return fmt.Errorf("escape %s:%s:%w", mode, tmpl.Name(), err)
+		}
+	}
+
+	for _, ed := range e.edits {
+		ed.pipe.Cmds = append(ed.pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      ed.pipe.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(ed.escaper).SetPos(ed.pipe.Pos)},
+		})
+	}
+	return nil
+}
+
+func (e *escaper) walk(c context, n parse.Node) (context, error) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return c, nil
+		}
+		var err error
+		for _, child := range n.Nodes {
+			c, err = e.walk(c, child)
+			if err != nil {
+				return c, err
+			}
+		}
+		return c, nil
+	case *parse.TextNode:
+		return e.text(c, n.Text), nil
+	case *parse.ActionNode:
+		return e.action(c, n), nil
+	case *parse.IfNode:
+		return e.branch(c, &n.BranchNode, false)
+	case *parse.WithNode:
+		return e.branch(c, &n.BranchNode, false)
+	case *parse.RangeNode:
+		return e.branch(c, &n.BranchNode, true)
+	case *parse.TemplateNode:
+		if c.state != stateText {
+			return c, fmt.Errorf("template %q invoked inside a %s", n.Name, c.state)
+		}
+		return c, nil
+	default:
+		// comments, break and continue
+		// do not write any output
+		return c, nil
+	}
+}
+
+func (e *escaper) branch(c context, n *parse.BranchNode, loop bool) (context, error) {
+	after, err := e.walk(c, n.List)
+	if err != nil {
+		return c, err
+	}
+	if loop && !after.eq(c) {
+		return c, fmt.Errorf("range body at %d ends in a %s", n.Position(), after.state)
+	}
+
+	afterElse, err := e.walk(c, n.ElseList)
+	if err != nil {
+		return c, err
+	}
+	if !after.eq(afterElse) {
+		return c, fmt.Errorf("branches at %d end in a %s and a %s", n.Position(), after.state, afterElse.state)
+	}
+	return after, nil
+}
+
+func (e *escaper) action(c context, n *parse.ActionNode) context {
+	if len(n.Pipe.Decl) > 0 {
+		// variable declarations
+		// do not write any output
+		return c
+	}
+
+	if name, ok := lastIdentifier(n.Pipe); ok {
+		if _, skip := e.skip[name]; skip {
+			return context{}
+		}
+		if _, escaped := funcs[name]; escaped {
+			return c
+		}
+	}
+
+	e.edits = append(e.edits, edit{pipe: n.Pipe, escaper: escaperFor(e.mode, c.state)})
+	return c
+}
+
+func lastIdentifier(p *parse.PipeNode) (string, bool) {
+	if len(p.Cmds) < 1 {
+		return "", false
+	}
+	last := p.Cmds[len(p.Cmds)-1]
+	if len(last.Args) < 1 {
+		return "", false
+	}
+	ident, ok := last.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return "", false
+	}
+	return ident.Ident, true
+}
+
+func (e *escaper) text(c context, text []byte) context {
+	for _, b := range text {
+		c = e.transition(c, b)
+		c.prev = b
+		if b != ' ' && b != '\t' {
+			c.prevSig = b
+		}
+	}
+	return c
+}
+
+func (e *escaper) transition(c context, b byte) context {
+	switch c.state {
+	case stateDoubleQuoted:
+		switch {
+		case c.escaped:
+			c.escaped = false
+		case b == '\\':
+			c.escaped = true
+		case b == '"':
+			c.state = stateText
+		}
+	case stateSingleQuoted:
+		if b == '\'' {
+			c.state = stateText
+		}
+	case stateComment:
+		if b == '\n' {
+			c.state = stateText
+		}
+	default:
+		c.state = e.textTransition(c, b)
+	}
+	return c
+}
+
+func (e *escaper) textTransition(c context, b byte) state {
+	switch e.mode {
+	case JSON:
+		if b == '"' {
+			return stateDoubleQuoted
+		}
+	case Shell:
+		switch {
+		case b == '"' && c.prev != '\\':
+			return stateDoubleQuoted
+		case b == '\'' && c.prev != '\\':
+			return stateSingleQuoted
+		case b == '#' && startsWord(c.prev):
+			return stateComment
+		}
+	case YAML:
+		switch {
+		case b == '#' && startsWord(c.prev):
+			return stateComment
+		case (b == '"' || b == '\'') && startsYAMLScalar(c.prevSig):
+			if b == '"' {
+				return stateDoubleQuoted
+			}
+			return stateSingleQuoted
+		}
+	}
+	return stateText
+}
+
+func startsWord(prev byte) bool {
+	switch prev {
+	case 0, ' ', '\t', '\n', ';':
+		return true
+	}
+	return false
+}
+
+// startsYAMLScalar reports whether a quote following
+// prev starts a quoted scalar, quotes in the middle of
+// plain scalars like it's are part of the scalar
+func startsYAMLScalar(prevSig byte) bool {
+	switch prevSig {
+	case 0, '\n', ':', '-', '[', '{', ',', '?':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package escape
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func execute(t *testing.T, mode, text string, data any, skip ...string) (string, error) {
+	t.Helper()
+	tt, err := template.New("test").
+		Funcs(Funcs()).
+		Funcs(template.FuncMap{"raw": func(s string) string { return s }}).
+		Parse(text)
+	if err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+	if err := Rewrite(tt, mode, skip...); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tt.Execute(&buf, data); err != nil {
+		t.Fatalf("execute error:%v", err)
+	}
+	return buf.String(), nil
+}
+
+func TestRewrite(t *testing.T) {
+	data := map[string]any{
+		"quote": `say "hi"\n`,
+		"num":   42,
+		"multi": "a\nb",
+		"shell": "it's $HOME `x`",
+		"list":  []string{"a", "b"},
+	}
+
+	cases := map[string]struct {
+		mode string
+		text string
+		skip []string
+		want string
+	}{
+		"json value": {
+			mode: JSON,
+			text: `{"n":{{.num}},"q":{{.quote}}}`,
+			want: `{"n":42,"q":"say \"hi\"\\n"}`,
+		},
+		"json inside string": {
+			mode: JSON,
+			text: `{"q":"<{{.quote}}>"}`,
+			want: `{"q":"<say \"hi\"\\n>"}`,
+		},
+		"json range": {
+			mode: JSON,
+			text: `[{{range $i, $v := .list}}{{if $i}},{{end}}"{{$v}}"{{end}}]`,
+			want: `["a","b"]`,
+		},
+		"yaml plain": {
+			mode: YAML,
+			text: "key: {{.multi}}\n",
+			want: "key: \"a\\nb\"\n",
+		},
+		"yaml double quoted": {
+			mode: YAML,
+			text: "key: \"{{.quote}}\"\n",
+			want: "key: \"say \\\"hi\\\"\\\\n\"\n",
+		},
+		"yaml single quoted": {
+			mode: YAML,
+			text: "key: '{{.shell}}'\n",
+			want: "key: 'it''s $HOME `x`'\n",
+		},
+		"yaml apostrophe in plain text": {
+			mode: YAML,
+			text: "key: don't {{.num}}\n",
+			want: "key: don't 42\n",
+		},
+		"yaml comment": {
+			mode: YAML,
+			text: "# {{.multi}}\nkey: 1\n",
+			want: "# a b\nkey: 1\n",
+		},
+		"shell value": {
+			mode: Shell,
+			text: "export V={{.shell}}\n",
+			want: "export V='it'\\''s $HOME `x`'\n",
+		},
+		"shell double quoted": {
+			mode: Shell,
+			text: `echo "{{.shell}}"`,
+			want: "echo \"it's \\$HOME \\`x\\`\"",
+		},
+		"shell single quoted": {
+			mode: Shell,
+			text: `echo '{{.shell}}'`,
+			want: `echo 'it'\''s $HOME ` + "`x`'",
+		},
+		"skip funcs": {
+			mode: JSON,
+			text: `{{raw "{"}}"n":{{.num}}}`,
+			skip: []string{"raw"},
+			want: `{"n":42}`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := execute(t, tc.mode, tc.text, data, tc.skip...)
+			if err != nil {
+				t.Fatalf("rewrite error:%v", err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+	cases := map[string]struct {
+		mode string
+		text string
+		want string
+	}{
+		"branches end in different contexts": {
+			mode: JSON,
+			text: `{{if .}}"{{end}}`,
+			want: "branches",
+		},
+		"template call inside string": {
+			mode: JSON,
+			text: `{{define "x"}}x{{end}}"{{template "x"}}"`,
+			want: "template",
+		},
+		"invalid mode": {
+			mode: "xml",
+			text: `{{.}}`,
+			want: "xml",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := execute(t, tc.mode, tc.text, nil)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected error containing %q got %v", tc.want, err)
+			}
+		})
+	}
+}
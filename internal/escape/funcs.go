@@ -0,0 +1,149 @@
+package escape
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+const (
+	escapeValue       = "tpla_escape_value"
+	escapeJSONString  = "tpla_escape_json_string"
+	escapeSingleQuote = "tpla_escape_yaml_single_quoted"
+	escapeShellValue  = "tpla_escape_shell_value"
+	escapeShellDouble = "tpla_escape_shell_double_quoted"
+	escapeShellSingle = "tpla_escape_shell_single_quoted"
+	escapeComment     = "tpla_escape_comment"
+)
+
+var funcs = template.FuncMap{
+	escapeValue:       jsonValue,
+	escapeJSONString:  jsonString,
+	escapeSingleQuote: yamlSingleQuoted,
+	escapeShellValue:  shellValue,
+	escapeShellDouble: shellDoubleQuoted,
+	escapeShellSingle: shellSingleQuoted,
+	escapeComment:     comment,
+}
+
+// Funcs returns the escapers appended by
+// Rewrite, they must be added to the template
+// using template.Funcs before it is executed
+func Funcs() template.FuncMap {
+	fm := make(template.FuncMap, len(funcs))
+	for name, f := range funcs {
+		fm[name] = f
+	}
+	return fm
+}
+
+func escaperFor(mode string, s state) string {
+	if s == stateComment {
+		return escapeComment
+	}
+
+	switch mode {
+	case Shell:
+		switch s {
+		case stateDoubleQuoted:
+			return escapeShellDouble
+		case stateSingleQuoted:
+			return escapeShellSingle
+		}
+		return escapeShellValue
+	case YAML:
+		switch s {
+		case stateDoubleQuoted:
+			return escapeJSONString
+		case stateSingleQuoted:
+			return escapeSingleQuote
+		}
+		// JSON values are valid
+		// YAML flow values
+		return escapeValue
+	default:
+		if s == stateDoubleQuoted {
+			return escapeJSONString
+		}
+		return escapeValue
+	}
+}
+
+func stringify(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func marshalJSON(v any) (string, error) {
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buff.String(), "\n"), nil
+}
+
+// jsonValue encodes v as a complete value,
+// strings are quoted while numbers, booleans
+// maps and slices keep their JSON types
+func jsonValue(v any) (string, error) {
+	switch v.(type) {
+	case []byte, fmt.Stringer:
+		v = stringify(v)
+	}
+	return marshalJSON(v)
+}
+
+// jsonString escapes v as the contents of a
+// double quoted string, the escapes are valid
+// in JSON and in YAML double quoted scalars
+func jsonString(v any) (string, error) {
+	quoted, err := marshalJSON(stringify(v))
+	if err != nil {
+		return "", err
+	}
+	return quoted[1 : len(quoted)-1], nil
+}
+
+func yamlSingleQuoted(v any) string {
+	s := strings.ReplaceAll(stringify(v), "'", "''")
+	return strings.ReplaceAll(s, "\n", "\n\n")
+}
+
+func shellValue(v any) string {
+	return "'" + shellSingleQuoted(v) + "'"
+}
+
+var shellDoubleQuotedReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"$", `\$`,
+	"`", "\\`",
+)
+
+func shellDoubleQuoted(v any) string {
+	return shellDoubleQuotedReplacer.Replace(stringify(v))
+}
+
+// shellSingleQuoted closes the quote, writes an
+// escaped quote and reopens the quote for every
+// single quote in v since single quoted strings
+// do not support any escapes
+func shellSingleQuoted(v any) string {
+	return strings.ReplaceAll(stringify(v), "'", `'\''`)
+}
+
+// comment keeps values on the commented line
+func comment(v any) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(stringify(v))
+}
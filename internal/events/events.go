@@ -0,0 +1,135 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	TemplateStarted Type = "template_started"
+	TemplateStopped Type = "template_stopped"
+	RenderWritten   Type = "render_written"
+	RenderIdentical Type = "render_identical"
+	RenderError     Type = "render_error"
+	ExecResult      Type = "exec_result"
+	ReloadBegun     Type = "reload_begun"
+	ReloadCompleted Type = "reload_completed"
+	ReloadFailed    Type = "reload_failed"
+	ActionError     Type = "action_error"
+)
+
+var types = []Type{
+	TemplateStarted,
+	TemplateStopped,
+	RenderWritten,
+	RenderIdentical,
+	RenderError,
+	ExecResult,
+	ReloadBegun,
+	ReloadCompleted,
+	ReloadFailed,
+	ActionError,
+}
+
+// ValidType reports if t is a known event type
+func ValidType(t Type) bool {
+	return slices.Contains(types, t)
+}
+
+type Event struct {
+	Type     Type      `json:"type"`
+	Time     time.Time `json:"time"`
+	Template string    `json:"template,omitempty"`
+	Action   string    `json:"action,omitempty"`
+	// Error is empty for a successful exec_result
+	Error string `json:"error,omitempty"`
+}
+
+// Filter matches events of any of the Templates
+// and Types, an empty list matches everything
+type Filter struct {
+	Templates []string
+	Types     []Type
+}
+
+func (f Filter) Match(e Event) bool {
+	if len(f.Templates) > 0 && !slices.Contains(f.Templates, e.Template) {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	return true
+}
+
+const subscriptionBuffer = 64
+
+// Bus fans out published events to subscribers,
+// events are dropped for subscribers which do not
+// keep up, a nil Bus discards every event
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+}
+
+func (b *Bus) Subscribe(f Filter) *Subscription {
+	sub := &Subscription{
+		bus:    b,
+		filter: f,
+		ch:     make(chan Event, subscriptionBuffer),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+type Subscription struct {
+	bus    *Bus
+	filter Filter
+	ch     chan Event
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close stops the delivery of events
+// and closes the Events channel
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; !ok {
+		return
+	}
+	delete(s.bus.subs, s)
+	close(s.ch)
+}
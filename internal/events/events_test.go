@@ -0,0 +1,60 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe(Filter{})
+	filtered := bus.Subscribe(Filter{Templates: []string{"app"}, Types: []Type{RenderError}})
+
+	published := []Event{
+		{Type: RenderWritten, Template: "app"},
+		{Type: RenderError, Template: "db", Error: "boom"},
+		{Type: RenderError, Template: "app", Error: "boom"},
+	}
+	for _, e := range published {
+		bus.Publish(e)
+	}
+	all.Close()
+	filtered.Close()
+	// closing twice is a no-op
+	filtered.Close()
+
+	collect := func(sub *Subscription) []string {
+		var got []string
+		for e := range sub.Events() {
+			if e.Time.IsZero() {
+				t.Error("expected event time to be set")
+			}
+			got = append(got, string(e.Type)+":"+e.Template)
+		}
+		return got
+	}
+
+	if diff := cmp.Diff([]string{"render_written:app", "render_error:db", "render_error:app"}, collect(all)); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"render_error:app"}, collect(filtered)); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func TestBus_slowSubscriber(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(Filter{})
+	defer sub.Close()
+
+	for range subscriptionBuffer * 2 {
+		bus.Publish(Event{Type: RenderWritten})
+	}
+	if got := len(sub.Events()); got != subscriptionBuffer {
+		t.Errorf("expected %d buffered events got %d", subscriptionBuffer, got)
+	}
+
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: RenderWritten})
+}
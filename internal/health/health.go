@@ -0,0 +1,51 @@
+package health
+
+import "time"
+
+// Template is the health of the render loop of a template
+type Template struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	// Running is false before the render loop
+	// starts and after it stops
+	Running bool `json:"running"`
+	// Rendered is set once a render
+	// has succeeded
+	Rendered            bool      `json:"rendered"`
+	LastTick            time.Time `json:"last_tick"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	// Failed is set once the render loop stops
+	// after too many consecutive failures
+	Failed bool `json:"failed"`
+	// Wedged is set when no tick completed
+	// within a multiple of the refresh interval
+	Wedged bool `json:"wedged"`
+}
+
+type Report struct {
+	OK        bool       `json:"ok"`
+	Templates []Template `json:"templates"`
+}
+
+// Liveness fails if any render loop is wedged
+func Liveness(templates []Template) Report {
+	report := Report{OK: true, Templates: templates}
+	for _, t := range templates {
+		if t.Wedged {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// Readiness succeeds once every required template has
+// rendered and none of them has failed for good
+func Readiness(templates []Template) Report {
+	report := Report{OK: true, Templates: templates}
+	for _, t := range templates {
+		if t.Required && (!t.Rendered || t.Failed) {
+			report.OK = false
+		}
+	}
+	return report
+}
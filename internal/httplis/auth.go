@@ -0,0 +1,157 @@
+package httplis
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/shubhang93/tplagent/internal/config"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// scopes are ordered, a caller with
+// a scope can call every endpoint
+// requiring a lower scope
+const (
+	scopeNone = iota
+	scopeRead
+	scopeTrigger
+	scopeAdmin
+)
+
+var scopeRanks = map[string]int{
+	config.ScopeRead:    scopeRead,
+	config.ScopeTrigger: scopeTrigger,
+	config.ScopeAdmin:   scopeAdmin,
+}
+
+type token struct {
+	value []byte
+	scope int
+	name  string
+}
+
+// authorizer authenticates callers with bearer tokens
+// or verified client certificates, a nil authorizer
+// allows every request
+type authorizer struct {
+	tokens       []token
+	clientScopes map[string]int
+	logger       *slog.Logger
+}
+
+func newAuthorizer(spec *config.ListenerSpec, logger *slog.Logger) (*authorizer, error) {
+	if spec == nil || (len(spec.Tokens) < 1 && spec.ClientCAFile == "") {
+		return nil, nil
+	}
+
+	a := &authorizer{
+		clientScopes: make(map[string]int, len(spec.ClientScopes)),
+		logger:       logger,
+	}
+	for _, ts := range spec.Tokens {
+		contents, err := os.ReadFile(os.ExpandEnv(ts.File))
+		if err != nil {
+			return nil, fmt.Errorf("read token file:%w", err)
+		}
+		value := strings.TrimSpace(string(contents))
+		if value == "" {
+			return nil, fmt.Errorf("token file %s is empty", ts.File)
+		}
+		a.tokens = append(a.tokens, token{
+			value: []byte(value),
+			scope: scopeRanks[ts.Scope],
+			name:  ts.File,
+		})
+	}
+	for cn, scope := range spec.ClientScopes {
+		a.clientScopes[cn] = scopeRanks[scope]
+	}
+	return a, nil
+}
+
+// require wraps next so that it is only
+// called by callers having at least scope
+func (a *authorizer) require(scope int, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(writer http.ResponseWriter, request *http.Request) {
+		identity, granted, err := a.authenticate(request)
+		if err != nil {
+			auditReject(a.logger, request, identity, err.Error())
+			writeJSON(writer, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		if granted < scope {
+			auditReject(a.logger, request, identity, "insufficient scope")
+			writeJSON(writer, http.StatusForbidden, map[string]string{"error": "insufficient scope"})
+			return
+		}
+		next(writer, request)
+	}
+}
+
+func (a *authorizer) authenticate(request *http.Request) (identity string, scope int, err error) {
+	if bearer, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer "); ok {
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare(t.value, []byte(bearer)) == 1 {
+				return "token:" + t.name, t.scope, nil
+			}
+		}
+		return "", scopeNone, errors.New("invalid token")
+	}
+
+	if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+		cn := request.TLS.VerifiedChains[0][0].Subject.CommonName
+		return "cert:" + cn, a.clientScopes[cn], nil
+	}
+	return "", scopeNone, errors.New("missing credentials")
+}
+
+func auditReject(logger *slog.Logger, request *http.Request, identity string, reason string) {
+	logger.Warn("request rejected",
+		slog.String("method", request.Method),
+		slog.String("path", request.URL.Path),
+		slog.String("remote", request.RemoteAddr),
+		slog.String("identity", identity),
+		slog.String("reason", reason))
+}
+
+// tlsConfig returns nil if TLS is not
+// configured, clients are verified
+// against the client CA if set
+func tlsConfig(spec *config.ListenerSpec) (*tls.Config, error) {
+	if spec == nil || spec.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(os.ExpandEnv(spec.TLSCertFile), os.ExpandEnv(spec.TLSKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("load tls key pair:%w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if spec.ClientCAFile == "" {
+		return conf, nil
+	}
+
+	caPEM, err := os.ReadFile(os.ExpandEnv(spec.ClientCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("read client ca:%w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates found in client ca file")
+	}
+	conf.ClientCAs = pool
+	// token callers need not present a
+	// certificate, presented ones are verified
+	conf.ClientAuth = tls.VerifyClientCertIfGiven
+	return conf, nil
+}
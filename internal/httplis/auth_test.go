@@ -0,0 +1,194 @@
+package httplis
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shubhang93/tplagent/internal/config"
+)
+
+func writeFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("write error:%v", err)
+	}
+	return path
+}
+
+func TestAuthorizer_tokens(t *testing.T) {
+	tmp := t.TempDir()
+	spec := &config.ListenerSpec{Tokens: []config.TokenSpec{
+		{File: writeFile(t, tmp, "read", "read-token\n"), Scope: config.ScopeRead},
+		{File: writeFile(t, tmp, "admin", "admin-token"), Scope: config.ScopeAdmin},
+	}}
+	auth, err := newAuthorizer(spec, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	if err != nil {
+		t.Fatalf("newAuthorizer error:%v", err)
+	}
+
+	ok := func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusEndpoint, auth.require(scopeRead, ok))
+	mux.HandleFunc(reloadEndpoint, auth.require(scopeAdmin, ok))
+
+	cases := map[string]struct {
+		method, path, token string
+		want                int
+	}{
+		"no token":              {method: http.MethodGet, path: "/status", want: http.StatusUnauthorized},
+		"invalid token":         {method: http.MethodGet, path: "/status", token: "bad", want: http.StatusUnauthorized},
+		"read token on status":  {method: http.MethodGet, path: "/status", token: "read-token", want: http.StatusOK},
+		"read token on reload":  {method: http.MethodPost, path: "/config/reload", token: "read-token", want: http.StatusForbidden},
+		"admin token on reload": {method: http.MethodPost, path: "/config/reload", token: "admin-token", want: http.StatusOK},
+		"admin token on status": {method: http.MethodGet, path: "/status", token: "admin-token", want: http.StatusOK},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("expected status %d got %d", tc.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAuthorizer_disabled(t *testing.T) {
+	auth, err := newAuthorizer(&config.ListenerSpec{}, slog.Default())
+	if err != nil {
+		t.Fatalf("newAuthorizer error:%v", err)
+	}
+	if auth != nil {
+		t.Fatal("expected auth to be disabled")
+	}
+}
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+	der  []byte
+}
+
+func newCert(t *testing.T, cn string, parent *keyPair, isCA bool) *keyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("key error:%v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("cert error:%v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &keyPair{
+		cert: cert,
+		key:  key,
+		der:  der,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (kp *keyPair) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(kp.key)
+	if err != nil {
+		t.Fatalf("marshal error:%v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestAuthorizer_clientCert(t *testing.T) {
+	tmp := t.TempDir()
+	ca := newCert(t, "test-ca", nil, true)
+	server := newCert(t, "localhost", ca, false)
+	spec := &config.ListenerSpec{
+		TLSCertFile:  writeFile(t, tmp, "server.crt", string(server.pem)),
+		TLSKeyFile:   writeFile(t, tmp, "server.key", string(server.keyPEM(t))),
+		ClientCAFile: writeFile(t, tmp, "ca.crt", string(ca.pem)),
+		ClientScopes: map[string]string{"ops": config.ScopeAdmin, "viewer": config.ScopeRead},
+	}
+
+	auth, err := newAuthorizer(spec, slog.Default())
+	if err != nil {
+		t.Fatalf("newAuthorizer error:%v", err)
+	}
+	tlsConf, err := tlsConfig(spec)
+	if err != nil {
+		t.Fatalf("tlsConfig error:%v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(reloadEndpoint, auth.require(scopeAdmin, func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = tlsConf
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cases := map[string]struct {
+		cn   string
+		want int
+	}{
+		"no certificate":     {want: http.StatusUnauthorized},
+		"admin certificate":  {cn: "ops", want: http.StatusOK},
+		"reader certificate": {cn: "viewer", want: http.StatusForbidden},
+		"unknown client":     {cn: "someone", want: http.StatusForbidden},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			clientTLS := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if tc.cn != "" {
+				client := newCert(t, tc.cn, ca, false)
+				clientTLS.Certificates = []tls.Certificate{{Certificate: [][]byte{client.der}, PrivateKey: client.key}}
+			}
+			c := http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+			resp, err := c.Post(srv.URL+"/config/reload", "application/json", nil)
+			if err != nil {
+				t.Fatalf("request error:%v", err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("expected status %d got %d", tc.want, resp.StatusCode)
+			}
+		})
+	}
+}
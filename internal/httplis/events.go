@@ -0,0 +1,109 @@
+package httplis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/shubhang93/tplagent/internal/events"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const eventsEndpoint = "GET /events"
+
+const sseKeepAlive = 15 * time.Second
+
+// streamEvents streams agent events as server sent
+// events when the client accepts text/event-stream
+// and as newline delimited JSON otherwise, events
+// are filtered by the template and type query params
+func (p *Proc) streamEvents(writer http.ResponseWriter, request *http.Request) {
+	if p.Events == nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": "events are not enabled"})
+		return
+	}
+
+	query := request.URL.Query()
+	filter := events.Filter{Templates: splitValues(query["template"])}
+	for _, t := range splitValues(query["type"]) {
+		if !events.ValidType(events.Type(t)) {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown event type %s", t)})
+			return
+		}
+		filter.Types = append(filter.Types, events.Type(t))
+	}
+
+	// subscribed before the headers are
+	// sent so that the client sees every
+	// event published after it connected
+	sub := p.Events.Subscribe(filter)
+	defer sub.Close()
+
+	// the stream outlives the server write timeout
+	rc := http.NewResponseController(writer)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	sse := strings.Contains(request.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Header().Set("Cache-Control", "no-cache")
+	} else {
+		writer.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	writer.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-request.Context().Done():
+			return
+		case <-keepAlive.C:
+			if !sse {
+				continue
+			}
+			_, err = fmt.Fprint(writer, ": keepalive\n\n")
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			err = writeEvent(writer, e, sse)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(writer http.ResponseWriter, e events.Event, sse bool) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if sse {
+		_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", e.Type, bs)
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "%s\n", bs)
+	return err
+}
+
+// splitValues accepts repeated and
+// comma separated query values
+func splitValues(values []string) []string {
+	var split []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				split = append(split, part)
+			}
+		}
+	}
+	return split
+}
@@ -0,0 +1,88 @@
+package httplis
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shubhang93/tplagent/internal/events"
+)
+
+func TestStreamEvents(t *testing.T) {
+	bus := events.NewBus()
+	p := Proc{Logger: newLogger(), Events: bus}
+	srv := httptest.NewServer(http.HandlerFunc(p.streamEvents))
+	defer srv.Close()
+
+	published := []events.Event{
+		{Type: events.RenderWritten, Template: "app"},
+		{Type: events.RenderError, Template: "db", Error: "boom"},
+		{Type: events.RenderError, Template: "app", Error: "boom"},
+		{Type: events.ExecResult, Template: "app"},
+	}
+
+	t.Run("ndjson", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/events?template=app&type=render_error,exec_result")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("unexpected content type %s", ct)
+		}
+
+		for _, e := range published {
+			bus.Publish(e)
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		var got []string
+		for len(got) < 2 && scanner.Scan() {
+			var e events.Event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(e.Type)+":"+e.Template)
+		}
+		if diff := cmp.Diff([]string{"render_error:app", "exec_result:app"}, got); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
+	t.Run("sse", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events?type=render_written", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		bus.Publish(published[0])
+
+		rdr := bufio.NewReader(resp.Body)
+		eventLine, _ := rdr.ReadString('\n')
+		dataLine, _ := rdr.ReadString('\n')
+		if eventLine != "event: render_written\n" {
+			t.Errorf("unexpected event line %q", eventLine)
+		}
+		if !strings.HasPrefix(dataLine, `data: {"type":"render_written"`) {
+			t.Errorf("unexpected data line %q", dataLine)
+		}
+	})
+
+	t.Run("unknown type", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/events?type=nope")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400 got %d", resp.StatusCode)
+		}
+	})
+}
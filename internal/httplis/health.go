@@ -0,0 +1,43 @@
+package httplis
+
+import (
+	"github.com/shubhang93/tplagent/internal/health"
+	"net/http"
+)
+
+// HealthReporter reports the render
+// loops of the running agent
+type HealthReporter interface {
+	Health() ([]health.Template, error)
+}
+
+const healthzEndpoint = "GET /healthz"
+const readyzEndpoint = "GET /readyz"
+
+func (p *Proc) healthz(writer http.ResponseWriter, _ *http.Request) {
+	p.probe(writer, health.Liveness)
+}
+
+func (p *Proc) readyz(writer http.ResponseWriter, _ *http.Request) {
+	p.probe(writer, health.Readiness)
+}
+
+func (p *Proc) probe(writer http.ResponseWriter, check func([]health.Template) health.Report) {
+	hr, ok := p.Agent.(HealthReporter)
+	if !ok {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": "agent does not report health"})
+		return
+	}
+	templates, err := hr.Health()
+	if err != nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+
+	report := check(templates)
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(writer, status, report)
+}
@@ -0,0 +1,88 @@
+package httplis
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shubhang93/tplagent/internal/health"
+)
+
+type fakeHealthAgent struct {
+	fakeAgent
+	templates []health.Template
+	err       error
+}
+
+func (f *fakeHealthAgent) Health() ([]health.Template, error) {
+	return f.templates, f.err
+}
+
+func TestProbes(t *testing.T) {
+	tests := map[string]struct {
+		agent       RefreshTriggerer
+		wantHealthz int
+		wantReadyz  int
+	}{
+		"ready": {
+			agent: &fakeHealthAgent{templates: []health.Template{
+				{Name: "app", Required: true, Running: true, Rendered: true},
+				{Name: "optional", Running: true},
+			}},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusOK,
+		},
+		"required not rendered": {
+			agent: &fakeHealthAgent{templates: []health.Template{
+				{Name: "app", Required: true, Running: true},
+			}},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+		"required failed": {
+			agent: &fakeHealthAgent{templates: []health.Template{
+				{Name: "app", Required: true, Rendered: true, Failed: true},
+			}},
+			wantHealthz: http.StatusOK,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+		"wedged": {
+			agent: &fakeHealthAgent{templates: []health.Template{
+				{Name: "app", Running: true, Wedged: true},
+			}},
+			wantHealthz: http.StatusServiceUnavailable,
+			wantReadyz:  http.StatusOK,
+		},
+		"agent not running": {
+			agent:       &fakeHealthAgent{err: errors.New("agent is not running")},
+			wantHealthz: http.StatusServiceUnavailable,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+		"no health reporter": {
+			agent:       &fakeAgent{},
+			wantHealthz: http.StatusServiceUnavailable,
+			wantReadyz:  http.StatusServiceUnavailable,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := Proc{Logger: newLogger(), Agent: tc.agent}
+			for _, probe := range []struct {
+				handler http.HandlerFunc
+				want    int
+			}{{p.healthz, tc.wantHealthz}, {p.readyz, tc.wantReadyz}} {
+				rec := httptest.NewRecorder()
+				probe.handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				if rec.Code != probe.want {
+					t.Errorf("expected status %d got %d: %s", probe.want, rec.Code, rec.Body.String())
+				}
+				if !json.Valid(rec.Body.Bytes()) {
+					t.Errorf("expected a JSON body got %s", rec.Body.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package httplis
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/render"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LabelSelector resolves hook selectors
+// to the templates of the running agent
+type LabelSelector interface {
+	TemplatesMatching(selector map[string]string) []string
+}
+
+const hooksEndpoint = "POST /hooks/{id}"
+
+const defaultSignatureHeader = "X-Signature-256"
+const defaultMaxSkew = 5 * time.Minute
+const maxHookBody = 1 << 20
+
+// hookShutdownGrace bounds the wait for hook
+// refreshes once the listener is stopped
+const hookShutdownGrace = 5 * time.Second
+
+type hook struct {
+	secret          []byte
+	signatureHeader string
+	timestampHeader string
+	maxSkew         time.Duration
+	templates       []string
+	selector        map[string]string
+}
+
+func newHooks(specs map[string]*config.HookSpec) (map[string]*hook, error) {
+	hooks := make(map[string]*hook, len(specs))
+	for id, spec := range specs {
+		contents, err := os.ReadFile(os.ExpandEnv(spec.SecretFile))
+		if err != nil {
+			return nil, fmt.Errorf("read secret of hook %s:%w", id, err)
+		}
+		secret := strings.TrimSpace(string(contents))
+		if secret == "" {
+			return nil, fmt.Errorf("secret file of hook %s is empty", id)
+		}
+		hooks[id] = &hook{
+			secret:          []byte(secret),
+			signatureHeader: cmp.Or(spec.SignatureHeader, defaultSignatureHeader),
+			timestampHeader: spec.TimestampHeader,
+			maxSkew:         cmp.Or(time.Duration(spec.MaxSkew), defaultMaxSkew),
+			templates:       spec.Templates,
+			selector:        spec.Selector,
+		}
+	}
+	return hooks, nil
+}
+
+// verify checks the hex encoded HMAC-SHA256 signature,
+// optionally prefixed with sha256=, of the body or of
+// timestamp.body when a timestamp header is configured
+func (h *hook) verify(header http.Header, body []byte, now time.Time) error {
+	signature := strings.TrimPrefix(header.Get(h.signatureHeader), "sha256=")
+	if signature == "" {
+		return errors.New("missing signature")
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+
+	mac := hmac.New(sha256.New, h.secret)
+	if h.timestampHeader != "" {
+		ts := header.Get(h.timestampHeader)
+		secs, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return errors.New("missing or malformed timestamp")
+		}
+		if skew := now.Sub(time.Unix(secs, 0)).Abs(); skew > h.maxSkew {
+			return errors.New("timestamp outside the allowed window")
+		}
+		mac.Write([]byte(ts + "."))
+	}
+	mac.Write(body)
+
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func (p *Proc) receiveHook(writer http.ResponseWriter, request *http.Request) {
+	id := request.PathValue("id")
+	h, ok := p.hooks[id]
+	if !ok {
+		writeJSON(writer, http.StatusNotFound, map[string]string{"error": "hook not found"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxHookBody))
+	if err != nil {
+		writeJSON(writer, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := h.verify(request.Header, body, time.Now()); err != nil {
+		auditReject(p.Logger, request, "hook:"+id, err.Error())
+		writeJSON(writer, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if p.Agent == nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": "agent is not running"})
+		return
+	}
+
+	templates := slices.Clone(h.templates)
+	if ls, ok := p.Agent.(LabelSelector); ok && len(h.selector) > 0 {
+		templates = append(templates, ls.TemplatesMatching(h.selector)...)
+	}
+	slices.Sort(templates)
+	templates = slices.Compact(templates)
+
+	p.Logger.Info("hook received", slog.String("hook", id), slog.Any("templates", templates))
+	// renders can outlast the sender
+	// timeout, errors are logged
+	for _, name := range templates {
+		p.refresher.refresh(id, name)
+	}
+	writeJSON(writer, http.StatusAccepted, map[string]any{"templates": templates})
+}
+
+// hookRefresher runs hook triggered refreshes
+// with at most one worker per template, triggers
+// arriving while a refresh is queued are coalesced
+type hookRefresher struct {
+	ctx    context.Context
+	agent  RefreshTriggerer
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]chan string
+	wg      sync.WaitGroup
+}
+
+func newHookRefresher(ctx context.Context, agent RefreshTriggerer, logger *slog.Logger) *hookRefresher {
+	return &hookRefresher{
+		ctx:     ctx,
+		agent:   agent,
+		logger:  logger,
+		pending: map[string]chan string{},
+	}
+}
+
+func (r *hookRefresher) refresh(hookID string, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	queue, ok := r.pending[name]
+	if !ok {
+		queue = make(chan string, 1)
+		r.pending[name] = queue
+		r.wg.Add(1)
+		go r.run(name, queue)
+	}
+
+	select {
+	case queue <- hookID:
+	default:
+		// a refresh is already queued
+	}
+}
+
+func (r *hookRefresher) run(name string, queue chan string) {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case hookID := <-queue:
+			err := r.agent.TriggerRefresh(name)
+			if err != nil && !errors.Is(err, render.ContentsIdentical) {
+				r.logger.Error("hook refresh failed",
+					slog.String("hook", hookID),
+					slog.String("templ", name),
+					slog.String("error", err.Error()))
+			}
+		}
+
+		// the worker exits once idle, refresh
+		// holds mu while queueing so nothing is lost
+		r.mu.Lock()
+		if len(queue) == 0 {
+			delete(r.pending, name)
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+	}
+}
+
+// wait blocks until every running refresh returns
+// or grace has passed, it reports if they returned
+func (r *hookRefresher) wait(grace time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(grace):
+		return false
+	}
+}
@@ -0,0 +1,266 @@
+package httplis
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/render"
+)
+
+func sign(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHook_verify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	body := `{"ref":"main"}`
+
+	plain := &hook{secret: []byte("s3cret"), signatureHeader: defaultSignatureHeader, maxSkew: defaultMaxSkew}
+	timed := &hook{secret: []byte("s3cret"), signatureHeader: defaultSignatureHeader, timestampHeader: "X-Timestamp", maxSkew: defaultMaxSkew}
+
+	tests := map[string]struct {
+		hook    *hook
+		headers map[string]string
+		wantErr bool
+	}{
+		"valid": {
+			hook:    plain,
+			headers: map[string]string{defaultSignatureHeader: sign("s3cret", body)},
+		},
+		"valid with prefix": {
+			hook:    plain,
+			headers: map[string]string{defaultSignatureHeader: "sha256=" + sign("s3cret", body)},
+		},
+		"wrong secret": {
+			hook:    plain,
+			headers: map[string]string{defaultSignatureHeader: sign("other", body)},
+			wantErr: true,
+		},
+		"missing signature": {
+			hook:    plain,
+			wantErr: true,
+		},
+		"valid timestamp": {
+			hook:    timed,
+			headers: map[string]string{defaultSignatureHeader: sign("s3cret", ts+"."+body), "X-Timestamp": ts},
+		},
+		"stale timestamp": {
+			hook:    timed,
+			headers: map[string]string{defaultSignatureHeader: sign("s3cret", stale+"."+body), "X-Timestamp": stale},
+			wantErr: true,
+		},
+		"timestamp not signed": {
+			hook:    timed,
+			headers: map[string]string{defaultSignatureHeader: sign("s3cret", body), "X-Timestamp": ts},
+			wantErr: true,
+		},
+		"missing timestamp": {
+			hook:    timed,
+			headers: map[string]string{defaultSignatureHeader: sign("s3cret", ts+"."+body)},
+			wantErr: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tc.headers {
+				header.Set(k, v)
+			}
+			err := tc.hook.verify(header, []byte(body), now)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("expected error %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+type selectorAgent struct {
+	mu        sync.Mutex
+	triggered []string
+	done      chan struct{}
+}
+
+func (s *selectorAgent) TriggerRefresh(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.triggered = append(s.triggered, name)
+	s.done <- struct{}{}
+	return nil
+}
+
+func (s *selectorAgent) ForceRefresh(name string) error {
+	return s.TriggerRefresh(name)
+}
+
+func (s *selectorAgent) TemplatesMatching(selector map[string]string) []string {
+	if selector["team"] == "payments" {
+		return []string{"payments-db", "payments-api"}
+	}
+	return nil
+}
+
+func TestReceiveHook(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "hook.secret")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hooks, err := newHooks(map[string]*config.HookSpec{
+		"git": {
+			SecretFile: secretFile,
+			Templates:  []string{"nginx-conf", "payments-api"},
+			Selector:   map[string]string{"team": "payments"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &selectorAgent{done: make(chan struct{}, 3)}
+	p := Proc{Logger: newLogger(), Agent: agent, hooks: hooks}
+	p.refresher = newHookRefresher(context.Background(), agent, p.Logger)
+
+	post := func(id string, signature string) int {
+		body := `{"ref":"main"}`
+		req := httptest.NewRequest(http.MethodPost, "/hooks/"+id, strings.NewReader(body))
+		req.SetPathValue("id", id)
+		req.Header.Set(defaultSignatureHeader, signature)
+		rec := httptest.NewRecorder()
+		p.receiveHook(rec, req)
+		return rec.Code
+	}
+
+	if status := post("unknown", sign("s3cret", `{"ref":"main"}`)); status != http.StatusNotFound {
+		t.Errorf("expected status 404 got %d", status)
+	}
+	if status := post("git", sign("wrong", `{"ref":"main"}`)); status != http.StatusUnauthorized {
+		t.Errorf("expected status 401 got %d", status)
+	}
+	if status := post("git", sign("s3cret", `{"ref":"main"}`)); status != http.StatusAccepted {
+		t.Fatalf("expected status 202 got %d", status)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-agent.done:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for refreshes")
+		}
+	}
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	slices.Sort(agent.triggered)
+	if diff := cmp.Diff([]string{"nginx-conf", "payments-api", "payments-db"}, agent.triggered); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+type blockingAgent struct {
+	mu      sync.Mutex
+	calls   int
+	release chan struct{}
+}
+
+func (b *blockingAgent) TriggerRefresh(string) error {
+	b.mu.Lock()
+	b.calls++
+	b.mu.Unlock()
+	<-b.release
+	return fmt.Errorf("render nginx-conf:%w", render.ContentsIdentical)
+}
+
+func (b *blockingAgent) ForceRefresh(name string) error {
+	return b.TriggerRefresh(name)
+}
+
+func TestHookRefresher(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	agent := &blockingAgent{release: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newHookRefresher(ctx, agent, logger)
+
+	r.refresh("git", "nginx-conf")
+	// wait for the first refresh to start
+	for {
+		agent.mu.Lock()
+		started := agent.calls == 1
+		agent.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// retries while a refresh runs coalesce into one
+	for i := 0; i < 50; i++ {
+		r.refresh("git", "nginx-conf")
+	}
+	close(agent.release)
+	if !r.wait(time.Second) {
+		t.Fatal("timed out waiting for refreshes")
+	}
+
+	if agent.calls != 2 {
+		t.Errorf("expected 2 refreshes got %d", agent.calls)
+	}
+	if strings.Contains(logs.String(), "hook refresh failed") {
+		t.Errorf("identical contents logged as a failure:\n%s", logs.String())
+	}
+
+	cancel()
+	r.refresh("git", "nginx-conf")
+	r.wait(time.Second)
+	if agent.calls != 2 {
+		t.Errorf("expected no refresh after cancel got %d calls", agent.calls)
+	}
+}
+
+func TestHookRefresher_waitDeadline(t *testing.T) {
+	// the refresh never returns
+	agent := &blockingAgent{release: make(chan struct{})}
+	defer close(agent.release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newHookRefresher(ctx, agent, newLogger())
+	r.refresh("git", "nginx-conf")
+	for {
+		agent.mu.Lock()
+		started := agent.calls == 1
+		agent.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	start := time.Now()
+	if r.wait(50 * time.Millisecond) {
+		t.Error("expected wait to give up on the stuck refresh")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("wait took %s", elapsed)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package httplis
+
+import (
+	"errors"
+	"net"
+)
+
+func peerCredentials(*net.UnixConn) (peerCred, error) {
+	return peerCred{}, errors.New("peer credentials are only supported on linux")
+}
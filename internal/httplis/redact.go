@@ -0,0 +1,71 @@
+package httplis
+
+import (
+	"encoding/json"
+	"github.com/shubhang93/tplagent/internal/config"
+	"regexp"
+)
+
+const redacted = "<redacted>"
+
+var secretKey = regexp.MustCompile(`(?i)(token|secret|passw|credential|api_?key|auth|private)`)
+
+// maps whose values are secrets
+// irrespective of their keys
+var secretMaps = map[string]struct{}{
+	"env":     {},
+	"headers": {},
+}
+
+// redactConfig returns c as a JSON document
+// with values of secret looking keys replaced
+func redactConfig(c config.TPLAgent) (any, error) {
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, err
+	}
+	return redact(doc), nil
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			switch {
+			case isSecretMap(key, value):
+				for k := range value.(map[string]any) {
+					value.(map[string]any)[k] = redacted
+				}
+			case secretKey.MatchString(key) && isScalar(value):
+				v[key] = redacted
+			default:
+				v[key] = redact(value)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
+
+func isSecretMap(key string, value any) bool {
+	if _, ok := secretMaps[key]; !ok {
+		return false
+	}
+	_, ok := value.(map[string]any)
+	return ok
+}
+
+func isScalar(v any) bool {
+	switch v.(type) {
+	case map[string]any, []any, nil:
+		return false
+	}
+	return true
+}
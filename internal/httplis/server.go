@@ -6,11 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/events"
+	"github.com/shubhang93/tplagent/internal/render"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -20,32 +23,112 @@ type reloadRequest struct {
 	ConfigPath string          `json:"config_path"`
 }
 
+// RefreshTriggerer refreshes templates
+// of the running agent on demand
+type RefreshTriggerer interface {
+	TriggerRefresh(templateName string) error
+	ForceRefresh(templateName string) error
+}
+
+// StatusReporter lists the templates
+// with a running render loop
+type StatusReporter interface {
+	Templates() []string
+}
+
 type Proc struct {
 	Logger   *slog.Logger
 	Reloaded bool
+	Agent    RefreshTriggerer
+	// Listener configures TLS and authorization,
+	// every request is allowed if it is nil
+	Listener *config.ListenerSpec
+	// ConfigPath is the config file
+	// updated by the template endpoints
+	ConfigPath string
+	// Events is streamed by GET /events
+	Events *events.Bus
+	// Hooks are served at POST /hooks/{id}
+	Hooks map[string]*config.HookSpec
+
+	configMU  sync.Mutex
+	hooks     map[string]*hook
+	refresher *hookRefresher
 }
 
 const reloadEndpoint = "POST /config/reload"
 const stopAgent = "POST /agent/stop"
+const triggerEndpoint = "POST /templates/{name}/trigger"
+const statusEndpoint = "GET /status"
+
+// Start serves the endpoints on addr until ctx is
+// done, an error is returned if the listener could
+// not be set up or stopped serving unexpectedly
+func (p *Proc) Start(ctx context.Context, addr string) error {
+	auth, err := newAuthorizer(p.Listener, p.Logger)
+	if err != nil {
+		return fmt.Errorf("listener auth:%w", err)
+	}
+	tlsConf, err := tlsConfig(p.Listener)
+	if err != nil {
+		return fmt.Errorf("listener tls:%w", err)
+	}
+
+	p.hooks, err = newHooks(p.Hooks)
+	if err != nil {
+		return fmt.Errorf("listener hooks:%w", err)
+	}
+
+	if auth == nil {
+		p.Logger.Warn("http listener accepts unauthenticated requests")
+	}
+
+	ln, err := listen(addr, p.Listener)
+	if err != nil {
+		return fmt.Errorf("listen:%w", err)
+	}
+	hooksCtx, stopHooks := context.WithCancel(ctx)
+	defer stopHooks()
+	p.refresher = newHookRefresher(hooksCtx, p.Agent, p.Logger)
 
-func (p *Proc) Start(ctx context.Context, addr string) {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc(reloadEndpoint, p.reloadConfig)
-	mux.HandleFunc(stopAgent, p.stopAgent)
+	mux.HandleFunc(reloadEndpoint, auth.require(scopeAdmin, p.reloadConfig))
+	mux.HandleFunc(stopAgent, auth.require(scopeAdmin, p.stopAgent))
+	mux.HandleFunc(triggerEndpoint, auth.require(scopeTrigger, p.triggerRefresh))
+	mux.HandleFunc(statusEndpoint, auth.require(scopeRead, p.status))
+	// hooks are authenticated by their signature
+	mux.HandleFunc(hooksEndpoint, p.receiveHook)
+	// probes are left open for orchestrators
+	mux.HandleFunc(healthzEndpoint, p.healthz)
+	mux.HandleFunc(readyzEndpoint, p.readyz)
+	mux.HandleFunc(eventsEndpoint, auth.require(scopeRead, p.streamEvents))
+	mux.HandleFunc(getConfigEndpoint, auth.require(scopeRead, p.getConfig))
+	mux.HandleFunc(putTemplateEndpoint, auth.require(scopeAdmin, p.putTemplate))
+	mux.HandleFunc(patchTemplateEndpoint, auth.require(scopeAdmin, p.patchTemplate))
+	mux.HandleFunc(deleteTemplateEndpoint, auth.require(scopeAdmin, p.deleteTemplate))
 
 	srvr := http.Server{
 		Addr:         addr,
-		Handler:      mux,
+		Handler:      newPeerChecker(addr, p.Listener, p.Logger).wrap(mux),
+		ConnContext:  connContext,
 		WriteTimeout: 3 * time.Second,
 		ReadTimeout:  10 * time.Second,
+		TLSConfig:    tlsConf,
+		// ends long running requests
+		// like event streams on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
+	serveDone := make(chan struct{})
 	wait := make(chan struct{})
 	go func() {
 		defer close(wait)
 
-		<-ctx.Done()
+		select {
+		case <-ctx.Done():
+		case <-serveDone:
+		}
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
 		_ = srvr.Shutdown(shutdownCtx)
@@ -57,14 +140,27 @@ func (p *Proc) Start(ctx context.Context, addr string) {
 		p.Logger.Info("starting http listener", slog.String("addr", addr))
 	}
 
-	err := srvr.ListenAndServe()
-	if !errors.Is(err, http.ErrServerClosed) && err != nil {
-		p.Logger.Error("ListenAndServe error", slog.String("error", err.Error()))
+	if tlsConf != nil {
+		// certificates are part of TLSConfig
+		err = srvr.ServeTLS(ln, "", "")
+	} else {
+		err = srvr.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
 	}
 
+	close(serveDone)
 	<-wait
+	stopHooks()
+	if !p.refresher.wait(hookShutdownGrace) {
+		p.Logger.Warn("hook refreshes still running after shutdown")
+	}
+	if err != nil {
+		return fmt.Errorf("serve:%w", err)
+	}
 	p.Logger.Info("http listener exited without errors")
-
+	return nil
 }
 
 func (p *Proc) stopAgent(writer http.ResponseWriter, _ *http.Request) {
@@ -85,6 +181,41 @@ func (p *Proc) stopAgent(writer http.ResponseWriter, _ *http.Request) {
 
 }
 
+func (p *Proc) triggerRefresh(writer http.ResponseWriter, request *http.Request) {
+	if p.Agent == nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": "agent is not running"})
+		return
+	}
+
+	name := request.PathValue("name")
+	force := request.URL.Query().Get("force") == "true"
+
+	p.Logger.Info("refresh triggerred", slog.String("templ", name), slog.Bool("force", force))
+
+	var err error
+	if force {
+		err = p.Agent.ForceRefresh(name)
+	} else {
+		err = p.Agent.TriggerRefresh(name)
+	}
+	switch {
+	case errors.Is(err, render.ContentsIdentical):
+		writeJSON(writer, http.StatusOK, map[string]bool{"success": true, "changed": false})
+	case err != nil:
+		writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(writer, http.StatusOK, map[string]bool{"success": true, "changed": true})
+	}
+}
+
+func (p *Proc) status(writer http.ResponseWriter, _ *http.Request) {
+	templates := []string{}
+	if sr, ok := p.Agent.(StatusReporter); ok {
+		templates = sr.Templates()
+	}
+	writeJSON(writer, http.StatusOK, map[string]any{"templates": templates})
+}
+
 func writeJSON(writer http.ResponseWriter, status int, data any) {
 	writer.WriteHeader(status)
 	_ = json.NewEncoder(writer).Encode(data)
@@ -95,6 +226,12 @@ func (p *Proc) reloadConfig(writer http.ResponseWriter, request *http.Request) {
 
 	reloadReq := reloadRequest{}
 	err := json.NewDecoder(request.Body).Decode(&reloadReq)
+	if errors.Is(err, io.EOF) {
+		// an empty body re-reads
+		// the config from disk
+		p.signalReload(writer)
+		return
+	}
 	if err != nil {
 		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
@@ -121,6 +258,10 @@ func (p *Proc) reloadConfig(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	p.signalReload(writer)
+}
+
+func (p *Proc) signalReload(writer http.ResponseWriter) {
 	proc, err := os.FindProcess(os.Getpid())
 	if err != nil {
 		writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
@@ -137,44 +278,26 @@ func (p *Proc) reloadConfig(writer http.ResponseWriter, request *http.Request) {
 
 }
 
+// backupAndReplace keeps the old config as path.bak and
+// writes newConfig in the format of the file extension
 func backupAndReplace(path string, newConfig config.TPLAgent) error {
 	bakFilename := fmt.Sprintf("%s.%s", path, "bak")
 	bakFile, err := os.Create(bakFilename)
 	if err != nil {
 		return err
 	}
+	defer bakFile.Close()
 
 	oldFile, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	defer oldFile.Close()
 
 	_, err = io.Copy(bakFile, oldFile)
 	if err != nil {
 		return err
 	}
-	_ = bakFile.Close()
-
-	tempFilename := fmt.Sprintf("%s.%s", path, "temp")
-	tempFile, err := os.Create(tempFilename)
-	if err != nil {
-		_ = os.Remove(bakFilename)
-		return err
-	}
-
-	jd := json.NewEncoder(tempFile)
-	jd.SetIndent("", strings.Repeat(" ", 2))
-	err = jd.Encode(newConfig)
-	if err != nil {
-		_ = os.Remove(tempFilename)
-		return err
-	}
-
-	err = os.Rename(tempFilename, path)
-	if err != nil {
-		_ = os.Remove(tempFilename)
-		return err
-	}
-	return nil
 
+	return config.WriteFile(path, newConfig)
 }
@@ -2,9 +2,14 @@ package httplis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/render"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,6 +20,8 @@ import (
 	"time"
 )
 
+const addr = "localhost:6000"
+
 func TestStart(t *testing.T) {
 
 	type reloadTest struct {
@@ -82,7 +89,6 @@ func TestStart(t *testing.T) {
 		},
 	}
 
-	const addr = "localhost:6000"
 	for _, rt := range reloadTests {
 		t.Run(rt.name, func(t *testing.T) {
 
@@ -185,6 +191,159 @@ func TestStart(t *testing.T) {
 
 }
 
+type fakeAgent struct {
+	triggered map[string]bool
+}
+
+func (f *fakeAgent) TriggerRefresh(name string) error {
+	return f.refresh(name, false)
+}
+
+func (f *fakeAgent) ForceRefresh(name string) error {
+	return f.refresh(name, true)
+}
+
+func (f *fakeAgent) refresh(name string, force bool) error {
+	switch name {
+	case "identical":
+		return render.ContentsIdentical
+	case "unknown":
+		return errors.New("render loop not initialized")
+	}
+	f.triggered[name] = force
+	return nil
+}
+
+func TestTriggerRefresh(t *testing.T) {
+	fa := &fakeAgent{triggered: map[string]bool{}}
+	s := Proc{Logger: newLogger(), Agent: fa}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		s.Start(ctx, addr)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/templates/nginx-conf/trigger", wantStatus: http.StatusOK},
+		{path: "/templates/hosts/trigger?force=true", wantStatus: http.StatusOK},
+		{path: "/templates/identical/trigger", wantStatus: http.StatusOK},
+		{path: "/templates/unknown/trigger", wantStatus: http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		resp, err := http.Post("http://"+addr+tc.path, "application/json", nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tc.wantStatus {
+			t.Errorf("%s:expected status %d got %d", tc.path, tc.wantStatus, resp.StatusCode)
+		}
+	}
+
+	expected := map[string]bool{"nginx-conf": false, "hosts": true}
+	if diff := cmp.Diff(expected, fa.triggered); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func TestStart_setupErrors(t *testing.T) {
+	tmp := t.TempDir()
+	busy, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	tests := map[string]struct {
+		addr  string
+		spec  *config.ListenerSpec
+		hooks map[string]*config.HookSpec
+	}{
+		"missing token file": {
+			addr: addr,
+			spec: &config.ListenerSpec{Tokens: []config.TokenSpec{{File: tmp + "/missing", Scope: config.ScopeAdmin}}},
+		},
+		"missing tls key pair": {
+			addr: addr,
+			spec: &config.ListenerSpec{TLSCertFile: tmp + "/tls.crt", TLSKeyFile: tmp + "/tls.key"},
+		},
+		"missing hook secret": {
+			addr:  addr,
+			hooks: map[string]*config.HookSpec{"deploy": {SecretFile: tmp + "/missing"}},
+		},
+		"address in use": {
+			addr: busy.Addr().String(),
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := Proc{Logger: newLogger(), Agent: &fakeAgent{}, Listener: tc.spec, Hooks: tc.hooks}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := s.Start(ctx, tc.addr); err == nil {
+				t.Error("expected an error")
+			}
+			if ctx.Err() != nil {
+				t.Error("Start did not return before the deadline")
+			}
+		})
+	}
+}
+
 func newLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stdout, nil))
 }
+
+func Test_backupAndReplace(t *testing.T) {
+	for _, format := range []string{"json", "toml", "hcl"} {
+		t.Run(format, func(t *testing.T) {
+			path := fmt.Sprintf("%s/config.%s", t.TempDir(), format)
+			old := config.TPLAgent{Agent: config.Agent{LogFmt: "text"}}
+			if err := config.WriteFile(path, old); err != nil {
+				t.Fatal(err)
+			}
+			oldContents, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newConfig := config.TPLAgent{
+				Agent: config.Agent{LogFmt: "json"},
+				TemplateSpecs: map[string]*config.TemplateSpec{
+					"app": {Raw: "hello", Destination: "/tmp/app.conf"},
+				},
+			}
+			if err := backupAndReplace(path, newConfig); err != nil {
+				t.Fatal(err)
+			}
+
+			conf, err := config.ReadFromFile(path)
+			if err != nil {
+				t.Fatalf("expected the config to stay %s:%v", format, err)
+			}
+			if conf.Agent.LogFmt != "json" || conf.TemplateSpecs["app"] == nil {
+				t.Errorf("unexpected config %+v", conf)
+			}
+			bak, err := os.ReadFile(path + ".bak")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(oldContents), string(bak)); diff != "" {
+				t.Errorf("(--Want ++Got):\n%s", diff)
+			}
+		})
+	}
+}
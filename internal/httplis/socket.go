@@ -0,0 +1,174 @@
+package httplis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/shubhang93/tplagent/internal/config"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const defaultSocketMode = 0600
+const staleSocketProbeTimeout = 500 * time.Millisecond
+
+// listen opens a unix socket for unix:// addresses
+// and a tcp socket for everything else
+func listen(addr string, spec *config.ListenerSpec) (net.Listener, error) {
+	path, ok := config.SocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setSocketPerms(path, spec); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes a socket left behind by an
+// agent which did not exit cleanly, anything other than
+// a socket and sockets with a live listener are kept
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat socket path:%w", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("socket path %s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, staleSocketProbeTimeout)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("socket %s is in use by another process", path)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove stale socket:%w", err)
+	}
+	return nil
+}
+
+func setSocketPerms(path string, spec *config.ListenerSpec) error {
+	mode := os.FileMode(defaultSocketMode)
+	if spec != nil && spec.SocketMode != "" {
+		parsed, err := strconv.ParseUint(spec.SocketMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("parse socket mode:%w", err)
+		}
+		mode = os.FileMode(parsed)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("chmod socket:%w", err)
+	}
+
+	if spec == nil || spec.SocketGroup == "" {
+		return nil
+	}
+	gid, err := lookupGID(spec.SocketGroup)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(path, -1, gid); err != nil {
+		return fmt.Errorf("chown socket:%w", err)
+	}
+	return nil
+}
+
+func lookupGID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("lookup socket group:%w", err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+type peerCred struct {
+	uid int
+	gid int
+}
+
+type peerCredKey struct{}
+
+type peerCredResult struct {
+	cred peerCred
+	err  error
+}
+
+// connContext attaches the peer credentials
+// of unix socket connections to the request context
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	// TLS connections wrap the unix connection
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	cred, err := peerCredentials(uc)
+	return context.WithValue(ctx, peerCredKey{}, peerCredResult{cred: cred, err: err})
+}
+
+// peerChecker only allows unix socket callers whose
+// uid or gid is allowed, a nil peerChecker allows everyone
+type peerChecker struct {
+	uids   []int
+	gids   []int
+	logger *slog.Logger
+}
+
+func newPeerChecker(addr string, spec *config.ListenerSpec, logger *slog.Logger) *peerChecker {
+	if _, ok := config.SocketPath(addr); !ok || spec == nil {
+		return nil
+	}
+	if len(spec.AllowedUIDs) < 1 && len(spec.AllowedGIDs) < 1 {
+		return nil
+	}
+	return &peerChecker{uids: spec.AllowedUIDs, gids: spec.AllowedGIDs, logger: logger}
+}
+
+func (pc *peerChecker) wrap(next http.Handler) http.Handler {
+	if pc == nil {
+		return next
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		res, ok := request.Context().Value(peerCredKey{}).(peerCredResult)
+		switch {
+		case !ok:
+			auditReject(pc.logger, request, "", "missing peer credentials")
+		case res.err != nil:
+			auditReject(pc.logger, request, "", res.err.Error())
+		case slices.Contains(pc.uids, res.cred.uid) || slices.Contains(pc.gids, res.cred.gid):
+			next.ServeHTTP(writer, request)
+			return
+		default:
+			identity := fmt.Sprintf("uid:%d gid:%d", res.cred.uid, res.cred.gid)
+			auditReject(pc.logger, request, identity, "peer not allowed")
+		}
+		writeJSON(writer, http.StatusForbidden, map[string]string{"error": "peer not allowed"})
+	})
+}
@@ -0,0 +1,187 @@
+package httplis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/shubhang93/tplagent/internal/config"
+)
+
+func startSocketListener(t *testing.T, spec *config.ListenerSpec) (string, *http.Client) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "tplagent.sock")
+	s := Proc{Logger: newLogger(), Agent: &fakeAgent{}, Listener: spec}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Start(ctx, config.UnixSocketScheme+sock)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	return sock, client
+}
+
+func TestStart_unixSocket(t *testing.T) {
+	sock, client := startSocketListener(t, &config.ListenerSpec{SocketMode: "0640"})
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("stat error:%v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0640 {
+		t.Errorf("expected socket mode 0640 got %o", perm)
+	}
+
+	resp, err := client.Get("http://tplagent/status")
+	if err != nil {
+		t.Fatalf("GET error:%v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestStart_unixSocketPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	tests := map[string]struct {
+		spec       *config.ListenerSpec
+		wantStatus int
+	}{
+		"allowed uid": {
+			spec:       &config.ListenerSpec{AllowedUIDs: []int{os.Getuid()}},
+			wantStatus: http.StatusOK,
+		},
+		"allowed gid": {
+			spec:       &config.ListenerSpec{AllowedGIDs: []int{os.Getgid()}},
+			wantStatus: http.StatusOK,
+		},
+		"peer not allowed": {
+			spec:       &config.ListenerSpec{AllowedUIDs: []int{os.Getuid() + 1}},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, client := startSocketListener(t, tc.spec)
+			resp, err := client.Get("http://tplagent/status")
+			if err != nil {
+				t.Fatalf("GET error:%v", err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("expected status %d got %d", tc.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_removeStaleSocket(t *testing.T) {
+	t.Run("keeps regular files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tplagent.sock")
+		if err := os.WriteFile(path, []byte("not a socket"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := removeStaleSocket(path); err == nil {
+			t.Error("expected an error for a regular file")
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("regular file removed:%v", err)
+		}
+	})
+
+	t.Run("keeps live sockets", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tplagent.sock")
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		if err := removeStaleSocket(path); err == nil {
+			t.Error("expected an error for a live socket")
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("live socket removed:%v", err)
+		}
+	})
+
+	t.Run("removes stale sockets", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tplagent.sock")
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		_ = ln.Close()
+		if err := removeStaleSocket(path); err != nil {
+			t.Errorf("unexpected error:%v", err)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected stale socket to be removed got %v", err)
+		}
+	})
+
+	t.Run("ignores missing paths", func(t *testing.T) {
+		if err := removeStaleSocket(filepath.Join(t.TempDir(), "tplagent.sock")); err != nil {
+			t.Errorf("unexpected error:%v", err)
+		}
+	})
+}
+
+func TestStart_unixSocketTLSPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	tmp := t.TempDir()
+	ca := newCert(t, "test-ca", nil, true)
+	server := newCert(t, "localhost", ca, false)
+	spec := &config.ListenerSpec{
+		TLSCertFile: writeFile(t, tmp, "server.crt", string(server.pem)),
+		TLSKeyFile:  writeFile(t, tmp, "server.key", string(server.keyPEM(t))),
+		AllowedUIDs: []int{os.Getuid()},
+	}
+	sock, _ := startSocketListener(t, spec)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"},
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("https://tplagent/status")
+	if err != nil {
+		t.Fatalf("GET error:%v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d got %d", http.StatusOK, resp.StatusCode)
+	}
+}
@@ -0,0 +1,179 @@
+package httplis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shubhang93/tplagent/internal/config"
+	"log/slog"
+	"net/http"
+)
+
+// TemplateReloader reloads a single
+// template of the running agent
+type TemplateReloader interface {
+	ReloadTemplate(conf config.TPLAgent, name string) error
+}
+
+const getConfigEndpoint = "GET /config"
+const putTemplateEndpoint = "PUT /templates/{name}"
+const patchTemplateEndpoint = "PATCH /templates/{name}"
+const deleteTemplateEndpoint = "DELETE /templates/{name}"
+
+var errTemplateNotFound = errors.New("template not found")
+
+func (p *Proc) getConfig(writer http.ResponseWriter, _ *http.Request) {
+	if p.ConfigPath == "" {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": "config path is not known"})
+		return
+	}
+	conf, err := config.ReadFromFile(p.ConfigPath)
+	if err != nil {
+		writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	doc, err := redactConfig(conf)
+	if err != nil {
+		writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(writer, http.StatusOK, doc)
+}
+
+func (p *Proc) putTemplate(writer http.ResponseWriter, request *http.Request) {
+	var spec config.TemplateSpec
+	if err := json.NewDecoder(request.Body).Decode(&spec); err != nil {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	p.updateTemplate(writer, request.PathValue("name"), func(specs map[string]*config.TemplateSpec, name string) (int, error) {
+		status := http.StatusOK
+		if _, ok := specs[name]; !ok {
+			status = http.StatusCreated
+		}
+		specs[name] = &spec
+		return status, nil
+	})
+}
+
+func (p *Proc) patchTemplate(writer http.ResponseWriter, request *http.Request) {
+	var patch any
+	if err := json.NewDecoder(request.Body).Decode(&patch); err != nil {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	p.updateTemplate(writer, request.PathValue("name"), func(specs map[string]*config.TemplateSpec, name string) (int, error) {
+		existing, ok := specs[name]
+		if !ok {
+			return http.StatusNotFound, errTemplateNotFound
+		}
+		patched, err := patchSpec(existing, patch)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		specs[name] = patched
+		return http.StatusOK, nil
+	})
+}
+
+func (p *Proc) deleteTemplate(writer http.ResponseWriter, request *http.Request) {
+	p.updateTemplate(writer, request.PathValue("name"), func(specs map[string]*config.TemplateSpec, name string) (int, error) {
+		if _, ok := specs[name]; !ok {
+			return http.StatusNotFound, errTemplateNotFound
+		}
+		delete(specs, name)
+		return http.StatusOK, nil
+	})
+}
+
+func patchSpec(spec *config.TemplateSpec, patch any) (*config.TemplateSpec, error) {
+	bs, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(bs, &doc); err != nil {
+		return nil, err
+	}
+	bs, err = json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return nil, err
+	}
+	var patched config.TemplateSpec
+	if err := json.Unmarshal(bs, &patched); err != nil {
+		return nil, fmt.Errorf("invalid patch:%w", err)
+	}
+	return &patched, nil
+}
+
+type updateFunc func(specs map[string]*config.TemplateSpec, name string) (status int, err error)
+
+// updateTemplate applies update to the config on disk,
+// validates and persists it in its original format and
+// reloads the updated template of the running agent,
+// templates of config fragments are read-only
+func (p *Proc) updateTemplate(writer http.ResponseWriter, name string, update updateFunc) {
+	if p.ConfigPath == "" {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": "config path is not known"})
+		return
+	}
+
+	p.configMU.Lock()
+	defer p.configMU.Unlock()
+
+	conf, owners, err := config.ReadWithFragments(p.ConfigPath)
+	if err != nil {
+		writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if file, ok := owners[name]; ok {
+		writeJSON(writer, http.StatusConflict, map[string]string{
+			"error": fmt.Sprintf("template %s is defined in %s", name, file),
+		})
+		return
+	}
+	if conf.TemplateSpecs == nil {
+		conf.TemplateSpecs = make(map[string]*config.TemplateSpec)
+	}
+
+	status, err := update(conf.TemplateSpecs, name)
+	if err != nil {
+		writeJSON(writer, status, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := config.Validate(&conf); err != nil {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := config.WriteFile(p.ConfigPath, withoutFragments(conf, owners)); err != nil {
+		writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	p.Logger.Info("config updated", slog.String("templ", name))
+
+	if reloader, ok := p.Agent.(TemplateReloader); ok {
+		if err := reloader.ReloadTemplate(conf, name); err != nil {
+			writeJSON(writer, http.StatusInternalServerError, map[string]string{
+				"error": fmt.Sprintf("config saved, reload failed:%s", err.Error()),
+			})
+			return
+		}
+	}
+	writeJSON(writer, status, map[string]bool{"success": true})
+}
+
+// withoutFragments returns conf without
+// the templates owned by fragments
+func withoutFragments(conf config.TPLAgent, owners map[string]string) config.TPLAgent {
+	if len(owners) == 0 {
+		return conf
+	}
+	specs := make(map[string]*config.TemplateSpec, len(conf.TemplateSpecs))
+	for name, spec := range conf.TemplateSpecs {
+		if _, ok := owners[name]; !ok {
+			specs[name] = spec
+		}
+	}
+	conf.TemplateSpecs = specs
+	return conf
+}
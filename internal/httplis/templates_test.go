@@ -0,0 +1,218 @@
+package httplis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shubhang93/tplagent/internal/config"
+	"github.com/shubhang93/tplagent/internal/duration"
+)
+
+type fakeReloader struct {
+	fakeAgent
+	reloaded []string
+}
+
+func (f *fakeReloader) ReloadTemplate(_ config.TPLAgent, name string) error {
+	f.reloaded = append(f.reloaded, name)
+	return nil
+}
+
+const templatesConfig = `{
+  "agent": {
+    "log_fmt": "text",
+    "log_level": "INFO"
+  },
+  "templates": {
+    "app-conf": {
+      "raw": "hello",
+      "destination": "/tmp/app.conf",
+      "refresh_interval": "10s",
+      "exec": {
+        "cmd": "systemctl",
+        "cmd_args": ["reload", "app"],
+        "env": {"DB_PASSWORD": "hunter2"}
+      },
+      "actions": [
+        {"name": "httpjson", "config": {"base_url": "http://localhost", "auth_token": "abc"}}
+      ]
+    }
+  }
+}`
+
+func TestTemplateEndpoints(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(templatesConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	agent := &fakeReloader{}
+	p := Proc{Logger: newLogger(), Agent: agent, ConfigPath: configPath}
+
+	do := func(handler http.HandlerFunc, method string, name string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/templates/"+name, strings.NewReader(body))
+		req.SetPathValue("name", name)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		method     string
+		templ      string
+		body       string
+		wantStatus int
+	}{
+		{name: "add", handler: p.putTemplate, method: http.MethodPut, templ: "db-conf",
+			body: `{"raw":"db","destination":"/tmp/db.conf"}`, wantStatus: http.StatusCreated},
+		{name: "replace", handler: p.putTemplate, method: http.MethodPut, templ: "db-conf",
+			body: `{"raw":"db v2","destination":"/tmp/db.conf"}`, wantStatus: http.StatusOK},
+		{name: "invalid spec", handler: p.putTemplate, method: http.MethodPut, templ: "db-conf",
+			body: `{"destination":"/tmp/db.conf"}`, wantStatus: http.StatusBadRequest},
+		{name: "patch", handler: p.patchTemplate, method: http.MethodPatch, templ: "app-conf",
+			body: `{"refresh_interval":"30s","exec":{"cmd_args":["restart","app"]},"actions":null}`, wantStatus: http.StatusOK},
+		{name: "patch missing", handler: p.patchTemplate, method: http.MethodPatch, templ: "nope",
+			body: `{"raw":"x"}`, wantStatus: http.StatusNotFound},
+		{name: "delete", handler: p.deleteTemplate, method: http.MethodDelete, templ: "db-conf", wantStatus: http.StatusOK},
+		{name: "delete missing", handler: p.deleteTemplate, method: http.MethodDelete, templ: "db-conf", wantStatus: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		rec := do(tc.handler, tc.method, tc.templ, tc.body)
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s:expected status %d got %d:%s", tc.name, tc.wantStatus, rec.Code, rec.Body.String())
+		}
+	}
+
+	if diff := cmp.Diff([]string{"db-conf", "db-conf", "app-conf", "db-conf"}, agent.reloaded); diff != "" {
+		t.Errorf("reloaded (--Want ++Got):\n%s", diff)
+	}
+
+	conf, err := config.ReadFromFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conf.TemplateSpecs["db-conf"]; ok {
+		t.Error("expected db-conf to be deleted")
+	}
+	app := conf.TemplateSpecs["app-conf"]
+	if app.RefreshInterval != duration.Duration(30*time.Second) || len(app.Actions) != 0 {
+		t.Errorf("patch not applied %+v", app)
+	}
+	if diff := cmp.Diff([]string{"restart", "app"}, app.Exec.CmdArgs); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+	if app.Exec.Cmd != "systemctl" {
+		t.Errorf("expected cmd to be kept got %s", app.Exec.Cmd)
+	}
+}
+
+func TestTemplateEndpoints_fragments(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	mainConf := `{"agent":{"log_fmt":"text","include_dirs":["conf.d"]},"templates":{}}`
+	if err := os.WriteFile(configPath, []byte(mainConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "conf.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fragPath := filepath.Join(dir, "conf.d", "db.yaml")
+	frag := "templates:\n  db-conf:\n    raw: db\n    destination: /tmp/db.conf\n"
+	if err := os.WriteFile(fragPath, []byte(frag), 0644); err != nil {
+		t.Fatal(err)
+	}
+	agent := &fakeReloader{}
+	p := Proc{Logger: newLogger(), Agent: agent, ConfigPath: configPath}
+
+	req := httptest.NewRequest(http.MethodDelete, "/templates/db-conf", nil)
+	req.SetPathValue("name", "db-conf")
+	rec := httptest.NewRecorder()
+	p.deleteTemplate(rec, req)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), fragPath) {
+		t.Errorf("expected a conflict naming %s got %d:%s", fragPath, rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/templates/app-conf", strings.NewReader(`{"raw":"app","destination":"/tmp/app.conf"}`))
+	req.SetPathValue("name", "app-conf")
+	rec = httptest.NewRecorder()
+	p.putTemplate(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d got %d:%s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	conf, err := config.Read(mustOpen(t, configPath), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conf.TemplateSpecs["db-conf"]; ok {
+		t.Error("expected fragment templates to stay out of the main config")
+	}
+	if _, ok := conf.TemplateSpecs["app-conf"]; !ok {
+		t.Error("expected app-conf in the main config")
+	}
+}
+
+func mustOpen(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func TestGetConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(templatesConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := Proc{Logger: newLogger(), ConfigPath: configPath}
+
+	rec := httptest.NewRecorder()
+	p.getConfig(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", rec.Code)
+	}
+
+	var doc struct {
+		Templates map[string]struct {
+			Exec struct {
+				Env map[string]string `json:"env"`
+			} `json:"exec"`
+			Actions []struct {
+				Config map[string]string `json:"config"`
+			} `json:"actions"`
+		} `json:"templates"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	app := doc.Templates["app-conf"]
+	if diff := cmp.Diff(map[string]string{"DB_PASSWORD": redacted}, app.Exec.Env); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+	expected := map[string]string{"base_url": "http://localhost", "auth_token": redacted}
+	if diff := cmp.Diff(expected, app.Actions[0].Config); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
+
+func Test_mergePatch(t *testing.T) {
+	var target, patch any
+	_ = json.Unmarshal([]byte(`{"a":"b","c":{"d":"e","f":"g"}}`), &target)
+	_ = json.Unmarshal([]byte(`{"a":"z","c":{"f":null}}`), &patch)
+
+	bs, _ := json.Marshal(mergePatch(target, patch))
+	if diff := cmp.Diff(`{"a":"z","c":{"d":"e"}}`, string(bs)); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+}
@@ -0,0 +1,280 @@
+package outformat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	Pretty = "pretty"
+	Minify = "minify"
+)
+
+type parseFunc func(contents []byte) error
+
+type canonicalizeFunc func(contents []byte, style string) ([]byte, error)
+
+type format struct {
+	parse        parseFunc
+	canonicalize canonicalizeFunc
+	styles       map[string]struct{}
+}
+
+var formats = map[string]format{
+	"json": {
+		parse:        parseJSON,
+		canonicalize: canonicalJSON,
+		styles:       map[string]struct{}{Pretty: {}, Minify: {}},
+	},
+	"yaml": {
+		parse:        parseYAML,
+		canonicalize: canonicalYAML,
+		styles:       map[string]struct{}{Pretty: {}},
+	},
+	"toml": {
+		parse:        parseTOML,
+		canonicalize: canonicalTOML,
+		styles:       map[string]struct{}{Pretty: {}},
+	},
+	"env": {parse: parseEnv},
+	"ini": {parse: parseINI},
+}
+
+// InvalidOutput is returned when the rendered
+// output cannot be parsed in the expected format
+type InvalidOutput struct {
+	Format string
+	Err    error
+}
+
+func (i *InvalidOutput) Error() string {
+	return fmt.Sprintf("invalid %s output:%s", i.Format, i.Err.Error())
+}
+
+func (i *InvalidOutput) Unwrap() error {
+	return i.Err
+}
+
+// Checker parses the rendered output before it is
+// written and optionally canonicalizes it so that
+// whitespace and key order differences are ignored
+type Checker struct {
+	Format       string
+	Canonicalize string
+}
+
+// Validate reports whether the format and
+// canonicalize style combination is supported
+func Validate(name string, style string) error {
+	f, ok := formats[name]
+	if !ok {
+		return fmt.Errorf("unknown output format %s", name)
+	}
+	if style == "" {
+		return nil
+	}
+	if _, ok := f.styles[style]; !ok {
+		return fmt.Errorf("canonicalize %s is not supported for %s", style, name)
+	}
+	return nil
+}
+
+func (c Checker) Check(rendered []byte) ([]byte, error) {
+	f, ok := formats[c.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %s", c.Format)
+	}
+
+	if c.Canonicalize == "" {
+		if err := f.parse(rendered); err != nil {
+			return nil, &InvalidOutput{Format: c.Format, Err: err}
+		}
+		return rendered, nil
+	}
+
+	canonical, err := f.canonicalize(rendered, c.Canonicalize)
+	if err != nil {
+		return nil, &InvalidOutput{Format: c.Format, Err: err}
+	}
+	return canonical, nil
+}
+
+func decodeJSON(contents []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(contents))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("unexpected data after top-level value")
+	}
+	return v, nil
+}
+
+func parseJSON(contents []byte) error {
+	_, err := decodeJSON(contents)
+	return err
+}
+
+func canonicalJSON(contents []byte, style string) ([]byte, error) {
+	v, err := decodeJSON(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	var buff bytes.Buffer
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	if style == Pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if style == Minify {
+		return bytes.TrimRight(buff.Bytes(), "\n"), nil
+	}
+	return buff.Bytes(), nil
+}
+
+func parseYAML(contents []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		var n yaml.Node
+		err := dec.Decode(&n)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// canonicalYAML re-encodes every
+// document of a multi document stream
+func canonicalYAML(contents []byte, _ string) ([]byte, error) {
+	var buff bytes.Buffer
+	enc := yaml.NewEncoder(&buff)
+	enc.SetIndent(2)
+
+	dec := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		var v any
+		err := dec.Decode(&v)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func parseTOML(contents []byte) error {
+	var v map[string]any
+	_, err := toml.Decode(string(contents), &v)
+	return err
+}
+
+func canonicalTOML(contents []byte, _ string) ([]byte, error) {
+	var v map[string]any
+	if _, err := toml.Decode(string(contents), &v); err != nil {
+		return nil, err
+	}
+
+	var buff bytes.Buffer
+	if err := toml.NewEncoder(&buff).Encode(v); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+var envKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func parseEnv(contents []byte) error {
+	return scanLines(contents, func(line string) error {
+		if strings.HasPrefix(line, "#") {
+			return nil
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return errors.New("expected KEY=VALUE")
+		}
+		if !envKey.MatchString(key) {
+			return fmt.Errorf("invalid key %q", key)
+		}
+		return checkQuotes(value)
+	})
+}
+
+func checkQuotes(value string) error {
+	if value == "" {
+		return nil
+	}
+	quote := value[0]
+	if quote != '"' && quote != '\'' {
+		return nil
+	}
+	if len(value) < 2 || value[len(value)-1] != quote {
+		return fmt.Errorf("unterminated quoted value %s", value)
+	}
+	return nil
+}
+
+func parseINI(contents []byte) error {
+	return scanLines(contents, func(line string) error {
+		switch {
+		case strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"):
+			return nil
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") || len(line) < 3 {
+				return fmt.Errorf("invalid section %s", line)
+			}
+			return nil
+		}
+		key, _, ok := strings.Cut(line, "=")
+		if !ok {
+			return errors.New("expected key = value")
+		}
+		if strings.TrimSpace(key) == "" {
+			return errors.New("empty key")
+		}
+		return nil
+	})
+}
+
+// scanLines calls check for every non-blank
+// line, errors are annotated with the line number
+func scanLines(contents []byte, check func(line string) error) error {
+	sc := bufio.NewScanner(bytes.NewReader(contents))
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if err := check(line); err != nil {
+			return fmt.Errorf("line %d:%w", lineNum, err)
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,143 @@
+package outformat
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestChecker_Check(t *testing.T) {
+	type checkTest struct {
+		checker   Checker
+		rendered  string
+		expected  string
+		wantError bool
+	}
+
+	tests := map[string]checkTest{
+		"valid json is written as is": {
+			checker:  Checker{Format: "json"},
+			rendered: `{"b": 1,  "a": [1, 2]}`,
+			expected: `{"b": 1,  "a": [1, 2]}`,
+		},
+		"json with a stray comma": {
+			checker:   Checker{Format: "json"},
+			rendered:  `{"b": 1, "a": [1, 2],}`,
+			wantError: true,
+		},
+		"json with trailing data": {
+			checker:   Checker{Format: "json"},
+			rendered:  `{"a": 1} {"b": 2}`,
+			wantError: true,
+		},
+		"pretty json sorts keys": {
+			checker:  Checker{Format: "json", Canonicalize: Pretty},
+			rendered: `{"b": 1.50, "a": {"d": "<x>", "c": null}}`,
+			expected: `{
+  "a": {
+    "c": null,
+    "d": "<x>"
+  },
+  "b": 1.50
+}
+`,
+		},
+		"minified json": {
+			checker:  Checker{Format: "json", Canonicalize: Minify},
+			rendered: "{\n  \"b\": 1,\n  \"a\": [1, 2]\n}\n",
+			expected: `{"a":[1,2],"b":1}`,
+		},
+		"valid yaml": {
+			checker:  Checker{Format: "yaml"},
+			rendered: "port: 8080\nhosts:\n  - a\n  - b\n",
+			expected: "port: 8080\nhosts:\n  - a\n  - b\n",
+		},
+		"invalid yaml": {
+			checker:   Checker{Format: "yaml"},
+			rendered:  "port: 8080\n  hosts: [a\n",
+			wantError: true,
+		},
+		"pretty yaml sorts keys": {
+			checker:  Checker{Format: "yaml", Canonicalize: Pretty},
+			rendered: "port:    8080\nhosts: [a, b]\n",
+			expected: "hosts:\n  - a\n  - b\nport: 8080\n",
+		},
+		"pretty yaml keeps every document": {
+			checker:  Checker{Format: "yaml", Canonicalize: Pretty},
+			rendered: "a: 1\n---\nb: 2\n",
+			expected: "a: 1\n---\nb: 2\n",
+		},
+		"valid toml": {
+			checker:  Checker{Format: "toml"},
+			rendered: "title = \"app\"\n[server]\nport = 8080\n",
+			expected: "title = \"app\"\n[server]\nport = 8080\n",
+		},
+		"invalid toml": {
+			checker:   Checker{Format: "toml"},
+			rendered:  "title = \"app\n",
+			wantError: true,
+		},
+		"pretty toml": {
+			checker:  Checker{Format: "toml", Canonicalize: Pretty},
+			rendered: "[server]\nport=8080\n\ntitle=\"app\"\n",
+			expected: "[server]\n  port = 8080\n  title = \"app\"\n",
+		},
+		"valid env": {
+			checker:  Checker{Format: "env"},
+			rendered: "# comment\nexport NAME=foo\nSECRET=\"a b\"\n\nEMPTY=\n",
+			expected: "# comment\nexport NAME=foo\nSECRET=\"a b\"\n\nEMPTY=\n",
+		},
+		"env with an unterminated quote": {
+			checker:   Checker{Format: "env"},
+			rendered:  "NAME=foo\nSECRET=\"a b\n",
+			wantError: true,
+		},
+		"env with an invalid key": {
+			checker:   Checker{Format: "env"},
+			rendered:  "1NAME=foo\n",
+			wantError: true,
+		},
+		"valid ini": {
+			checker:  Checker{Format: "ini"},
+			rendered: "; comment\n[server]\nport = 8080\n",
+			expected: "; comment\n[server]\nport = 8080\n",
+		},
+		"ini without a key": {
+			checker:   Checker{Format: "ini"},
+			rendered:  "[server]\n= 8080\n",
+			wantError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.checker.Check([]byte(tc.rendered))
+			if tc.wantError {
+				invalid := &InvalidOutput{}
+				if !errors.As(err, &invalid) {
+					t.Errorf("expected %T got %v", invalid, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if diff := cmp.Diff(tc.expected, string(got)); diff != "" {
+				t.Errorf("(--Want ++Got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate("json", Minify); err != nil {
+		t.Error(err)
+	}
+	if err := Validate("xml", ""); err == nil {
+		t.Error("expected an error for unknown format")
+	}
+	if err := Validate("env", Pretty); err == nil {
+		t.Error("expected an error for unsupported canonicalize style")
+	}
+}
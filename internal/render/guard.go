@@ -0,0 +1,99 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// Guard refuses renders which look like the result
+// of a misbehaving data source, for example an empty
+// list returned by a backend during an outage
+type Guard struct {
+	MinSize          int
+	MaxShrinkPercent float64
+	Contains         []string
+	Matches          []*regexp.Regexp
+}
+
+// GuardViolation is returned when a render is
+// refused by the Guard, the destination is left untouched
+type GuardViolation struct {
+	Reason string
+}
+
+func (g *GuardViolation) Error() string {
+	return fmt.Sprintf("render refused:%s", g.Reason)
+}
+
+// check validates contents against the guard, old is
+// the current destination and is nil if it does not exist
+func (g *Guard) check(old []byte, contents []byte) error {
+	if g == nil {
+		return nil
+	}
+	if err := g.checkSize(len(old), len(contents)); err != nil {
+		return err
+	}
+	return g.checkContents(contents)
+}
+
+// checkSet validates the files of a multi output
+// render as a whole, old are the contents of the
+// files written by the previous render
+func (g *Guard) checkSet(old [][]byte, contents [][]byte) error {
+	if g == nil {
+		return nil
+	}
+
+	if len(old) > 0 && len(contents) == 0 {
+		return &GuardViolation{Reason: fmt.Sprintf("no files rendered, %d files exist", len(old))}
+	}
+	if g.MaxShrinkPercent > 0 && len(old) > len(contents) {
+		shrink := float64(len(old)-len(contents)) * 100 / float64(len(old))
+		if shrink > g.MaxShrinkPercent {
+			return &GuardViolation{Reason: fmt.Sprintf("file count shrunk by %.1f%% max allowed %.1f%%", shrink, g.MaxShrinkPercent)}
+		}
+	}
+
+	oldSize, size := 0, 0
+	for _, c := range old {
+		oldSize += len(c)
+	}
+	for _, c := range contents {
+		size += len(c)
+	}
+	if err := g.checkSize(oldSize, size); err != nil {
+		return err
+	}
+	return g.checkContents(bytes.Join(contents, []byte("\n")))
+}
+
+func (g *Guard) checkSize(oldSize int, size int) error {
+	if size < g.MinSize {
+		return &GuardViolation{Reason: fmt.Sprintf("size %d is less than min size %d", size, g.MinSize)}
+	}
+
+	if g.MaxShrinkPercent > 0 && oldSize > size {
+		shrink := float64(oldSize-size) * 100 / float64(oldSize)
+		if shrink > g.MaxShrinkPercent {
+			return &GuardViolation{Reason: fmt.Sprintf("shrunk by %.1f%% max allowed %.1f%%", shrink, g.MaxShrinkPercent)}
+		}
+	}
+	return nil
+}
+
+func (g *Guard) checkContents(contents []byte) error {
+	for _, s := range g.Contains {
+		if !bytes.Contains(contents, []byte(s)) {
+			return &GuardViolation{Reason: fmt.Sprintf("missing required content %q", s)}
+		}
+	}
+
+	for _, re := range g.Matches {
+		if !re.Match(contents) {
+			return &GuardViolation{Reason: fmt.Sprintf("no match for required pattern %q", re.String())}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ManagedBlock restricts rendering to the lines
+// between the Begin and End marker lines of the
+// destination, the rest of the file is left untouched
+type ManagedBlock struct {
+	Begin string
+	End   string
+}
+
+// splice returns the contents of dest with the
+// managed block replaced by block and the current
+// block, the markers are appended to the file and
+// the current block is nil if they are missing
+func (m ManagedBlock) splice(dest string, block []byte) ([]byte, []byte, error) {
+	old, err := os.ReadFile(dest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	block = terminateLine(block)
+
+	beginStart, beginEnd, found := findLine(old, 0, m.Begin)
+	if !found {
+		var buff bytes.Buffer
+		buff.Write(old)
+		if len(old) > 0 && old[len(old)-1] != '\n' {
+			buff.WriteByte('\n')
+		}
+		buff.WriteString(m.Begin)
+		buff.WriteByte('\n')
+		buff.Write(block)
+		buff.WriteString(m.End)
+		buff.WriteByte('\n')
+		return buff.Bytes(), nil, nil
+	}
+
+	endStart, _, found := findLine(old, beginEnd, m.End)
+	if !found {
+		return nil, nil, fmt.Errorf("managed block:found %q without %q at offset %d", m.Begin, m.End, beginStart)
+	}
+
+	var buff bytes.Buffer
+	buff.Write(old[:beginEnd])
+	if old[beginEnd-1] != '\n' {
+		buff.WriteByte('\n')
+	}
+	buff.Write(block)
+	buff.Write(old[endStart:])
+	return buff.Bytes(), old[beginEnd:endStart], nil
+}
+
+// terminateLine appends a newline
+// to block if it does not end with one
+func terminateLine(block []byte) []byte {
+	if len(block) > 0 && block[len(block)-1] != '\n' {
+		block = append(block, '\n')
+	}
+	return block
+}
+
+// findLine returns the offsets of the first line
+// equal to line starting at from, end includes the
+// trailing newline
+func findLine(contents []byte, from int, line string) (start int, end int, found bool) {
+	for start = from; start < len(contents); start = end {
+		end = len(contents)
+		if i := bytes.IndexByte(contents[start:], '\n'); i >= 0 {
+			end = start + i + 1
+		}
+		if string(bytes.TrimRight(contents[start:end], "\r\n")) == line {
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
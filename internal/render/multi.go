@@ -0,0 +1,301 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultManifestName = ".tplagent.manifest"
+
+// the random token keeps data from
+// forging markers which are not
+// rendered by FileMarker
+var fileMarkerPrefix = "\x00tplagent-file:" + markerToken() + ":"
+
+const fileMarkerSuffix = "\x00"
+
+func markerToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(fmt.Sprintf("multi output: marker token:%v", err))
+	}
+	return hex.EncodeToString(token)
+}
+
+type outputFile struct {
+	name     string
+	contents []byte
+}
+
+// FileMarker returns the marker which starts a new
+// output file in multi output mode, everything
+// rendered after the marker up to the next marker
+// is written to name inside the destination directory
+func FileMarker(name string) (string, error) {
+	if err := checkOutputName(name); err != nil {
+		return "", err
+	}
+	return fileMarkerPrefix + name + fileMarkerSuffix, nil
+}
+
+// checkOutputName rejects names escaping the destination,
+// names are checked again when read back from the render
+// and the manifest since data can contain markers too
+func checkOutputName(name string) error {
+	if !filepath.IsLocal(name) || strings.ContainsRune(name, 0) {
+		return fmt.Errorf("invalid output file name:%q", name)
+	}
+	return nil
+}
+
+func (s *Sink) renderFiles(staticData any) error {
+	s.destFileBytes.Reset()
+	if err := renderTempl(s.Templ, s.destFileBytes, staticData); err != nil {
+		return err
+	}
+
+	files, err := splitFiles(s.destFileBytes.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if err := ensureDestDirs(filepath.Join(s.WriteTo, s.manifestName())); err != nil {
+		return err
+	}
+
+	manifestPath := filepath.Join(s.WriteTo, s.manifestName())
+	previous, err := readManifest(manifestPath)
+	if err != nil {
+		return fmt.Errorf("error reading manifest:%w", err)
+	}
+
+	for i := range files {
+		checked, err := s.check(files[i].contents)
+		if err != nil {
+			return fmt.Errorf("%s:%w", files[i].name, err)
+		}
+		files[i].contents = checked
+	}
+
+	// the guard sees the whole set so an empty or shrunk
+	// render cannot remove the files written before
+	old, identical, err := readPrevious(s.WriteTo, previous, files)
+	if err != nil {
+		return err
+	}
+	if !identical {
+		contents := make([][]byte, 0, len(files))
+		for _, f := range files {
+			contents = append(contents, f.contents)
+		}
+		if err := s.activeGuard().checkSet(old, contents); err != nil {
+			return err
+		}
+	}
+
+	current := make([]string, 0, len(files))
+	for _, f := range files {
+		current = append(current, f.name)
+	}
+
+	staged, err := s.stageFiles(files)
+	defer func() {
+		for _, st := range staged {
+			_ = os.Remove(st.temp)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	changed := len(staged) > 0
+	for len(staged) > 0 {
+		st := staged[0]
+		if err := os.Rename(st.temp, st.dest); err != nil {
+			return fmt.Errorf("error writing %s:%w", st.name, err)
+		}
+		staged = staged[1:]
+	}
+
+	slices.Sort(current)
+	for _, name := range previous {
+		if _, found := slices.BinarySearch(current, name); found {
+			continue
+		}
+		err := os.Remove(filepath.Join(s.WriteTo, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing stale file %s:%w", name, err)
+		}
+		changed = true
+	}
+
+	if !slices.Equal(previous, current) {
+		manifest := strings.Join(current, "\n")
+		if err := atomicWriteDest(manifestPath, strings.NewReader(manifest), s.copyBuffer, mode, nil); err != nil {
+			return fmt.Errorf("error writing manifest:%w", err)
+		}
+	}
+
+	if !changed {
+		return ContentsIdentical
+	}
+
+	s.lastChange = Change{
+		OldHash:    s.lastChange.NewHash,
+		NewHash:    hash(s.destFileBytes.Bytes()),
+		RenderedAt: time.Now(),
+	}
+	return nil
+}
+
+type stagedFile struct {
+	name string
+	temp string
+	dest string
+}
+
+// stageFiles writes every changed file to a temp file
+// next to its destination, the files are renamed once
+// all of them are written so that a failed write
+// leaves the files of the previous render in place.
+// Backups are not kept, they are not listed in the
+// manifest and would be read by tools globbing the dir
+func (s *Sink) stageFiles(files []outputFile) ([]stagedFile, error) {
+	var staged []stagedFile
+	for _, f := range files {
+		dest := filepath.Join(s.WriteTo, f.name)
+		old, err := os.ReadFile(dest)
+		if err == nil && bytes.Equal(old, f.contents) {
+			continue
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return staged, fmt.Errorf("error reading %s:%w", f.name, err)
+		}
+		if err := ensureDestDirs(dest); err != nil {
+			return staged, err
+		}
+
+		temp := fmt.Sprintf("%s.%s", dest, tempFileExt)
+		tempFile, err := createWritableFile(temp, mode, nil)
+		if err != nil {
+			return staged, fmt.Errorf("error writing %s:%w", f.name, err)
+		}
+		staged = append(staged, stagedFile{name: f.name, temp: temp, dest: dest})
+		err = writeTempFile(tempFile, bytes.NewReader(f.contents), s.copyBuffer)
+		_ = tempFile.Close()
+		if err != nil {
+			return staged, fmt.Errorf("error writing %s:%w", f.name, err)
+		}
+	}
+	return staged, nil
+}
+
+func (s *Sink) manifestName() string {
+	if s.ManifestName == "" {
+		return defaultManifestName
+	}
+	return s.ManifestName
+}
+
+func splitFiles(rendered []byte) ([]outputFile, error) {
+	prefix := []byte(fileMarkerPrefix)
+	suffix := []byte(fileMarkerSuffix)
+
+	before, rest, found := bytes.Cut(rendered, prefix)
+	if len(bytes.TrimSpace(before)) > 0 {
+		return nil, errors.New("multi output: found content before the first file marker")
+	}
+
+	var files []outputFile
+	seen := map[string]struct{}{}
+	for found {
+		name, afterName, ok := bytes.Cut(rest, suffix)
+		if !ok {
+			return nil, errors.New("multi output: malformed file marker")
+		}
+
+		var contents []byte
+		contents, rest, found = bytes.Cut(afterName, prefix)
+
+		if err := checkOutputName(string(name)); err != nil {
+			return nil, fmt.Errorf("multi output: %w", err)
+		}
+		// markers are NUL delimited, NUL bytes
+		// left in the contents come from data
+		if bytes.IndexByte(contents, 0) >= 0 {
+			return nil, fmt.Errorf("multi output: file %s contains a NUL byte", name)
+		}
+
+		if _, ok := seen[string(name)]; ok {
+			return nil, fmt.Errorf("multi output: duplicate file %s", name)
+		}
+		seen[string(name)] = struct{}{}
+		files = append(files, outputFile{
+			name:     string(name),
+			contents: bytes.Clone(contents),
+		})
+	}
+	return files, nil
+}
+
+// readPrevious returns the contents of the files written
+// by the previous render and whether files is identical
+// to them, same names and same contents
+func readPrevious(dir string, previous []string, files []outputFile) ([][]byte, bool, error) {
+	identical := len(previous) == len(files)
+	rendered := make(map[string][]byte, len(files))
+	for _, f := range files {
+		rendered[f.name] = f.contents
+	}
+
+	old := make([][]byte, 0, len(previous))
+	for _, name := range previous {
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			identical = false
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading %s:%w", name, err)
+		}
+		old = append(old, contents)
+		if c, ok := rendered[name]; !ok || !bytes.Equal(c, contents) {
+			identical = false
+		}
+	}
+	return old, identical, nil
+}
+
+func readManifest(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name := sc.Text()
+		if name == "" {
+			continue
+		}
+		if err := checkOutputName(name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, sc.Err()
+}
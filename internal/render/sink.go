@@ -2,16 +2,24 @@ package render
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"syscall"
+	"time"
 )
 
 const tempFileExt = "temp"
 const bakFileExt = "bak"
 const mode = os.FileMode(0766)
+
+// sharedFileMode is used for managed block
+// destinations which do not exist yet
+const sharedFileMode = os.FileMode(0644)
 const copyBuffSize = 32 * 1024
 
 var ContentsIdentical = errors.New("identical contents")
@@ -20,11 +28,58 @@ type executableTemplate interface {
 	Execute(io.Writer, any) error
 }
 
+// Checker inspects the rendered contents before
+// they are written, the returned contents are
+// written instead of the rendered contents
+type Checker interface {
+	Check(rendered []byte) ([]byte, error)
+}
+
+// Change describes the last write of a Sink
+type Change struct {
+	// OldHash is empty if the destination did not
+	// exist, in multi output mode it is the hash
+	// of the previous render of the Sink
+	OldHash string
+	// NewHash is the sha256 of the destination,
+	// or of the whole output in multi output mode
+	NewHash string
+	// Backup is empty if nothing was backed up
+	Backup     string
+	RenderedAt time.Time
+}
+
 type Sink struct {
-	Templ         executableTemplate
-	WriteTo       string
+	Templ   executableTemplate
+	WriteTo string
+	// MultiOutput treats WriteTo as a directory,
+	// the rendered output is split into files
+	// using the markers returned by FileMarker
+	MultiOutput bool
+	// ManifestName is the name of the file inside
+	// WriteTo which tracks the files written in
+	// multi output mode, defaults to .tplagent.manifest
+	ManifestName string
+	// ManagedBlock replaces only the lines between
+	// its markers in WriteTo instead of the whole file
+	ManagedBlock *ManagedBlock
+	// Checker rejects or rewrites the rendered
+	// contents before they replace WriteTo
+	Checker Checker
+	// Guard refuses renders violating its
+	// thresholds, SkipGuard disables it to
+	// push such a render through intentionally
+	Guard         *Guard
+	SkipGuard     bool
 	destFileBytes *bytes.Buffer
 	copyBuffer    []byte
+	lastChange    Change
+}
+
+// LastChange returns the change made
+// by the last successful Render
+func (s *Sink) LastChange() Change {
+	return s.lastChange
 }
 
 func (s *Sink) Render(staticData any) error {
@@ -35,7 +90,18 @@ func (s *Sink) Render(staticData any) error {
 		s.destFileBytes.Reset()
 	}()
 
-	if err := ensureDestDirs(s.WriteTo); err != nil {
+	if s.MultiOutput {
+		return s.renderFiles(staticData)
+	}
+
+	if s.ManagedBlock != nil {
+		// managed blocks edit shared files like /etc/hosts,
+		// the perms of their directory are left as is
+		dir := filepath.Dir(s.WriteTo)
+		if err := os.MkdirAll(dir, mode); err != nil {
+			return fmt.Errorf("failed to create dir path:%s:%w", dir, err)
+		}
+	} else if err := ensureDestDirs(s.WriteTo); err != nil {
 		return err
 	}
 
@@ -44,35 +110,123 @@ func (s *Sink) Render(staticData any) error {
 		return err
 	}
 
-	oldFileContents, readErr := os.ReadFile(s.WriteTo)
+	contents, err := s.check(s.destFileBytes.Bytes())
+	if err != nil {
+		return err
+	}
+
+	guard := s.activeGuard()
+	if s.ManagedBlock != nil {
+		spliced, oldBlock, err := s.ManagedBlock.splice(s.WriteTo, contents)
+		if err != nil {
+			return err
+		}
+		// the guard only looks at the block, the
+		// rest of the file is not owned by the sink,
+		// an unchanged block is left to writeDest
+		// to report as identical contents
+		if oldBlock == nil || !bytes.Equal(oldBlock, terminateLine(contents)) {
+			if err := guard.check(oldBlock, contents); err != nil {
+				return err
+			}
+		}
+		guard = nil
+		contents = spliced
+	}
+
+	opts := writeOpts{guard: guard, shared: s.ManagedBlock != nil, backup: true}
+	old, err := writeDest(s.WriteTo, contents, s.copyBuffer, opts)
+	if err != nil {
+		return err
+	}
+
+	change := Change{NewHash: hash(contents), RenderedAt: time.Now()}
+	if old != nil {
+		change.OldHash = hash(old)
+		change.Backup = backupName(s.WriteTo)
+	}
+	s.lastChange = change
+	return nil
+}
+
+func hash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Sink) activeGuard() *Guard {
+	if s.SkipGuard {
+		return nil
+	}
+	return s.Guard
+}
+
+type writeOpts struct {
+	guard *Guard
+	// shared destinations keep their mode and owner
+	shared bool
+	// backup keeps the old contents in dest.bak
+	backup bool
+}
+
+// writeDest atomically replaces the contents of dest
+// after optionally backing up the old contents,
+// ContentsIdentical is returned when dest already has
+// the same contents, the old contents are nil if dest
+// did not exist
+func writeDest(dest string, contents []byte, copyBuff []byte, opts writeOpts) ([]byte, error) {
+	perm := mode
+	var owner os.FileInfo
+	if opts.shared {
+		perm = sharedFileMode
+		if fi, err := os.Stat(dest); err == nil {
+			perm, owner = fi.Mode().Perm(), fi
+		}
+	}
+
+	oldFileContents, readErr := os.ReadFile(dest)
 	switch {
 	case readErr == nil:
-		if res := bytes.Compare(oldFileContents, s.destFileBytes.Bytes()); res == 0 {
-			return ContentsIdentical
+		if res := bytes.Compare(oldFileContents, contents); res == 0 {
+			return nil, ContentsIdentical
+		}
+
+		if err := opts.guard.check(oldFileContents, contents); err != nil {
+			return nil, err
 		}
 
-		if err := atomicBackup(s.WriteTo, bytes.NewReader(oldFileContents), s.copyBuffer); err != nil {
-			return fmt.Errorf("backup failed:%w", err)
+		if opts.backup {
+			if err := atomicBackup(dest, bytes.NewReader(oldFileContents), copyBuff, perm, owner); err != nil {
+				return nil, fmt.Errorf("backup failed:%w", err)
+			}
 		}
 
-		if err := atomicWriteDest(s.WriteTo, s.destFileBytes, s.copyBuffer); err != nil {
-			return fmt.Errorf("atomic write failed:%w", err)
+		if err := atomicWriteDest(dest, bytes.NewReader(contents), copyBuff, perm, owner); err != nil {
+			return nil, fmt.Errorf("atomic write failed:%w", err)
 		}
+		return oldFileContents, nil
 
 	case errors.Is(readErr, os.ErrNotExist):
-		if err := atomicWriteDest(s.WriteTo, s.destFileBytes, s.copyBuffer); err != nil {
-			return fmt.Errorf("atomic write failed:%w", err)
+		if err := opts.guard.check(nil, contents); err != nil {
+			return nil, err
+		}
+		if err := atomicWriteDest(dest, bytes.NewReader(contents), copyBuff, perm, owner); err != nil {
+			return nil, fmt.Errorf("atomic write failed:%w", err)
 		}
+		return nil, nil
 	default:
-		return readErr
+		return nil, readErr
 
 	}
-	return nil
 }
 
-func atomicBackup(dest string, contents io.Reader, copyBuff []byte) error {
-	bakFilename := fmt.Sprintf("%s.%s", dest, bakFileExt)
-	bakFile, err := createWritableFile(bakFilename)
+func backupName(dest string) string {
+	return fmt.Sprintf("%s.%s", dest, bakFileExt)
+}
+
+func atomicBackup(dest string, contents io.Reader, copyBuff []byte, perm os.FileMode, owner os.FileInfo) error {
+	bakFilename := backupName(dest)
+	bakFile, err := createWritableFile(bakFilename, perm, owner)
 	if err != nil {
 		return err
 	}
@@ -87,9 +241,9 @@ func atomicBackup(dest string, contents io.Reader, copyBuff []byte) error {
 	return nil
 }
 
-func atomicWriteDest(dest string, contents io.Reader, copyBuff []byte) error {
+func atomicWriteDest(dest string, contents io.Reader, copyBuff []byte, perm os.FileMode, owner os.FileInfo) error {
 	tempFileName := fmt.Sprintf("%s.%s", dest, tempFileExt)
-	tempFile, err := createWritableFile(tempFileName)
+	tempFile, err := createWritableFile(tempFileName, perm, owner)
 	if err != nil {
 		return err
 	}
@@ -114,6 +268,13 @@ func writeTempFile(tempFile *os.File, contents io.Reader, buff []byte) error {
 	return nil
 }
 
+func (s *Sink) check(rendered []byte) ([]byte, error) {
+	if s.Checker == nil {
+		return rendered, nil
+	}
+	return s.Checker.Check(rendered)
+}
+
 func (s *Sink) init() {
 	if s.destFileBytes == nil {
 		s.destFileBytes = &bytes.Buffer{}
@@ -138,17 +299,42 @@ func ensureDestDirs(filename string) error {
 	return nil
 }
 
-func createWritableFile(filename string) (*os.File, error) {
-	fi, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+// createWritableFile creates filename with perm,
+// the file is given the owner of owner if not nil
+func createWritableFile(filename string, perm os.FileMode, owner os.FileInfo) (*os.File, error) {
+	fi, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
 	if err != nil {
 		return nil, err
 	}
-	if err := os.Chmod(fi.Name(), mode); err != nil {
+	if err := os.Chmod(fi.Name(), perm); err != nil {
+		_ = fi.Close()
+		return nil, err
+	}
+	if err := chownLike(fi, owner); err != nil {
+		_ = fi.Close()
 		return nil, err
 	}
 	return fi, nil
 }
 
+func chownLike(f *os.File, owner os.FileInfo) error {
+	if owner == nil {
+		return nil
+	}
+	st, ok := owner.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	created, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if cst, ok := created.Sys().(*syscall.Stat_t); ok && cst.Uid == st.Uid && cst.Gid == st.Gid {
+		return nil
+	}
+	return f.Chown(int(st.Uid), int(st.Gid))
+}
+
 func renderTempl(t executableTemplate, wr io.Writer, staticData any) error {
 	if err := t.Execute(wr, staticData); err != nil {
 		return fmt.Errorf("error writing dest file:%w", err)
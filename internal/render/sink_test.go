@@ -1,10 +1,14 @@
 package render
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"github.com/google/go-cmp/cmp"
 	"io"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
 	"text/template"
 	"time"
@@ -237,3 +241,542 @@ type mockTpl struct{}
 func (m mockTpl) Execute(_ io.Writer, a any) error {
 	return nil
 }
+
+func TestSink_RenderMultiOutput(t *testing.T) {
+	funcs := template.FuncMap{"file": FileMarker}
+	tmpl := template.Must(template.New("test").Funcs(funcs).Parse(`{{range .}}{{file (printf "%s.conf" .)}}server {{.}};
+{{end}}`))
+
+	readDir := func(t *testing.T, dir string) map[string]string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{}
+		for _, e := range entries {
+			bs, err := os.ReadFile(dir + "/" + e.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[e.Name()] = string(bs)
+		}
+		return files
+	}
+
+	tmp := t.TempDir()
+	dest := tmp + "/conf.d"
+	if err := os.MkdirAll(dest, mode); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.WriteFile(dest+"/unmanaged.conf", []byte("unmanaged"), mode); err != nil {
+		t.Error(err)
+		return
+	}
+
+	s := Sink{Templ: tmpl, WriteTo: dest, MultiOutput: true}
+	if err := s.Render([]string{"foo", "bar"}); err != nil {
+		t.Errorf("render error:%v", err)
+		return
+	}
+
+	expected := map[string]string{
+		"foo.conf":           "server foo;\n",
+		"bar.conf":           "server bar;\n",
+		"unmanaged.conf":     "unmanaged",
+		".tplagent.manifest": "bar.conf\nfoo.conf",
+	}
+	if diff := cmp.Diff(expected, readDir(t, dest)); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+		return
+	}
+
+	if err := s.Render([]string{"foo", "bar"}); !errors.Is(err, ContentsIdentical) {
+		t.Errorf("expected error to be %v got %v", ContentsIdentical, err)
+		return
+	}
+
+	if err := s.Render([]string{"foo"}); err != nil {
+		t.Errorf("render error:%v", err)
+		return
+	}
+	expected = map[string]string{
+		"foo.conf":           "server foo;\n",
+		"unmanaged.conf":     "unmanaged",
+		".tplagent.manifest": "foo.conf",
+	}
+	if diff := cmp.Diff(expected, readDir(t, dest)); diff != "" {
+		t.Errorf("(--Want ++Got):\n%s", diff)
+	}
+
+	t.Run("changed files are not backed up", func(t *testing.T) {
+		dest := t.TempDir()
+		tmpl := template.Must(template.New("test").Funcs(funcs).Parse(`{{file "app.conf"}}version {{.}}`))
+		s := Sink{Templ: tmpl, WriteTo: dest, MultiOutput: true}
+		for _, version := range []string{"1", "2"} {
+			if err := s.Render(version); err != nil {
+				t.Fatalf("render error:%v", err)
+			}
+		}
+		expected := map[string]string{
+			"app.conf":           "version 2",
+			".tplagent.manifest": "app.conf",
+		}
+		if diff := cmp.Diff(expected, readDir(t, dest)); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
+	t.Run("content outside file markers", func(t *testing.T) {
+		s := Sink{
+			Templ:       template.Must(template.New("test").Funcs(funcs).Parse(`stray{{file "a.conf"}}a`)),
+			WriteTo:     t.TempDir(),
+			MultiOutput: true,
+		}
+		if err := s.Render(nil); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("guard sees the whole set", func(t *testing.T) {
+		dest := t.TempDir()
+		s := Sink{Templ: tmpl, WriteTo: dest, MultiOutput: true, Guard: &Guard{MinSize: 5, MaxShrinkPercent: 10}}
+		if err := s.Render([]string{"foo", "bar"}); err != nil {
+			t.Fatalf("render error:%v", err)
+		}
+		if err := s.Render([]string{"foo", "bar"}); !errors.Is(err, ContentsIdentical) {
+			t.Errorf("expected error to be %v got %v", ContentsIdentical, err)
+		}
+
+		for _, data := range [][]string{nil, {"foo"}} {
+			violation := &GuardViolation{}
+			if err := s.Render(data); !errors.As(err, &violation) {
+				t.Errorf("%v:expected a guard violation got %v", data, err)
+			}
+		}
+		expected := map[string]string{
+			"foo.conf":           "server foo;\n",
+			"bar.conf":           "server bar;\n",
+			".tplagent.manifest": "bar.conf\nfoo.conf",
+		}
+		if diff := cmp.Diff(expected, readDir(t, dest)); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
+	t.Run("file names escaping the destination", func(t *testing.T) {
+		if _, err := FileMarker("../passwd"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("markers injected by data", func(t *testing.T) {
+		root := t.TempDir()
+		dest := root + "/conf.d"
+		s := Sink{Templ: tmpl, WriteTo: dest, MultiOutput: true}
+		injected := "foo;\n" + fileMarkerPrefix + "../escaped.conf" + fileMarkerSuffix + "evil"
+		if err := s.Render([]string{injected}); err == nil {
+			t.Error("expected an error")
+		}
+		if _, err := os.Stat(root + "/escaped.conf"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no file outside the destination got %v", err)
+		}
+	})
+
+	t.Run("markers forged by data", func(t *testing.T) {
+		dest := t.TempDir() + "/conf.d"
+		s := Sink{Templ: tmpl, WriteTo: dest, MultiOutput: true}
+		forged := "foo;\n\x00tplagent-file:other.conf\x00evil"
+		if err := s.Render([]string{forged}); err == nil {
+			t.Error("expected an error")
+		}
+		if _, err := os.Stat(dest + "/other.conf"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected no forged file got %v", err)
+		}
+	})
+
+	t.Run("failed write keeps the previous render", func(t *testing.T) {
+		setTmpl := template.Must(template.New("test").Funcs(funcs).Parse(`{{range $name, $v := .}}{{file $name}}{{$v}}{{end}}`))
+		dest := t.TempDir() + "/conf.d"
+		s := Sink{Templ: setTmpl, WriteTo: dest, MultiOutput: true}
+		if err := s.Render(map[string]string{"a.conf": "1", "b.conf": "1"}); err != nil {
+			t.Fatalf("render error:%v", err)
+		}
+		// the temp file of b.conf cannot be created
+		if err := os.Mkdir(dest+"/b.conf."+tempFileExt, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Render(map[string]string{"a.conf": "2", "b.conf": "2", "c.conf": "2"}); err == nil {
+			t.Fatal("expected an error")
+		}
+
+		expected := map[string]string{
+			"a.conf":             "1",
+			"b.conf":             "1",
+			".tplagent.manifest": "a.conf\nb.conf",
+		}
+		if err := os.Remove(dest + "/b.conf." + tempFileExt); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, readDir(t, dest)); diff != "" {
+			t.Errorf("(--Want ++Got):\n%s", diff)
+		}
+	})
+
+	t.Run("manifest entries escaping the destination", func(t *testing.T) {
+		root := t.TempDir()
+		dest := root + "/conf.d"
+		victim := root + "/victim.conf"
+		if err := os.WriteFile(victim, []byte("keep"), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(dest, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dest+"/"+defaultManifestName, []byte("../victim.conf\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+		s := Sink{Templ: tmpl, WriteTo: dest, MultiOutput: true}
+		if err := s.Render([]string{"foo"}); err == nil {
+			t.Error("expected an error")
+		}
+		if _, err := os.Stat(victim); err != nil {
+			t.Errorf("expected %s to be kept got %v", victim, err)
+		}
+	})
+}
+
+func TestSink_RenderManagedBlock(t *testing.T) {
+	block := &ManagedBlock{Begin: "# BEGIN tplagent", End: "# END tplagent"}
+	hostsTmpl := template.Must(template.New("test").Parse(`{{range .}}{{.}}
+{{end}}`))
+
+	tests := map[string]struct {
+		existing string
+		data     []string
+		expected string
+	}{
+		"markers are appended when missing": {
+			existing: "127.0.0.1 localhost",
+			data:     []string{"10.0.0.1 api"},
+			expected: `127.0.0.1 localhost
+# BEGIN tplagent
+10.0.0.1 api
+# END tplagent
+`,
+		},
+		"only the managed block is replaced": {
+			existing: `127.0.0.1 localhost
+# BEGIN tplagent
+10.0.0.1 api
+# END tplagent
+::1 localhost
+`,
+			data: []string{"10.0.0.2 api", "10.0.0.3 db"},
+			expected: `127.0.0.1 localhost
+# BEGIN tplagent
+10.0.0.2 api
+10.0.0.3 db
+# END tplagent
+::1 localhost
+`,
+		},
+		"dest does not exist": {
+			data: []string{"10.0.0.1 api"},
+			expected: `# BEGIN tplagent
+10.0.0.1 api
+# END tplagent
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dest := t.TempDir() + "/hosts"
+			if tc.existing != "" {
+				if err := os.WriteFile(dest, []byte(tc.existing), mode); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+
+			s := Sink{Templ: hostsTmpl, WriteTo: dest, ManagedBlock: block}
+			if err := s.Render(tc.data); err != nil {
+				t.Errorf("render error:%v", err)
+				return
+			}
+
+			bs, err := os.ReadFile(dest)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if diff := cmp.Diff(tc.expected, string(bs)); diff != "" {
+				t.Errorf("(--Want ++Got):\n%s", diff)
+				return
+			}
+
+			if err := s.Render(tc.data); !errors.Is(err, ContentsIdentical) {
+				t.Errorf("expected error to be %v got %v", ContentsIdentical, err)
+			}
+		})
+	}
+
+	t.Run("END marker is missing", func(t *testing.T) {
+		dest := t.TempDir() + "/hosts"
+		if err := os.WriteFile(dest, []byte("# BEGIN tplagent\n10.0.0.1 api\n"), mode); err != nil {
+			t.Error(err)
+			return
+		}
+		s := Sink{Templ: hostsTmpl, WriteTo: dest, ManagedBlock: block}
+		if err := s.Render([]string{"10.0.0.2 api"}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("file and dir perms are kept", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.Chmod(dir, 0755); err != nil {
+			t.Error(err)
+			return
+		}
+		dest := dir + "/hosts"
+		if err := os.WriteFile(dest, []byte("127.0.0.1 localhost\n"), 0640); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := os.Chmod(dest, 0640); err != nil {
+			t.Error(err)
+			return
+		}
+
+		s := Sink{Templ: hostsTmpl, WriteTo: dest, ManagedBlock: block}
+		if err := s.Render([]string{"10.0.0.1 api"}); err != nil {
+			t.Errorf("render error:%v", err)
+			return
+		}
+
+		perms := map[string]os.FileMode{dir: 0755, dest: 0640, backupName(dest): 0640}
+		for path, want := range perms {
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if got := fi.Mode().Perm(); got != want {
+				t.Errorf("%s: expected mode %v got %v", path, want, got)
+			}
+		}
+	})
+}
+
+type upperChecker struct{}
+
+func (upperChecker) Check(rendered []byte) ([]byte, error) {
+	if bytes.Contains(rendered, []byte("invalid")) {
+		return nil, errors.New("invalid contents")
+	}
+	return bytes.ToUpper(rendered), nil
+}
+
+func TestSink_RenderChecker(t *testing.T) {
+	dest := t.TempDir() + "/test.render"
+	s := Sink{Templ: testTmpl, WriteTo: dest, Checker: upperChecker{}}
+
+	if err := s.Render(staticData{Name: "foo"}); err != nil {
+		t.Errorf("render error:%v", err)
+		return
+	}
+
+	if err := s.Render(staticData{Name: "invalid"}); err == nil {
+		t.Error("expected an error")
+		return
+	}
+
+	bs, err := os.ReadFile(dest)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if expected := "NAME: FOO"; expected != string(bs) {
+		t.Errorf("expected %s got %s", expected, string(bs))
+	}
+}
+
+func TestSink_RenderGuard(t *testing.T) {
+	listTmpl := template.Must(template.New("test").Parse(`{{range .}}server {{.}};
+{{end}}`))
+	existing := "server a;\nserver b;\nserver c;\nserver d;\n"
+
+	tests := map[string]struct {
+		guard     *Guard
+		data      []string
+		skipGuard bool
+		wantErr   bool
+	}{
+		"empty render is refused": {
+			guard:   &Guard{MinSize: 1},
+			data:    nil,
+			wantErr: true,
+		},
+		"shrink above the threshold is refused": {
+			guard:   &Guard{MaxShrinkPercent: 50},
+			data:    []string{"a"},
+			wantErr: true,
+		},
+		"shrink below the threshold is allowed": {
+			guard: &Guard{MaxShrinkPercent: 50},
+			data:  []string{"a", "b", "c"},
+		},
+		"required content is missing": {
+			guard:   &Guard{Contains: []string{"server a;"}},
+			data:    []string{"b"},
+			wantErr: true,
+		},
+		"required pattern is missing": {
+			guard:   &Guard{Matches: []*regexp.Regexp{regexp.MustCompile(`(?m)^server \w+;$`)}},
+			data:    []string{"a b"},
+			wantErr: true,
+		},
+		"skip guard pushes the render through": {
+			guard:     &Guard{MinSize: 1},
+			data:      nil,
+			skipGuard: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dest := t.TempDir() + "/upstreams.conf"
+			if err := os.WriteFile(dest, []byte(existing), mode); err != nil {
+				t.Error(err)
+				return
+			}
+
+			s := Sink{Templ: listTmpl, WriteTo: dest, Guard: tc.guard, SkipGuard: tc.skipGuard}
+			err := s.Render(tc.data)
+
+			violation := &GuardViolation{}
+			if tc.wantErr != errors.As(err, &violation) {
+				t.Errorf("expected guard violation:%t got %v", tc.wantErr, err)
+				return
+			}
+			if !tc.wantErr && err != nil {
+				t.Error(err)
+				return
+			}
+
+			bs, err := os.ReadFile(dest)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if tc.wantErr && string(bs) != existing {
+				t.Errorf("destination was modified:%s", string(bs))
+			}
+		})
+	}
+}
+
+func TestSink_RenderManagedBlockGuard(t *testing.T) {
+	block := &ManagedBlock{Begin: "# BEGIN tplagent", End: "# END tplagent"}
+	hostsTmpl := template.Must(template.New("test").Parse(`{{range .}}{{.}}
+{{end}}`))
+	managed := "# BEGIN tplagent\n10.0.0.1 api\n10.0.0.2 db\n10.0.0.3 cache\n10.0.0.4 queue\n# END tplagent\n"
+
+	tests := map[string]struct {
+		host    string
+		guard   *Guard
+		data    []string
+		wantErr bool
+	}{
+		"block shrink is refused in a large file": {
+			host:    strings.Repeat("127.0.0.1 localhost\n", 100),
+			guard:   &Guard{MaxShrinkPercent: 50},
+			data:    nil,
+			wantErr: true,
+		},
+		"host content does not count towards min size": {
+			host:    strings.Repeat("127.0.0.1 localhost\n", 100),
+			guard:   &Guard{MinSize: 1},
+			data:    nil,
+			wantErr: true,
+		},
+		"small block change in a small file is allowed": {
+			host:  "",
+			guard: &Guard{MaxShrinkPercent: 50, MinSize: 1},
+			data:  []string{"10.0.0.1 api", "10.0.0.2 db", "10.0.0.3 cache"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dest := t.TempDir() + "/hosts"
+			existing := tc.host + managed
+			if err := os.WriteFile(dest, []byte(existing), mode); err != nil {
+				t.Error(err)
+				return
+			}
+
+			s := Sink{Templ: hostsTmpl, WriteTo: dest, ManagedBlock: block, Guard: tc.guard}
+			err := s.Render(tc.data)
+
+			violation := &GuardViolation{}
+			if tc.wantErr != errors.As(err, &violation) {
+				t.Errorf("expected guard violation:%t got %v", tc.wantErr, err)
+				return
+			}
+			if !tc.wantErr && err != nil {
+				t.Error(err)
+				return
+			}
+
+			bs, err := os.ReadFile(dest)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if tc.wantErr && string(bs) != existing {
+				t.Errorf("destination was modified:%s", string(bs))
+			}
+			if !tc.wantErr {
+				if err := s.Render(tc.data); !errors.Is(err, ContentsIdentical) {
+					t.Errorf("expected error to be %v got %v", ContentsIdentical, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSink_LastChange(t *testing.T) {
+	dest := t.TempDir() + "/out.conf"
+	sink := Sink{Templ: testTmpl, WriteTo: dest}
+
+	if err := sink.Render(staticData{Name: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	first := sink.LastChange()
+	if first.OldHash != "" || first.Backup != "" {
+		t.Errorf("expected no old hash and backup for a new file got %+v", first)
+	}
+	if first.NewHash != hash([]byte("Name: foo")) {
+		t.Errorf("unexpected new hash %s", first.NewHash)
+	}
+
+	if err := sink.Render(staticData{Name: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	second := sink.LastChange()
+	expected := Change{
+		OldHash:    first.NewHash,
+		NewHash:    hash([]byte("Name: bar")),
+		Backup:     dest + ".bak",
+		RenderedAt: second.RenderedAt,
+	}
+	if diff := cmp.Diff(expected, second); diff != "" {
+		t.Error(diff)
+	}
+	if second.RenderedAt.Before(first.RenderedAt) {
+		t.Errorf("expected rendered at to move forward")
+	}
+}
@@ -0,0 +1,195 @@
+// Package supervise runs a single child process
+// on behalf of the agent, signals received by the
+// agent are forwarded to it and template changes
+// are propagated by signalling or restarting it
+package supervise
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultKillTimeout = 10 * time.Second
+
+type Child struct {
+	Cmd  string
+	Args []string
+	// EnvFile is a KEY=VALUE file merged into
+	// the environment of the child, it is read
+	// again every time the child is (re)started
+	EnvFile string
+	// ChangeSignal is sent to the child when
+	// a template changes, the child is restarted
+	// instead when it is 0
+	ChangeSignal syscall.Signal
+	// KillSignal stops the child, SIGKILL follows
+	// if it is still running after KillTimeout
+	KillSignal  syscall.Signal
+	KillTimeout time.Duration
+	Stdin       io.Reader
+	Stdout      io.Writer
+	Stderr      io.Writer
+	Logger      *slog.Logger
+
+	initOnce sync.Once
+	changed  chan struct{}
+	mu       sync.Mutex
+	restart  bool
+}
+
+type running struct {
+	cmd    *exec.Cmd
+	exited chan *os.ProcessState
+}
+
+func (c *Child) init() {
+	c.initOnce.Do(func() {
+		c.changed = make(chan struct{}, 1)
+	})
+}
+
+// Changed notifies the child of a template
+// change, restart forces a restart even
+// if ChangeSignal is set, notifications
+// are coalesced until they are handled
+func (c *Child) Changed(restart bool) {
+	c.init()
+	c.mu.Lock()
+	c.restart = c.restart || restart
+	c.mu.Unlock()
+
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Child) takeRestart() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	restart := c.restart
+	c.restart = false
+	return restart
+}
+
+// Run starts the child and supervises it until it
+// exits or ctx is done, signals received on sigs are
+// forwarded to the child, the exit code of the child
+// is returned, 128+signal if it was killed by a signal
+func (c *Child) Run(ctx context.Context, sigs <-chan os.Signal) (int, error) {
+	c.init()
+
+	proc, err := c.start()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		select {
+		case sig := <-sigs:
+			c.Logger.Debug("forwarding signal", slog.String("signal", sig.String()))
+			_ = proc.cmd.Process.Signal(sig)
+		case <-c.changed:
+			if !c.takeRestart() && c.ChangeSignal != 0 {
+				c.Logger.Info("templates changed, signalling child", slog.String("signal", c.ChangeSignal.String()))
+				if err := proc.cmd.Process.Signal(c.ChangeSignal); err != nil {
+					c.Logger.Error("signal child error", slog.String("error", err.Error()))
+				}
+				continue
+			}
+			c.Logger.Info("templates changed, restarting child")
+			c.stop(proc)
+			proc, err = c.start()
+			if err != nil {
+				return 0, err
+			}
+		case state := <-proc.exited:
+			return exitCode(state), nil
+		case <-ctx.Done():
+			return exitCode(c.stop(proc)), nil
+		}
+	}
+}
+
+func (c *Child) start() (*running, error) {
+	env, err := c.environ()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(c.Cmd, c.Args...)
+	cmd.Env = env
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	// signals sent to the terminal process group
+	// must reach the child only once, through
+	// the agent forwarding them
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start child error:%w", err)
+	}
+	c.Logger.Info("child started", slog.String("cmd", c.Cmd), slog.Int("pid", cmd.Process.Pid))
+
+	exited := make(chan *os.ProcessState, 1)
+	go func() {
+		_ = cmd.Wait()
+		exited <- cmd.ProcessState
+	}()
+	return &running{cmd: cmd, exited: exited}, nil
+}
+
+// stop signals the child with KillSignal and waits
+// for it to exit, the child is killed if it does
+// not exit within KillTimeout
+func (c *Child) stop(proc *running) *os.ProcessState {
+	killSig := cmp.Or(c.KillSignal, syscall.SIGTERM)
+	_ = proc.cmd.Process.Signal(killSig)
+
+	timer := time.NewTimer(cmp.Or(c.KillTimeout, defaultKillTimeout))
+	defer timer.Stop()
+
+	select {
+	case state := <-proc.exited:
+		return state
+	case <-timer.C:
+		c.Logger.Warn("child did not exit in time, killing", slog.Int("pid", proc.cmd.Process.Pid))
+		_ = proc.cmd.Process.Kill()
+		return <-proc.exited
+	}
+}
+
+func (c *Child) environ() ([]string, error) {
+	env := os.Environ()
+	if c.EnvFile == "" {
+		return env, nil
+	}
+	contents, err := os.ReadFile(c.EnvFile)
+	if err != nil {
+		return nil, fmt.Errorf("read env file error:%w", err)
+	}
+	fileEnv, err := parseEnvFile(contents)
+	if err != nil {
+		return nil, fmt.Errorf("parse env file %s:%w", c.EnvFile, err)
+	}
+	return append(env, fileEnv...), nil
+}
+
+func exitCode(state *os.ProcessState) int {
+	if state == nil {
+		return 1
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
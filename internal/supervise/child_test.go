@@ -0,0 +1,123 @@
+package supervise
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestChild(script string) *Child {
+	return &Child{
+		Cmd:         "sh",
+		Args:        []string{"-c", script},
+		KillTimeout: 2 * time.Second,
+		Logger:      slog.New(slog.NewTextHandler(os.Stdout, nil)),
+	}
+}
+
+func waitForFile(t *testing.T, path string, contains string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		bs, _ := os.ReadFile(path)
+		if strings.Contains(string(bs), contains) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %q in %s", contains, path)
+}
+
+func TestChild_Run(t *testing.T) {
+	t.Run("exit code is returned", func(t *testing.T) {
+		child := newTestChild("exit 7")
+		code, err := child.Run(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != 7 {
+			t.Errorf("expected exit code 7 got %d", code)
+		}
+	})
+
+	t.Run("env file is injected", func(t *testing.T) {
+		tmp := t.TempDir()
+		envFile := filepath.Join(tmp, "app.env")
+		contents := "# comment\nexport NAME=\"foo bar\"\nLEVEL=debug\n"
+		if err := os.WriteFile(envFile, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		child := newTestChild(`[ "$NAME" = "foo bar" ] && [ "$LEVEL" = debug ]`)
+		child.EnvFile = envFile
+		code, err := child.Run(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != 0 {
+			t.Errorf("expected env to be set, exit code %d", code)
+		}
+	})
+
+	t.Run("change signal is delivered", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		child := newTestChild(`trap 'echo hup >> ` + out + `; exit 0' HUP; echo ready > ` + out + `; while true; do sleep 0.05; done`)
+		child.ChangeSignal = syscall.SIGHUP
+
+		done := make(chan int, 1)
+		go func() {
+			code, _ := child.Run(context.Background(), nil)
+			done <- code
+		}()
+
+		waitForFile(t, out, "ready")
+		child.Changed(false)
+		waitForFile(t, out, "hup")
+		if code := <-done; code != 0 {
+			t.Errorf("expected exit code 0 got %d", code)
+		}
+	})
+
+	t.Run("restart on change", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		child := newTestChild(`echo start >> ` + out + `; while true; do sleep 0.05; done`)
+		child.ChangeSignal = syscall.SIGHUP
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan int, 1)
+		go func() {
+			code, _ := child.Run(ctx, nil)
+			done <- code
+		}()
+
+		waitForFile(t, out, "start")
+		child.Changed(true)
+		waitForFile(t, out, "start\nstart")
+		cancel()
+		if code := <-done; code != 128+int(syscall.SIGTERM) {
+			t.Errorf("expected exit code %d got %d", 128+int(syscall.SIGTERM), code)
+		}
+	})
+
+	t.Run("signals are forwarded", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "out")
+		child := newTestChild(`trap 'exit 5' USR1; echo ready > ` + out + `; while true; do sleep 0.05; done`)
+
+		sigs := make(chan os.Signal, 1)
+		done := make(chan int, 1)
+		go func() {
+			code, _ := child.Run(context.Background(), sigs)
+			done <- code
+		}()
+
+		waitForFile(t, out, "ready")
+		sigs <- syscall.SIGUSR1
+		if code := <-done; code != 5 {
+			t.Errorf("expected exit code 5 got %d", code)
+		}
+	})
+}
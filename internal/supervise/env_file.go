@@ -0,0 +1,76 @@
+package supervise
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// parseEnvFile reads KEY=VALUE lines, blank lines and
+// lines starting with # are skipped, an export prefix
+// is removed and the value is unquoted like a shell does
+func parseEnvFile(contents []byte) ([]string, error) {
+	var env []string
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d:expected KEY=VALUE", lineNum)
+		}
+		value, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d:%w", lineNum, err)
+		}
+		env = append(env, fmt.Sprintf("%s=%s", strings.TrimSpace(key), value))
+	}
+	return env, scanner.Err()
+}
+
+// unquote joins the quoted and unquoted segments of value,
+// single quoted segments are kept as is, a backslash escapes
+// the next byte outside quotes and only \\, \", \$ and \`
+// inside double quotes, a quote escaped for a single
+// quoted string by closing and reopening it is read back
+func unquote(value string) (string, error) {
+	var sb strings.Builder
+	sb.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\':
+			if i+1 < len(value) {
+				i++
+			}
+			sb.WriteByte(value[i])
+		case '\'':
+			end := strings.IndexByte(value[i+1:], '\'')
+			if end < 0 {
+				return "", errors.New("unterminated single quote")
+			}
+			sb.WriteString(value[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			i++
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) && strings.IndexByte("\\\"$`", value[i+1]) >= 0 {
+					i++
+				}
+				sb.WriteByte(value[i])
+			}
+			if i == len(value) {
+				return "", errors.New("unterminated double quote")
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
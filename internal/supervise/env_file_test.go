@@ -0,0 +1,77 @@
+package supervise
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/shubhang93/tplagent/internal/escape"
+)
+
+func Test_parseEnvFile(t *testing.T) {
+	tests := map[string]struct {
+		contents string
+		expected []string
+		wantErr  bool
+	}{
+		"plain and quoted values": {
+			contents: "# comment\nexport A=plain\nB=\"double quoted\"\nC='single quoted'\n",
+			expected: []string{"A=plain", "B=double quoted", "C=single quoted"},
+		},
+		"adjacent segments": {
+			contents: `A='it'\''s'` + "\n" + `B="say \"hi\" to \$USER"'!'` + "\n" + `C=a\ b`,
+			expected: []string{"A=it's", `B=say "hi" to $USER!`, "C=a b"},
+		},
+		"backslash inside single quotes": {
+			contents: `A='C:\dir'`,
+			expected: []string{`A=C:\dir`},
+		},
+		"unterminated quote": {
+			contents: `A='abc`,
+			wantErr:  true,
+		},
+		"missing key": {
+			contents: "=abc",
+			wantErr:  true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			env, err := parseEnvFile([]byte(tt.contents))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v got %v", tt.wantErr, err)
+				return
+			}
+			if diff := cmp.Diff(tt.expected, env); diff != "" {
+				t.Errorf("(--Want ++Got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("values escaped for shell are read back", func(t *testing.T) {
+		values := []string{"it's", `'\''`, `say "hi" $HOME`, "back`tick`", `C:\dir\`, "", "a b  c"}
+
+		tmpl := template.Must(template.New("env").Funcs(escape.Funcs()).Parse(
+			"{{range .}}export SINGLE={{.}}\nDOUBLE=\"{{.}}\"\n{{end}}"))
+		if err := escape.Rewrite(tmpl, escape.Shell); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range values {
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, []string{v}); err != nil {
+				t.Fatal(err)
+			}
+			env, err := parseEnvFile(buf.Bytes())
+			if err != nil {
+				t.Errorf("%q:%v", buf.String(), err)
+				continue
+			}
+			expected := []string{"SINGLE=" + v, "DOUBLE=" + v}
+			if diff := cmp.Diff(expected, env); diff != "" {
+				t.Errorf("%q (--Want ++Got):\n%s", buf.String(), diff)
+			}
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package tplactions
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -26,3 +27,13 @@ type Interface interface {
 	SetLogger(logger *slog.Logger)
 	Close()
 }
+
+// Watcher is an optional interface
+// actions can implement to push change
+// notifications to the agent, Watch
+// should block until ctx is done and
+// call notify whenever the data
+// served by the action changes
+type Watcher interface {
+	Watch(ctx context.Context, notify func()) error
+}